@@ -0,0 +1,552 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"ossia/filter"
+	"ossia/models"
+	"ossia/scheduler"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/asdine/storm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Alert rules are evaluated after every poll of their resource and every
+// usage snapshot. The inventory does not change in between, so pending
+// alerts are promoted by the periodic alerts task without querying it.
+// Alerts are kept in memory, they are evaluated again after a restart
+
+// capacityResource is the rule resource evaluating the usage
+// of the aggregates and the whole deployment
+const capacityResource = "capacity"
+
+// channelPrefix prefixes the webhook names of the alert channels
+const channelPrefix = "channel:"
+
+// commandTimeout is the default timeout of command channels
+const commandTimeout = 30 * time.Second
+
+// alertState keeps the pending, firing and recently resolved alerts by ID
+var alertState = struct {
+	sync.Mutex
+	alerts map[string]*models.Alert
+}{
+	alerts: make(map[string]*models.Alert),
+}
+
+// alertObject is the object matching the rule
+type alertObject struct {
+	id        string
+	name      string
+	projectID string
+	value     interface{}
+}
+
+// validateAlerts checks the rules and their channels
+func validateAlerts(config *models.Configuration) error {
+	names := make(map[string]bool, len(config.Alerts.Rules))
+	for _, rule := range config.Alerts.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("alerts.rules: name is required")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("alerts.rules %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		model := reflect.TypeOf(models.Capacity{})
+		if c, ok := getCollector(rule.Resource); ok {
+			model = reflect.TypeOf(c.Model())
+		} else if rule.Resource != capacityResource {
+			return fmt.Errorf("alerts.rules %s: unknown resource %s", rule.Name, rule.Resource)
+		}
+		expr, err := filter.Parse(rule.Condition)
+		if err != nil {
+			return fmt.Errorf("alerts.rules %s: %v", rule.Name, err)
+		}
+		for _, cmp := range filter.Comparisons(expr) {
+			if !validPath(model, cmp.Path) {
+				return fmt.Errorf("alerts.rules %s: invalid condition field %s", rule.Name, cmp.Path)
+			}
+		}
+		if _, err := template.New(rule.Name).Parse(rule.Summary); err != nil {
+			return fmt.Errorf("alerts.rules %s: %v", rule.Name, err)
+		}
+		for _, channel := range rule.Channels {
+			if _, ok := config.Alerts.Channels[channel]; !ok {
+				return fmt.Errorf("alerts.rules %s: unknown channel %s", rule.Name, channel)
+			}
+		}
+	}
+
+	for name, channel := range config.Alerts.Channels {
+		switch channel.Type {
+		case "webhook":
+			err := validateWebhook(models.Webhook{Name: name, URL: channel.URL, Events: []string{"alert.*"}})
+			if err != nil {
+				return fmt.Errorf("alerts.channels %s: %v", name, err)
+			}
+		case "smtp":
+			if len(channel.To) == 0 || config.SMTP.Host == "" {
+				return fmt.Errorf("alerts.channels %s: to and smtp.host are required", name)
+			}
+		case "command":
+			if channel.Command == "" {
+				return fmt.Errorf("alerts.channels %s: command is required", name)
+			}
+		default:
+			return fmt.Errorf("alerts.channels %s: unknown type %s", name, channel.Type)
+		}
+	}
+	return nil
+}
+
+// getAlertRule returns the rule by name
+func getAlertRule(name string) (models.AlertRule, bool) {
+	for _, rule := range Cfg().Alerts.Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return models.AlertRule{}, false
+}
+
+// ruleApplies is true if the rule is evaluated for the deployment after
+// the poll of the resources, all rules are evaluated without resources
+func ruleApplies(rule models.AlertRule, deployment string, resources []string) bool {
+	if len(rule.Deployments) > 0 && !matchAny(rule.Deployments, deployment) {
+		return false
+	}
+	if len(resources) == 0 || contains(resources, rule.Resource) {
+		return true
+	}
+	return rule.Resource == capacityResource && (contains(resources, "hypervisors") || contains(resources, "aggregates"))
+}
+
+// evaluateAlerts evaluates the rules of the deployment. Objects matching
+// a rule become pending alerts, firing alerts resolve once they stop matching.
+// The Inventory is queried before locking the alerts
+func evaluateAlerts(deployment string, resources ...string) {
+	now := time.Now()
+
+	type evaluation struct {
+		rule    models.AlertRule
+		objects []alertObject
+	}
+	var evaluations []evaluation
+	for _, rule := range Cfg().Alerts.Rules {
+		if !ruleApplies(rule, deployment, resources) {
+			continue
+		}
+		objects, err := ruleObjects(deployment, rule)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"deployment": deployment,
+				"rule":       rule.Name,
+				"error":      err,
+			}).Error("Unable to evaluate alert rule")
+			continue
+		}
+		evaluations = append(evaluations, evaluation{rule, objects})
+	}
+
+	alertState.Lock()
+	var notify []models.Alert
+	for _, e := range evaluations {
+		rule, objects := e.rule, e.objects
+		matched := make(map[string]bool, len(objects))
+		for _, o := range objects {
+			id := strings.Join([]string{rule.Name, deployment, rule.Resource, o.id}, "/")
+			matched[id] = true
+
+			a, ok := alertState.alerts[id]
+			if !ok || a.State == models.AlertResolved {
+				a = &models.Alert{
+					ID:         id,
+					Rule:       rule.Name,
+					Severity:   rule.Severity,
+					State:      models.AlertPending,
+					Deployment: deployment,
+					Resource:   rule.Resource,
+					ObjectID:   o.id,
+					Name:       o.name,
+					ProjectID:  o.projectID,
+					Since:      now,
+				}
+				alertState.alerts[id] = a
+			}
+			a.Name = o.name
+			a.Summary = alertSummary(rule, *a, o.value)
+		}
+
+		for id, a := range alertState.alerts {
+			if a.Rule != rule.Name || a.Deployment != deployment || matched[id] {
+				continue
+			}
+			switch a.State {
+			case models.AlertPending:
+				delete(alertState.alerts, id)
+			case models.AlertFiring:
+				a.State = models.AlertResolved
+				a.Resolved = now
+				notify = append(notify, *a)
+			}
+		}
+	}
+	notify = append(notify, promoteAlerts(now)...)
+	alertState.Unlock()
+
+	notifyAlerts(notify)
+}
+
+// scheduleAlerts registers the periodic check of the pending alerts,
+// removed if the interval is not set
+func scheduleAlerts() {
+	interval := Cfg().Alerts.Interval
+	if interval <= 0 {
+		scheduler.RemoveTask("alerts")
+		return
+	}
+	scheduler.AddTask(scheduler.Task{
+		Name:     "alerts",
+		Resource: "alerts",
+		Schedule: fmt.Sprintf("@every %s", interval),
+		Run:      func() error { checkAlerts(); return nil },
+	})
+}
+
+// checkAlerts fires the pending alerts matching for the rule duration
+// and drops the expired resolved ones
+func checkAlerts() {
+	alertState.Lock()
+	notify := promoteAlerts(time.Now())
+	alertState.Unlock()
+
+	notifyAlerts(notify)
+}
+
+// promoteAlerts fires the pending alerts and returns them. Alerts of
+// removed rules and deployments are dropped. alertState must be locked
+func promoteAlerts(now time.Time) []models.Alert {
+	var fired []models.Alert
+	for id, a := range alertState.alerts {
+		rule, ok := getAlertRule(a.Rule)
+		if !ok || !ruleApplies(rule, a.Deployment, nil) || !deploymentRegistered(a.Deployment) {
+			delete(alertState.alerts, id)
+			continue
+		}
+		switch {
+		case a.State == models.AlertPending && now.Sub(a.Since) >= rule.For:
+			a.State = models.AlertFiring
+			a.Fired = now
+			fired = append(fired, *a)
+		case a.State == models.AlertResolved && now.Sub(a.Resolved) >= Cfg().Alerts.KeepResolved:
+			delete(alertState.alerts, id)
+		}
+	}
+	return fired
+}
+
+// listAlerts returns the alerts, the oldest first
+func listAlerts() []models.Alert {
+	alertState.Lock()
+	defer alertState.Unlock()
+
+	alerts := make([]models.Alert, 0, len(alertState.alerts))
+	for _, a := range alertState.alerts {
+		alerts = append(alerts, *a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].Since.Equal(alerts[j].Since) {
+			return alerts[i].Since.Before(alerts[j].Since)
+		}
+		return alerts[i].ID < alerts[j].ID
+	})
+	return alerts
+}
+
+// ruleObjects returns the objects of the deployment matching the rule
+func ruleObjects(deployment string, rule models.AlertRule) ([]alertObject, error) {
+	expr, err := filter.Parse(rule.Condition)
+	if err != nil {
+		return nil, err
+	}
+	r := newResolver(deployment)
+
+	var objects []alertObject
+	if rule.Resource == capacityResource {
+		for _, c := range deploymentCapacity(deployment) {
+			v := reflect.ValueOf(c)
+			if expr.Match(func(path string) []interface{} { return r.values(v, path) }) {
+				objects = append(objects, alertObject{id: c.ID, name: c.Name, value: c})
+			}
+		}
+		return objects, nil
+	}
+
+	c, ok := getCollector(rule.Resource)
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %s", rule.Resource)
+	}
+	items := c.Model()
+	err = DB.From(deployment).Select(r.matcher(expr)).Find(items)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(items).Elem()
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		o := alertObject{
+			id:    item.Addr().Interface().(models.Resource).Key(),
+			value: item.Interface(),
+		}
+		if names := leafValues(item); len(names) > 0 {
+			o.name = fmt.Sprint(names[0])
+		}
+		if p := item.FieldByName("ProjectID"); p.IsValid() {
+			o.projectID = p.String()
+		} else if rule.Resource == "projects" {
+			o.projectID = o.id
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// deploymentCapacity returns the usage of the aggregates
+// and the whole deployment
+func deploymentCapacity(deployment string) []models.Capacity {
+	var (
+		hypervisors []models.Hypervisor
+		aggregates  []models.Aggregate
+	)
+	bucket := DB.From(deployment)
+	err := bucket.All(&hypervisors)
+	if err != nil {
+		log.WithFields(log.Fields{"deployment": deployment}).Error(err)
+	}
+	err = bucket.All(&aggregates)
+	if err != nil {
+		log.WithFields(log.Fields{"deployment": deployment}).Error(err)
+	}
+
+	hosts := make(map[string]models.Hypervisor, len(hypervisors))
+	for _, h := range hypervisors {
+		hosts[h.Hostname] = h
+	}
+
+	capacity := make([]models.Capacity, 0, len(aggregates)+1)
+	for _, a := range aggregates {
+		c := models.Capacity{ID: fmt.Sprint(a.ID), Name: a.Name, Type: "aggregate"}
+		for _, host := range a.Hosts {
+			if h, ok := hosts[host]; ok {
+				addCapacity(&c, h)
+			}
+		}
+		capacity = append(capacity, withUsage(c))
+	}
+
+	total := models.Capacity{ID: deployment, Name: deployment, Type: "deployment"}
+	for _, h := range hypervisors {
+		addCapacity(&total, h)
+	}
+	return append(capacity, withUsage(total))
+}
+
+// addCapacity adds the hypervisor if it is enabled and up
+func addCapacity(c *models.Capacity, h models.Hypervisor) {
+	if h.Status != "enabled" || h.State != "up" {
+		return
+	}
+	c.Hypervisors++
+	c.VCPUs += h.VCPUs
+	c.VCPUsUsed += h.VCPUsUsed
+	c.MemoryMB += h.TotalRAMMB
+	c.MemoryUsedMB += h.TotalRAMMB - h.FreeRAMMB
+	c.DiskGB += h.TotalDiskGB
+	c.DiskUsedGB += h.TotalDiskGB - h.FreeDiskGB
+}
+
+// withUsage sets the usage percents
+func withUsage(c models.Capacity) models.Capacity {
+	c.VCPUUsage = usagePercent(c.VCPUsUsed, c.VCPUs)
+	c.MemoryUsage = usagePercent(c.MemoryUsedMB, c.MemoryMB)
+	c.DiskUsage = usagePercent(c.DiskUsedGB, c.DiskGB)
+	return c
+}
+
+// usagePercent returns the percent rounded to two decimals
+func usagePercent(used int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(used)*10000/float64(total)) / 100
+}
+
+// alertSummary renders the summary template of the rule with
+// the alert fields and the matching Object
+func alertSummary(rule models.AlertRule, a models.Alert, object interface{}) string {
+	if rule.Summary == "" {
+		return fmt.Sprintf("%s %s of %s matches %s", strings.TrimSuffix(a.Resource, "s"), a.Name, a.Deployment, rule.Condition)
+	}
+
+	var summary bytes.Buffer
+	t, err := template.New(rule.Name).Parse(rule.Summary)
+	if err == nil {
+		err = t.Execute(&summary, struct {
+			models.Alert
+			Object interface{}
+		}{a, object})
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"rule":  rule.Name,
+			"error": err,
+		}).Error("Unable to render alert summary")
+		return rule.Summary
+	}
+	return summary.String()
+}
+
+// notifyAlerts sends the firing and resolved alerts to the channels of their rules
+func notifyAlerts(alerts []models.Alert) {
+	for i := range alerts {
+		a := alerts[i]
+		log.WithFields(log.Fields{
+			"rule":       a.Rule,
+			"deployment": a.Deployment,
+			"object":     a.Name,
+			"state":      a.State,
+		}).Warn("Alert " + a.State)
+
+		rule, _ := getAlertRule(a.Rule)
+		for _, name := range rule.Channels {
+			channel, ok := Cfg().Alerts.Channels[name]
+			if !ok {
+				continue
+			}
+			switch channel.Type {
+			case "webhook":
+				w, _ := channelWebhook(channelPrefix + name)
+				queueWebhook(w, models.WebhookPayload{
+					Event:      "alert." + a.State,
+					Deployment: a.Deployment,
+					Alert:      &a,
+				})
+			case "smtp":
+				go mailAlert(name, channel, a)
+			case "command":
+				go runAlertCommand(name, channel, a)
+			}
+		}
+	}
+}
+
+// channelWebhook returns the webhook of the alert channel, named with
+// channelPrefix. Its deliveries are retried and dead-lettered as the
+// webhooks ones
+func channelWebhook(webhook string) (models.Webhook, error) {
+	channel, ok := Cfg().Alerts.Channels[strings.TrimPrefix(webhook, channelPrefix)]
+	if !ok || channel.Type != "webhook" || !strings.HasPrefix(webhook, channelPrefix) {
+		return models.Webhook{}, errWebhookMissing
+	}
+	return models.Webhook{
+		Name:   webhook,
+		URL:    channel.URL,
+		Secret: channel.Secret,
+		Source: models.WebhookFromConfig,
+	}, nil
+}
+
+// mailAlert sends the alert to the recipients of the channel
+func mailAlert(name string, channel models.AlertChannel, a models.Alert) {
+	state := strings.ToUpper(a.State)
+	if a.Severity != "" {
+		state += " " + a.Severity
+	}
+	subject := fmt.Sprintf("[%s] %s: %s %s (%s)", strings.ToUpper(AppName), state, a.Rule, a.Name, a.Deployment)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", a.Summary)
+	fmt.Fprintf(&body, "Rule:       %s\n", a.Rule)
+	fmt.Fprintf(&body, "Severity:   %s\n", a.Severity)
+	fmt.Fprintf(&body, "State:      %s\n", a.State)
+	fmt.Fprintf(&body, "Deployment: %s\n", a.Deployment)
+	fmt.Fprintf(&body, "Object:     %s %s (%s)\n", a.Resource, a.Name, a.ObjectID)
+	fmt.Fprintf(&body, "Since:      %s\n", a.Since.Format(time.RFC3339))
+	if !a.Fired.IsZero() {
+		fmt.Fprintf(&body, "Fired:      %s\n", a.Fired.Format(time.RFC3339))
+	}
+	if !a.Resolved.IsZero() {
+		fmt.Fprintf(&body, "Resolved:   %s\n", a.Resolved.Format(time.RFC3339))
+	}
+
+	err := sendMail(channel.To, subject, "text/plain", body.String())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"channel": name,
+			"rule":    a.Rule,
+			"error":   err,
+		}).Error("Unable to send alert mail")
+	}
+}
+
+// runAlertCommand runs the command of the channel with the alert as JSON
+// on the standard input and in OSSIA_ALERT_* environment variables
+func runAlertCommand(name string, channel models.AlertChannel, a models.Alert) {
+	timeout := channel.Timeout
+	if timeout == 0 {
+		timeout = commandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	input, err := json.Marshal(a)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	cmd := exec.CommandContext(ctx, channel.Command, channel.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"OSSIA_ALERT_RULE="+a.Rule,
+		"OSSIA_ALERT_STATE="+a.State,
+		"OSSIA_ALERT_SEVERITY="+a.Severity,
+		"OSSIA_ALERT_DEPLOYMENT="+a.Deployment,
+		"OSSIA_ALERT_NAME="+a.Name,
+		"OSSIA_ALERT_SUMMARY="+a.Summary,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"channel": name,
+			"rule":    a.Rule,
+			"output":  strings.TrimSpace(string(output)),
+			"error":   err,
+		}).Error("Alert command failed")
+	}
+}
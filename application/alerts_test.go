@@ -0,0 +1,49 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"strings"
+	"testing"
+
+	"ossia/models"
+)
+
+func TestValidateAlerts(t *testing.T) {
+	tests := []struct {
+		name string
+		rule models.AlertRule
+		err  string
+	}{
+		{"capacity", models.AlertRule{Resource: "capacity", Condition: "type==aggregate and vcpu_usage>85"}, ""},
+		{"hypervisors", models.AlertRule{Resource: "hypervisors", Condition: "state!=up"}, ""},
+		{"instances", models.AlertRule{Resource: "instances", Condition: "status==ERROR and flavor.vcpus>4"}, ""},
+		{"unknown resource", models.AlertRule{Resource: "volumes", Condition: "status==error"}, "unknown resource volumes"},
+		{"syntax", models.AlertRule{Resource: "instances", Condition: "status=="}, "alerts.rules syntax"},
+		{"unknown field", models.AlertRule{Resource: "instances", Condition: "sttaus==ERROR"}, "invalid condition field sttaus"},
+		{"capacity field", models.AlertRule{Resource: "capacity", Condition: "vcpu_usage>85 and quota>90"}, "invalid condition field quota"},
+		{"field of another resource", models.AlertRule{Resource: "hypervisors", Condition: "status==ERROR and flavor.vcpus>4"}, "invalid condition field flavor.vcpus"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			config := &models.Configuration{Alerts: models.Alerts{Rules: []models.AlertRule{tt.rule}}}
+			err := validateAlerts(config)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("validateAlerts() = %v, want nil", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("validateAlerts() = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
@@ -12,11 +12,15 @@ governing permissions and limitations under the License.
 package application
 
 import (
+	"context"
+	"sync"
+
 	"ossia/models"
 	"ossia/openstack"
 
 	"github.com/gophercloud/gophercloud"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 // App is application object
@@ -36,132 +40,231 @@ type APIConnection struct {
 var (
 	//Connection is map of Initialized APIs
 	Connection = make(map[string]APIConnection)
+
+	// connectionMu guards Connection, connectionLocks
+	// serialize API Connection setup per deployment
+	connectionMu    sync.Mutex
+	connectionLocks = make(map[string]*sync.Mutex)
+
+	// breakers keeps Circuit Breaker per deployment
+	breakers   = make(map[string]*openstack.Breaker)
+	breakersMu sync.Mutex
+
+	// limiters keeps API rate limiter per deployment
+	limiters   = make(map[string]*rate.Limiter)
+	limitersMu sync.Mutex
+
+	// pollers limits concurrent update tasks
+	pollers     chan struct{}
+	pollersOnce sync.Once
 )
 
+// clientOptions builds OpenStack HTTP Client options
+// from the application configuration
+func clientOptions(deploymentName string) openstack.ClientOptions {
+	return openstack.ClientOptions{
+		Timeout: Cfg().APIClient.Timeout,
+		Limiter: limiter(deploymentName),
+	}
+}
+
+// limiter returns API rate limiter of the deployment.
+// Nova and Keystone clients share the same limiter. The
+// deployment rate_limit and burst override the api_client ones
+func limiter(deploymentName string) *rate.Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l, ok := limiters[deploymentName]
+	if !ok {
+		config := Cfg()
+		d := config.Deployments[deploymentName]
+		rateLimit, burst := config.APIClient.RateLimit, config.APIClient.Burst
+		if d.RateLimit > 0 {
+			rateLimit = d.RateLimit
+		}
+		if d.Burst > 0 {
+			burst = d.Burst
+		}
+		l = openstack.NewLimiter(rateLimit, burst)
+		limiters[deploymentName] = l
+	}
+	return l
+}
+
+// acquirePoller blocks until the update task may run
+// and returns the function releasing the slot. False is
+// returned if ctx is done before the slot is available
+func acquirePoller(ctx context.Context) (func(), bool) {
+	pollersOnce.Do(func() {
+		if max := Cfg().APIClient.MaxPollers; max > 0 {
+			pollers = make(chan struct{}, max)
+		}
+	})
+	if pollers == nil {
+		return func() {}, true
+	}
+	select {
+	case pollers <- struct{}{}:
+		return func() { <-pollers }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// retryOptions builds OpenStack API retry options
+// from the application configuration
+func retryOptions() openstack.RetryOptions {
+	client := Cfg().APIClient
+	return openstack.RetryOptions{
+		Retries:    client.Retries,
+		Backoff:    client.Backoff,
+		MaxBackoff: client.MaxBackoff,
+	}
+}
+
+// Breaker returns Circuit Breaker of the deployment
+func Breaker(deploymentName string) *openstack.Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b, ok := breakers[deploymentName]
+	if !ok {
+		breaker := Cfg().APIClient.Breaker
+		b = openstack.NewBreaker(breaker.Threshold, breaker.Cooldown)
+		breakers[deploymentName] = b
+	}
+	return b
+}
+
+// breakerStatus returns Circuit Breaker state of the
+// deployments visible to the caller
+func breakerStatus(a *access) map[string]openstack.BreakerStatus {
+	status := make(map[string]openstack.BreakerStatus)
+	for deployment := range Cfg().Deployments {
+		if a.canRead(deployment) {
+			status[deployment] = Breaker(deployment).Status()
+		}
+	}
+	return status
+}
+
 // Nova establishes Nova API Connection
 func Nova(deploymentName string) *gophercloud.ServiceClient {
-	deployment := Cfg.Deployments[deploymentName]
-	value, ok := Connection[deploymentName]
-	var (
-		err error
-		tmp = Connection[deploymentName]
-	)
-
-	// Some CNX for deploymentName initialized
-	if ok {
-		if value.Nova != nil {
-			err = value.Nova.Reauthenticate(value.Token)
-			if err != nil {
-				log.Error(err)
-				return nil
-			}
-			tmp.Token = Connection[deploymentName].Nova.TokenID
-			tmp.Nova = value.Nova
-			tmp.Keystone = value.Keystone
-			Connection[deploymentName] = tmp
-			return Connection[deploymentName].Nova
-		}
-		cnx := openstack.ComputeConnection(deployment.OsAuthURL, deployment.OsUsername, deployment.OsPassword, deployment.OsProjectName)
-		if cnx == nil {
+	defer lockConnection(deploymentName)()
+
+	deployment := Cfg().Deployments[deploymentName]
+	value := getConnection(deploymentName)
+
+	// Nova API for deploymentName is initialized
+	if value.Nova != nil {
+		err := value.Nova.Reauthenticate(value.Token)
+		if err != nil {
+			log.Error(err)
 			return nil
 		}
-		Connection[deploymentName] = APIConnection{
-			Nova:     cnx,
-			Keystone: nil,
-			Token:    "",
-		}
-		tmp.Nova = Connection[deploymentName].Nova
-		tmp.Keystone = value.Keystone
-		tmp.Token = Connection[deploymentName].Nova.TokenID
-		Connection[deploymentName] = tmp
-		return Connection[deploymentName].Nova
+		value.Token = value.Nova.TokenID
+		setConnection(deploymentName, value)
+		return value.Nova
 	}
 
-	// No CNX for deploymentName. Establish both connections
 	log.WithFields(log.Fields{
 		"deployment": deploymentName,
 		"AuthUrl":    deployment.OsAuthURL,
 	}).Debug("Making Compute Connection")
 
-	cnx := openstack.ComputeConnection(deployment.OsAuthURL, deployment.OsUsername, deployment.OsPassword, deployment.OsProjectName)
+	cnx := openstack.ComputeConnection(deployment.OsAuthURL, deployment.OsUsername, deployment.OsPassword, deployment.OsProjectName, clientOptions(deploymentName))
 	if cnx == nil {
 		return nil
 	}
-	Connection[deploymentName] = APIConnection{
-		Nova:     cnx,
-		Keystone: nil,
-		Token:    "",
-	}
-	tmp.Token = Connection[deploymentName].Nova.TokenID
-	tmp.Nova = Connection[deploymentName].Nova
-	Connection[deploymentName] = tmp
-	return Connection[deploymentName].Nova
+	value.Nova = cnx
+	value.Token = cnx.TokenID
+	setConnection(deploymentName, value)
+	return value.Nova
 
 }
 
 // Keystone establishes Nova API Connection
 func Keystone(deploymentName string) *gophercloud.ServiceClient {
-	deployment := Cfg.Deployments[deploymentName]
-	value, ok := Connection[deploymentName]
-	var (
-		err error
-		tmp = Connection[deploymentName]
-	)
-
-	// Some API's for deploymentName are initialized
-	if ok {
-		if value.Keystone != nil {
-			err = value.Keystone.Reauthenticate(value.Token)
-			if err != nil {
-				log.Error(err)
-				return nil
-			}
-			tmp.Token = Connection[deploymentName].Keystone.TokenID
-			tmp.Nova = value.Nova
-			tmp.Keystone = value.Keystone
-			Connection[deploymentName] = tmp
-			return Connection[deploymentName].Keystone
-		}
-		cnx := openstack.IdentityConnection(deployment.OsAuthURL, deployment.OsUsername, deployment.OsPassword, deployment.OsProjectName)
-		if cnx == nil {
+	defer lockConnection(deploymentName)()
+
+	deployment := Cfg().Deployments[deploymentName]
+	value := getConnection(deploymentName)
+
+	// Keystone API for deploymentName is initialized
+	if value.Keystone != nil {
+		err := value.Keystone.Reauthenticate(value.Token)
+		if err != nil {
+			log.Error(err)
 			return nil
 		}
-		Connection[deploymentName] = APIConnection{
-			Keystone: cnx,
-			Nova:     nil,
-			Token:    "",
-		}
-		tmp.Nova = value.Nova
-		tmp.Keystone = Connection[deploymentName].Keystone
-		tmp.Token = Connection[deploymentName].Keystone.TokenID
-		Connection[deploymentName] = tmp
-		return Connection[deploymentName].Keystone
+		value.Token = value.Keystone.TokenID
+		setConnection(deploymentName, value)
+		return value.Keystone
 	}
 
-	// No CNX for deploymentName. Establish both connections
 	log.WithFields(log.Fields{
 		"deployment": deploymentName,
 		"AuthUrl":    deployment.OsAuthURL,
 	}).Debug("Making Keystone Connection")
 
-	cnx := openstack.IdentityConnection(deployment.OsAuthURL, deployment.OsUsername, deployment.OsPassword, deployment.OsProjectName)
+	cnx := openstack.IdentityConnection(deployment.OsAuthURL, deployment.OsUsername, deployment.OsPassword, deployment.OsProjectName, clientOptions(deploymentName))
 	if cnx == nil {
 		return nil
 	}
-	Connection[deploymentName] = APIConnection{
-		Keystone: cnx,
-		Nova:     nil,
-		Token:    "",
+	value.Keystone = cnx
+	value.Token = cnx.TokenID
+	setConnection(deploymentName, value)
+	return value.Keystone
+
+}
+
+// lockConnection serializes API Connection setup of the
+// deployment and returns the unlock function
+func lockConnection(deploymentName string) func() {
+	connectionMu.Lock()
+	l, ok := connectionLocks[deploymentName]
+	if !ok {
+		l = &sync.Mutex{}
+		connectionLocks[deploymentName] = l
 	}
-	tmp.Token = Connection[deploymentName].Keystone.TokenID
-	tmp.Keystone = Connection[deploymentName].Keystone
-	Connection[deploymentName] = tmp
-	return Connection[deploymentName].Keystone
+	connectionMu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func getConnection(deploymentName string) APIConnection {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+	return Connection[deploymentName]
+}
+
+func setConnection(deploymentName string, cnx APIConnection) {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+	Connection[deploymentName] = cnx
+}
+
+// resetDeployment drops API Connections, rate limiter and
+// Circuit Breaker of the deployment. They are recreated from
+// the current configuration on the next API call
+func resetDeployment(deploymentName string) {
+	connectionMu.Lock()
+	delete(Connection, deploymentName)
+	connectionMu.Unlock()
+
+	limitersMu.Lock()
+	delete(limiters, deploymentName)
+	limitersMu.Unlock()
 
+	breakersMu.Lock()
+	delete(breakers, deploymentName)
+	breakersMu.Unlock()
 }
 
 // func Keystone(deploymentName string) *gophercloud.ServiceClient {
-// 	deployment := Cfg.Deployments[deploymentName]
+// 	deployment := Cfg().Deployments[deploymentName]
 // 	value, ok := Connection[deploymentName]
 // 	var (
 // 		err error
@@ -202,12 +305,15 @@ func Keystone(deploymentName string) *gophercloud.ServiceClient {
 // functions
 func NewApp() *App {
 
-	config := GetConfig()
+	GetConfig()
 	setupLogger()
-	InitDB(Cfg.Database)
+	InitDB(Cfg().Database)
+	reindexProjects()
+	loadManagedDeployments()
+	setupAuth(Cfg().Auth)
 
 	app := &App{
-		Config: config,
+		Config: Cfg(),
 	}
 
 	return app
@@ -0,0 +1,53 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"testing"
+
+	"ossia/models"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimiterOverride(t *testing.T) {
+	useTestConfig(t, &models.Configuration{
+		APIClient: models.APIClient{RateLimit: 10, Burst: 5},
+		Deployments: map[string]models.Deployment{
+			"default":  {},
+			"fast":     {RateLimit: 50, Burst: 20},
+			"bursting": {Burst: 10},
+		},
+	})
+
+	tests := []struct {
+		deployment string
+		limit      rate.Limit
+		burst      int
+	}{
+		{"default", 10, 5},
+		{"fast", 50, 20},
+		{"bursting", 10, 10},
+		{"unknown", 10, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.deployment, func(t *testing.T) {
+			resetDeployment(tt.deployment)
+			defer resetDeployment(tt.deployment)
+
+			l := limiter(tt.deployment)
+			if l == nil || l.Limit() != tt.limit || l.Burst() != tt.burst {
+				t.Fatalf("limiter() = %v, want %v/s with burst %d", l, tt.limit, tt.burst)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"ossia/middleware"
+	"ossia/models"
+	"strings"
+	"sync"
+
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// authChain authenticates API requests
+	authChain      []middleware.Authenticator
+	authConfigured bool
+	authMu         sync.RWMutex
+)
+
+// setupAuth builds API authenticators from the configuration
+func setupAuth(config models.Auth) {
+	var chain []middleware.Authenticator
+
+	if len(config.APIKeys) > 0 {
+		var keys []middleware.APIKey
+		for _, k := range config.APIKeys {
+			keys = append(keys, middleware.APIKey{
+				Name:   k.Name,
+				Key:    k.Key,
+				Scopes: k.Scopes,
+				Roles:  k.Roles,
+			})
+		}
+		chain = append(chain, middleware.NewAPIKeyAuthenticator(keys))
+	}
+
+	jwt := config.JWT
+	if jwt.JWKSFile != "" || jwt.Issuer != "" {
+		a, err := middleware.NewJWTAuthenticator(middleware.JWTOptions{
+			JWKSFile:    jwt.JWKSFile,
+			Issuer:      jwt.Issuer,
+			Audience:    jwt.Audience,
+			ScopesClaim: jwt.ScopesClaim,
+			RolesClaim:  jwt.RolesClaim,
+			Leeway:      jwt.Leeway,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"jwks_file": jwt.JWKSFile,
+				"error":     err,
+			}).Error("Unable to load JWKS, JWT authentication is unavailable")
+		} else {
+			chain = append(chain, a)
+		}
+	}
+
+	keystone := config.Keystone
+	if keystone.Enabled {
+		chain = append(chain, middleware.NewKeystoneAuthenticator(middleware.KeystoneOptions{
+			Validate:        validateKeystoneToken,
+			Deployments:     keystoneDeployments,
+			Scopes:          keystone.Scopes,
+			AdminRoles:      keystone.AdminRoles,
+			CacheTTL:        keystone.CacheTTL,
+			NegativeTTL:     keystone.NegativeTTL,
+			CacheSize:       keystone.CacheSize,
+			ValidationRate:  keystone.ValidationRate,
+			ValidationBurst: keystone.ValidationBurst,
+		}))
+	}
+
+	authMu.Lock()
+	defer authMu.Unlock()
+	authChain = chain
+	authConfigured = len(config.APIKeys) > 0 || jwt.JWKSFile != "" || jwt.Issuer != "" || keystone.Enabled
+
+	if !authConfigured {
+		log.Warn("API authentication is not configured, the API is open except admin endpoints")
+	}
+}
+
+// authenticators returns the current authenticators
+func authenticators() []middleware.Authenticator {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	return authChain
+}
+
+// publicRequest is true for requests served without authentication:
+// status, Swagger UI and the whole API if authentication is not configured
+func publicRequest(c iris.Context) bool {
+	path := c.Path()
+	switch {
+	case c.Method() == iris.MethodOptions:
+		return true
+	case path == "/v1/status" || path == "/v2/status":
+		return true
+	case Cfg().Auth.SwaggerUI && (path == "/" || strings.HasPrefix(path, "/assets/")):
+		return true
+	case strings.HasPrefix(path, "/v1/admin"):
+		return false
+	}
+
+	authMu.RLock()
+	defer authMu.RUnlock()
+	return !authConfigured
+}
+
+// requiredScope returns the scope of the API operation
+func requiredScope(c iris.Context) string {
+	switch {
+	case strings.HasPrefix(c.Path(), "/v1/admin"):
+		return middleware.ScopeAdmin
+	case c.Method() == iris.MethodGet || c.Method() == iris.MethodHead:
+		return middleware.ScopeRead
+	}
+	return middleware.ScopeRefresh
+}
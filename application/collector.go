@@ -0,0 +1,337 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"ossia/models"
+	"ossia/openstack"
+	"ossia/utils"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/gophercloud/gophercloud"
+	log "github.com/sirupsen/logrus"
+)
+
+// Collector represents a resource type polled from OpenStack API.
+// Registered collectors are refreshed and scheduled by UpdateInventory
+type Collector interface {
+	// Name of the resource type (tasks, logs and API)
+	Name() string
+	// Dependencies are the collectors to finish before this one
+	Dependencies() []string
+	// Schedule returns the cron spec of the periodic task
+	Schedule(interval models.PollInterval) string
+	// Model returns a pointer to an empty slice of Inventory objects
+	Model() interface{}
+	// Fetch lists the resources from OpenStack API
+	Fetch(ctx context.Context, deployment string) (FetchResult, error)
+	// Reconcile stores fetched resources in the Inventory
+	Reconcile(deployment string, result FetchResult) error
+}
+
+// ObjectCollector is a Collector able to refresh a single object
+type ObjectCollector interface {
+	Collector
+	// NameField is the Inventory field with the object name
+	NameField() string
+	// FetchOne gets the object by its ID from OpenStack API
+	FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error)
+}
+
+// FetchResult is a set of resources returned by the Collector
+type FetchResult struct {
+	// Resources to save in the Inventory
+	Resources []models.Resource
+	// Deleted resources reported by the API
+	Deleted []models.Resource
+	// Complete is set if Resources is the whole collection,
+	// so Inventory objects missing there are deleted
+	Complete bool
+	// PolledAt is the time of the API call
+	PolledAt time.Time
+}
+
+var collectors []Collector
+
+// RegisterCollector adds Collector to the registry
+func RegisterCollector(c Collector) {
+	collectors = append(collectors, c)
+}
+
+// Collectors returns registered collectors
+func Collectors() []Collector {
+	return collectors
+}
+
+// getCollector returns registered Collector by its name
+func getCollector(name string) (Collector, bool) {
+	for _, c := range collectors {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// runCollector fetches the resources of the deployment
+// and reconciles them with the Inventory
+func runCollector(ctx context.Context, c Collector, deployment string) error {
+	defer utils.TimeTrack(time.Now(), c.Fetch)
+
+	releasePoller, ok := acquirePoller(ctx)
+	if !ok {
+		return ctx.Err()
+	}
+	defer releasePoller()
+
+	if !deploymentRegistered(deployment) {
+		return errNotRegistered
+	}
+	if !pollAllowed(deployment, c.Name()) {
+		return openstack.ErrBreakerOpen
+	}
+
+	result, err := c.Fetch(ctx, deployment)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"task":       c.Name(),
+			"error":      err,
+		}).Error("Unable to fetch resources from OpenStack")
+		return err
+	}
+
+	err = c.Reconcile(deployment, result)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"task":       c.Name(),
+		}).Error(err)
+	}
+	return err
+}
+
+// reconcile saves fetched resources and deletes the Inventory objects
+// reported as deleted or missing in the complete result
+func reconcile(deployment string, c Collector, result FetchResult) error {
+	bucket := DB.From(deployment)
+
+	// the first complete sync loads the Inventory of the deployment,
+	// it does not emit an event per object
+	initial := result.Complete && initialSync(deployment, c)
+	var events []models.Event
+	changed := func(old, r models.Resource) {
+		if initial {
+			return
+		}
+		if e, ok := changeEvent(deployment, c, old, r); ok {
+			events = append(events, e)
+		}
+	}
+	defer func() {
+		publishEvents(events)
+		notifyWebhooks(events)
+	}()
+
+	fetched := make(map[string]bool, len(result.Resources))
+	for _, r := range result.Resources {
+		fetched[r.Key()] = true
+		old, _ := loadObject(bucket, c, r.Key())
+		err := bucket.Save(r)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		changed(old, r)
+	}
+
+	for _, r := range result.Deleted {
+		log.Debug("Deleting ", c.Name(), " ", r.Key())
+		old, _ := loadObject(bucket, c, r.Key())
+		err := bucket.DeleteStruct(r)
+		if err != nil && err != storm.ErrNotFound {
+			log.Error(err)
+		}
+		changed(old, nil)
+	}
+
+	var err error
+	if result.Complete {
+		err = cleanupInventory(bucket, c, fetched, changed)
+		if err == nil {
+			state := getPollState(deployment, c.Name())
+			state.LastFullSync = result.PolledAt
+			savePollState(deployment, state)
+		}
+	}
+
+	// the Inventory is up to date, deletions of the cleanup included
+	indexResources(deployment, c, result)
+	touchInventory(deployment, time.Now())
+	if c.Name() == "hypervisors" {
+		checkCapacity(deployment)
+	}
+	evaluateAlerts(deployment, c.Name())
+	return err
+}
+
+// cleanupInventory deletes the stored objects missing
+// from the complete result of the collector
+func cleanupInventory(bucket storm.Node, c Collector, fetched map[string]bool, changed func(old, r models.Resource)) error {
+	inventory := c.Model()
+	err := bucket.All(inventory)
+	if err != nil {
+		return err
+	}
+	items := reflect.ValueOf(inventory).Elem()
+	for i := 0; i < items.Len(); i++ {
+		r := items.Index(i).Addr().Interface().(models.Resource)
+		if !fetched[r.Key()] {
+			log.Debug("Deleting ", c.Name(), " ", r.Key())
+			err := bucket.DeleteStruct(r)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			changed(r, nil)
+		}
+	}
+	return nil
+}
+
+// initialSync is true for the first complete sync of the resource
+// type of the deployment, with none of its objects stored yet
+func initialSync(deployment string, c Collector) bool {
+	if !getPollState(deployment, c.Name()).LastFullSync.IsZero() {
+		return false
+	}
+	model := reflect.New(reflect.TypeOf(c.Model()).Elem().Elem()).Interface()
+	count, err := DB.From(deployment).Count(model)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+		return false
+	}
+	return count == 0
+}
+
+// refreshObject refreshes a single Inventory object referenced
+// by its ID or name. The object is deleted from the Inventory
+// if OpenStack API does not return it anymore. The reference is
+// resolved among the objects allowed to the caller first, unknown
+// IDs are only fetched for callers allowed to see them
+func refreshObject(deployment string, c ObjectCollector, ref objectRef) (models.Resource, error) {
+	defer utils.TimeTrack(time.Now(), refreshObject)
+
+	id, err := resolveObject(deployment, c, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := cycleContext()
+	defer cancel()
+
+	releasePoller, ok := acquirePoller(ctx)
+	if !ok {
+		return nil, ctx.Err()
+	}
+	defer releasePoller()
+
+	if !pollAllowed(deployment, c.Name()) {
+		return nil, openstack.ErrBreakerOpen
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+		"task":       c.Name(),
+		"id":         id,
+	}).Info("Refreshing inventory object")
+
+	r, err := c.FetchOne(ctx, deployment, id)
+	if err != nil {
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			reconcile(deployment, c, FetchResult{
+				Deleted: []models.Resource{emptyResource(c, id)},
+			})
+			return nil, storm.ErrNotFound
+		}
+		return nil, err
+	}
+	if !ref.access.resourceAllowed(deployment, r) {
+		return nil, storm.ErrNotFound
+	}
+
+	err = reconcile(deployment, c, FetchResult{
+		Resources: []models.Resource{r},
+		PolledAt:  time.Now(),
+	})
+	return r, err
+}
+
+// resolveObject returns the ID of the Inventory object matching the
+// reference. The reference itself is used as ID if nothing matches
+// and the caller may see an object with that ID
+func resolveObject(deployment string, c ObjectCollector, ref objectRef) (string, error) {
+	r, err := findObject(deployment, c.Name(), ref)
+	switch {
+	case err == nil:
+		return r.Key(), nil
+	case err != storm.ErrNotFound:
+		return "", err
+	case ref.project != "" || !ref.access.resourceAllowed(deployment, emptyResource(c, ref.name)):
+		return "", storm.ErrNotFound
+	}
+	return ref.name, nil
+}
+
+// loadObject returns the Inventory object by its ID
+func loadObject(bucket storm.Node, c Collector, id string) (models.Resource, error) {
+	r := emptyResource(c, id)
+	if r.Key() != id {
+		return nil, storm.ErrNotFound
+	}
+	err := bucket.One("ID", reflect.ValueOf(r).Elem().FieldByName("ID").Interface(), r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// emptyResource returns the Collector model with only ID set
+func emptyResource(c Collector, id string) models.Resource {
+	r := reflect.New(reflect.TypeOf(c.Model()).Elem().Elem())
+	field := r.Elem().FieldByName("ID")
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(id)
+	case reflect.Int:
+		n, _ := strconv.Atoi(id)
+		field.SetInt(int64(n))
+	}
+	return r.Interface().(models.Resource)
+}
+
+// ambiguousError is returned if the name matches several objects
+type ambiguousError struct {
+	ref        string
+	candidates []string
+	// objects are the matching objects of Inventory lookups
+	objects []models.Resource
+}
+
+func (e *ambiguousError) Error() string {
+	return fmt.Sprintf("%s matches %d objects", e.ref, len(e.candidates))
+}
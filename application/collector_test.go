@@ -0,0 +1,57 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"testing"
+	"time"
+
+	"ossia/models"
+)
+
+func TestReconcileInitialSync(t *testing.T) {
+	openTestDB(t)
+	useTestConfig(t, &models.Configuration{Events: models.Events{BufferSize: 100}})
+
+	published := func() uint64 {
+		eventBus.Lock()
+		defer eventBus.Unlock()
+		return eventBus.next
+	}
+	c := &flavorsCollector{}
+	tests := []struct {
+		name    string
+		flavors []string
+		events  uint64
+	}{
+		{"initial sync", []string{"f1", "f2"}, 0},
+		{"created", []string{"f1", "f2", "f3"}, 1},
+		{"deleted", []string{"f1"}, 2},
+		{"unchanged", []string{"f1"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FetchResult{Complete: true, PolledAt: time.Now()}
+			for _, id := range tt.flavors {
+				result.Resources = append(result.Resources, &models.Flavor{ID: id, Name: id})
+			}
+			before := published()
+			err := reconcile("lab", c, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := published() - before; got != tt.events {
+				t.Errorf("reconcile() published %d events, want %d", got, tt.events)
+			}
+		})
+	}
+}
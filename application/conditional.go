@@ -0,0 +1,213 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	log "github.com/sirupsen/logrus"
+)
+
+// Conditional requests. The Inventory of a deployment changes only
+// when it is polled, so the latest PollTime validates the responses
+// built from it. Validators are computed before the handler runs,
+// unchanged responses are answered 304 without reading the Inventory
+
+// inventoryModified is the latest PollTime per deployment, loaded
+// from the Inventory on first use and advanced by the reconciliation
+var inventoryModified = struct {
+	sync.RWMutex
+	times map[string]time.Time
+}{times: make(map[string]time.Time)}
+
+// validatorsKey is the context key of the response validators
+const validatorsKey = "ossia.validators"
+
+// validators of the response, set on successful responses only
+type validators struct {
+	etag         string
+	lastModified time.Time
+}
+
+// touchInventory advances the modification time of the deployment
+func touchInventory(deployment string, t time.Time) {
+	inventoryModified.Lock()
+	defer inventoryModified.Unlock()
+
+	if t.After(inventoryModified.times[deployment]) {
+		inventoryModified.times[deployment] = t
+	}
+}
+
+// forgetInventory drops the modification time of the deployment
+func forgetInventory(deployment string) {
+	inventoryModified.Lock()
+	defer inventoryModified.Unlock()
+
+	delete(inventoryModified.times, deployment)
+}
+
+// lastModified returns the latest PollTime of the deployment objects
+func lastModified(deployment string) time.Time {
+	inventoryModified.RLock()
+	t, ok := inventoryModified.times[deployment]
+	inventoryModified.RUnlock()
+	if ok {
+		return t
+	}
+
+	for _, c := range collectors {
+		inventory := c.Model()
+		err := DB.From(deployment).All(inventory)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"deployment": deployment,
+				"task":       c.Name(),
+			}).Error(err)
+			continue
+		}
+		items := reflect.ValueOf(inventory).Elem()
+		for i := 0; i < items.Len(); i++ {
+			pollTime, ok := items.Index(i).FieldByName("PollTime").Interface().(time.Time)
+			if ok && pollTime.After(t) {
+				t = pollTime
+			}
+		}
+	}
+	touchInventory(deployment, t)
+	return t
+}
+
+// conditional answers 304 if the client copy of the response is
+// still valid. The deployment of the route or the deployments
+// readable by the caller validate the response
+func conditional(c iris.Context) {
+	if method := c.Method(); method != http.MethodGet && method != http.MethodHead {
+		c.Next()
+		return
+	}
+
+	deployments := readableDeployments(getAccess(c))
+	if deployment := c.Params().Get("deployment"); deployment != "" {
+		if !deploymentRegistered(deployment) {
+			c.Next()
+			return
+		}
+		deployments = []string{deployment}
+	}
+
+	var modified time.Time
+	for _, d := range deployments {
+		if t := lastModified(d); t.After(modified) {
+			modified = t
+		}
+	}
+	if modified.IsZero() {
+		c.Next()
+		return
+	}
+
+	// responses differ per URL, format and caller access
+	h := sha1.New()
+	fmt.Fprintln(h, modified.UnixNano(), c.Request().URL.RequestURI(), c.GetHeader("Accept"))
+	if a := getAccess(c); a != nil {
+		fmt.Fprintln(h, a.roles)
+	}
+	v := validators{
+		etag:         fmt.Sprintf(`W/"%x"`, h.Sum(nil)),
+		lastModified: modified,
+	}
+
+	if notModified(c, v) {
+		c.Header("ETag", v.etag)
+		c.WriteNotModified()
+		return
+	}
+	c.Values().Set(validatorsKey, v)
+	c.Next()
+}
+
+// notModified checks If-None-Match, or If-Modified-Since without it
+func notModified(c iris.Context, v validators) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, etag := range strings.Split(match, ",") {
+			etag = strings.TrimSpace(etag)
+			// weak comparison, see RFC 7232 section 2.3.2
+			if etag == "*" || strings.TrimPrefix(etag, "W/") == strings.TrimPrefix(v.etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	modified, err := c.CheckIfModifiedSince(v.lastModified)
+	return err == nil && !modified
+}
+
+// setValidators adds the validators to successful responses.
+// Clients revalidate the responses, they depend on the caller
+func setValidators(c iris.Context) {
+	v, ok := c.Values().Get(validatorsKey).(validators)
+	if !ok || c.GetStatusCode() != iris.StatusOK {
+		return
+	}
+	c.Header("ETag", v.etag)
+	c.SetLastModified(v.lastModified)
+	c.Header("Cache-Control", "private, no-cache")
+}
+
+// compressEncodings are the encodings offered to the clients
+var compressEncodings = []string{context.BROTLI, context.GZIP}
+
+// acceptsCompression is true if compression is enabled
+// and the client accepts gzip or br
+func acceptsCompression(c iris.Context) bool {
+	if !Cfg().Compression.Enabled {
+		return false
+	}
+	_, err := context.GetEncoding(c.Request(), compressEncodings)
+	return err == nil
+}
+
+// recordResponse buffers the response, so endCompression
+// compresses it if larger than the configured size
+func recordResponse(c iris.Context) {
+	if acceptsCompression(c) {
+		c.Record()
+	}
+}
+
+// endCompression enables the compression of the recorded response
+func endCompression(c iris.Context) {
+	recorder, ok := c.IsRecording()
+	if !ok || len(recorder.Body()) < Cfg().Compression.MinSize {
+		return
+	}
+	compress(c)
+}
+
+// compress enables the compression of the response
+func compress(c iris.Context) {
+	err := c.CompressWriter(true)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": c.Path(),
+		}).Error(err)
+	}
+}
@@ -13,16 +13,43 @@ package application
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"ossia/middleware"
 	"ossia/models"
+	"ossia/scheduler"
 
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-// Cfg is global config object variable
-var Cfg *models.Configuration
+// currentConfig holds the global config object. It is replaced
+// as a whole on reload and never modified in place
+var currentConfig atomic.Value
+
+// Cfg returns the current config. Functions read it once,
+// so a request or a task sees a consistent config
+func Cfg() *models.Configuration {
+	config, _ := currentConfig.Load().(*models.Configuration)
+	return config
+}
+
+// setConfig replaces the current config
+func setConfig(config *models.Configuration) {
+	currentConfig.Store(config)
+}
+
+// reloadMu serializes config reloads
+var reloadMu sync.Mutex
+
+// deploymentName restricts deployment names used in URLs and DB buckets
+var deploymentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
 
 // GetConfig is returning config object
 func GetConfig() *models.Configuration {
@@ -33,24 +60,274 @@ func GetConfig() *models.Configuration {
 	viper.AddConfigPath("/etc/ossia/")
 	viper.AddConfigPath("/opt/ossia/etc/")
 
+	setDefaults(viper.GetViper())
+
 	err := viper.ReadInConfig()
 
 	viper.WatchConfig()
 
 	if err != nil {
-		log.Fatal("%v", err)
+		log.Fatal(err)
 	}
 
-	err = viper.Unmarshal(&Cfg)
+	var config *models.Configuration
+	err = viper.Unmarshal(&config)
 	if err != nil {
 		fmt.Printf("unable to decode into config struct, %v", err)
 	}
+	err = validateConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setConfig(config)
 
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		log.WithFields(log.Fields{
 			"file": e.Name,
-		}).Warn("Config file changed. Please do a manual restart for now.")
+		}).Warn("Config file changed, reloading")
+		reloadConfig(e.Name)
 	})
 
-	return Cfg
+	return config
+}
+
+// setDefaults defines values for the optional settings
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("poll_interval.full_reconcile", "6h")
+	v.SetDefault("poll_interval.cycle_deadline", "15m")
+	v.SetDefault("api_client.timeout", "60s")
+	v.SetDefault("api_client.retries", 3)
+	v.SetDefault("api_client.backoff", "1s")
+	v.SetDefault("api_client.max_backoff", "30s")
+	v.SetDefault("api_client.circuit_breaker.threshold", 5)
+	v.SetDefault("api_client.circuit_breaker.cooldown", "10m")
+	v.SetDefault("api_client.burst", 1)
+	v.SetDefault("api_client.page_size", 1000)
+	v.SetDefault("api_client.max_pollers", 4)
+	v.SetDefault("api_client.startup_stagger", "10s")
+	v.SetDefault("auth.swagger_ui", true)
+	v.SetDefault("auth.jwt.scopes_claim", "scope")
+	v.SetDefault("auth.jwt.roles_claim", "roles")
+	v.SetDefault("auth.jwt.leeway", "60s")
+	v.SetDefault("auth.keystone.scopes", []string{"read"})
+	v.SetDefault("auth.keystone.admin_roles", []string{"admin"})
+	v.SetDefault("auth.keystone.cache_ttl", "5m")
+	v.SetDefault("auth.keystone.negative_ttl", "30s")
+	v.SetDefault("auth.keystone.cache_size", 10000)
+	v.SetDefault("auth.keystone.validation_rate", 10)
+	v.SetDefault("auth.keystone.validation_burst", 20)
+	v.SetDefault("compression.enabled", true)
+	v.SetDefault("compression.min_size", 1024)
+	v.SetDefault("cors.allowed_origins", []string{"*"})
+	v.SetDefault("events.buffer_size", 10000)
+	v.SetDefault("webhooks.workers", 4)
+	v.SetDefault("webhooks.backlog", 10000)
+	v.SetDefault("webhooks.timeout", "10s")
+	v.SetDefault("webhooks.retries", 5)
+	v.SetDefault("webhooks.backoff", "2s")
+	v.SetDefault("webhooks.dead_letters", 1000)
+	v.SetDefault("alerts.interval", "1m")
+	v.SetDefault("alerts.keep_resolved", "1h")
+	v.SetDefault("smtp.port", 25)
+	v.SetDefault("smtp.from", "ossia@localhost")
+}
+
+// readConfig reads and validates the config file
+func readConfig(file string) (*models.Configuration, error) {
+	var config *models.Configuration
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	v.SetConfigType("yaml")
+	setDefaults(v)
+
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = v.Unmarshal(&config)
+	if err != nil {
+		return nil, err
+	}
+	return config, validateConfig(config)
+}
+
+// validateConfig checks deployments credentials, poll intervals, alert rules and reports
+func validateConfig(config *models.Configuration) error {
+	for name, d := range config.Deployments {
+		err := validateDeployment(name, d)
+		if err != nil {
+			return err
+		}
+	}
+	for _, k := range config.Auth.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return fmt.Errorf("auth.api_keys: name and key are required")
+		}
+		for _, scope := range k.Scopes {
+			if scope != middleware.ScopeRead && scope != middleware.ScopeRefresh && scope != middleware.ScopeAdmin {
+				return fmt.Errorf("auth.api_keys %s: unknown scope %s", k.Name, scope)
+			}
+		}
+	}
+	jwt := config.Auth.JWT
+	if (jwt.JWKSFile != "" || jwt.Issuer != "") && jwt.Audience == "" {
+		return fmt.Errorf("auth.jwt: audience is required")
+	}
+	if home := config.Auth.Keystone.Home; home != "" {
+		if _, ok := config.Deployments[home]; !ok {
+			return fmt.Errorf("auth.keystone: unknown home deployment %s", home)
+		}
+	}
+	for _, scope := range config.Auth.Keystone.Scopes {
+		if scope != middleware.ScopeRead && scope != middleware.ScopeRefresh {
+			return fmt.Errorf("auth.keystone: unsupported scope %s", scope)
+		}
+	}
+	keystone := config.Auth.Keystone
+	if keystone.NegativeTTL < 0 || keystone.CacheSize < 0 || keystone.ValidationRate < 0 {
+		return fmt.Errorf("auth.keystone: negative_ttl, cache_size and validation_rate must not be negative")
+	}
+	if keystone.ValidationRate > 0 && keystone.ValidationBurst < 1 {
+		return fmt.Errorf("auth.keystone: validation_burst must be at least 1")
+	}
+	if config.CORS.AllowCredentials {
+		if len(config.CORS.AllowedOrigins) == 0 {
+			return fmt.Errorf("cors: allowed_origins are required with credentials")
+		}
+		for _, origin := range config.CORS.AllowedOrigins {
+			if strings.Contains(origin, "*") {
+				return fmt.Errorf("cors: wildcard origin %s is not allowed with credentials", origin)
+			}
+		}
+	}
+	for name, role := range config.RBAC.Roles {
+		for _, operation := range role.Operations {
+			if operation != middleware.ScopeRead && operation != middleware.ScopeRefresh && operation != middleware.ScopeAdmin {
+				return fmt.Errorf("rbac.roles %s: unknown operation %s", name, operation)
+			}
+		}
+	}
+	webhooks := config.Webhooks
+	if webhooks.Backlog < 0 || webhooks.Retries < 0 || webhooks.DeadLetters < 0 {
+		return fmt.Errorf("webhooks: backlog, retries and dead_letters must not be negative")
+	}
+	err := validateAlerts(config)
+	if err != nil {
+		return err
+	}
+	err = validateReports(config)
+	if err != nil {
+		return err
+	}
+	for _, c := range Collectors() {
+		err := scheduler.ValidateSchedule(c.Schedule(config.PollInterval))
+		if err != nil {
+			return fmt.Errorf("poll_interval.%s: %v", c.Name(), err)
+		}
+	}
+	for setting, value := range map[string]string{
+		"full_reconcile": config.PollInterval.FullReconcile,
+		"cycle_deadline": config.PollInterval.CycleDeadline,
+	} {
+		_, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("poll_interval.%s: %v", setting, err)
+		}
+	}
+	return nil
+}
+
+// validateDeployment checks the deployment credentials
+func validateDeployment(name string, d models.Deployment) error {
+	if !deploymentName.MatchString(name) || name == systemBucket {
+		return fmt.Errorf("deployment %s: invalid name", name)
+	}
+	if d.OsAuthURL == "" || d.OsUsername == "" || d.OsPassword == "" || d.OsProjectName == "" {
+		return fmt.Errorf("deployment %s: os_auth_url, os_username, os_password and os_project_name are required", name)
+	}
+	if d.RateLimit < 0 || d.Burst < 0 {
+		return fmt.Errorf("deployment %s: rate_limit and burst must not be negative", name)
+	}
+	return nil
+}
+
+// reloadConfig applies the changed config file. Invalid
+// files are ignored and the current config is kept
+func reloadConfig(file string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	config, err := readConfig(file)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"file":  file,
+			"error": err,
+		}).Error("Invalid config file, keeping the current config")
+		return
+	}
+
+	fileConfig = config
+	applyConfig(mergeDeployments(config))
+}
+
+// applyConfig replaces the current config, starts pollers of new
+// deployments, stops removed ones and reschedules the others
+func applyConfig(config *models.Configuration) {
+	previous := Cfg()
+	setConfig(config)
+	log.SetLevel(getLogLevel())
+
+	for setting, changed := range map[string]bool{
+		"listen_on":              previous.ListenOn != config.ListenOn,
+		"database":               previous.Database != config.Database,
+		"logfile":                previous.LogFile != config.LogFile,
+		"auto_tls":               previous.AutoTLS != config.AutoTLS,
+		"auth.swagger_ui":        previous.Auth.SwaggerUI != config.Auth.SwaggerUI,
+		"api_client.max_pollers": previous.APIClient.MaxPollers != config.APIClient.MaxPollers,
+		"cors":                   !reflect.DeepEqual(previous.CORS, config.CORS),
+	} {
+		if changed {
+			log.WithFields(log.Fields{
+				"setting": setting,
+			}).Warn("Setting changed, restart is required to apply it")
+		}
+	}
+
+	if !reflect.DeepEqual(previous.Auth, config.Auth) {
+		log.Info("API authentication settings changed")
+		setupAuth(config.Auth)
+	}
+
+	clientChanged := previous.APIClient != config.APIClient
+
+	var added []string
+	for name, d := range config.Deployments {
+		old, ok := previous.Deployments[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case old != d || clientChanged:
+			log.WithFields(log.Fields{
+				"deployment": name,
+			}).Info("Deployment settings changed, recreating API clients")
+			resetDeployment(name)
+			fallthrough
+		default:
+			scheduleInventory(name, config.PollInterval)
+		}
+	}
+
+	for name := range previous.Deployments {
+		if _, ok := config.Deployments[name]; !ok {
+			log.WithFields(log.Fields{
+				"deployment": name,
+			}).Warn("Deployment removed, stopping its tasks")
+			scheduler.RemoveTasks(name)
+			resetDeployment(name)
+		}
+	}
+
+	ScheduleGlobalTasks()
+	StartDeployments(added)
 }
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadConfigLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ossia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := `
+poll_interval:
+  images: 1h
+  flavors: 1h
+  projects: 1h
+  instances: 30m
+  aggregates: 1h
+  hypervisors: 1h
+  full_reconcile: 6h
+  cycle_deadline: 15m
+deployments:
+  lab:
+    os_auth_url: "http://keystone.lab:5000/v3"
+    os_project_name: "admin"
+    os_username: "admin"
+    os_password: "secret"
+`
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"defaults", base, ""},
+		{"deployment rate limit", base + "    rate_limit: 20\n    burst: 10\n", ""},
+		{"negative deployment rate limit", base + "    rate_limit: -1\n", "deployment lab: rate_limit and burst must not be negative"},
+		{"no dead letters", base + "webhooks:\n  dead_letters: 0\n", ""},
+		{"negative dead letters", base + "webhooks:\n  dead_letters: -1\n", "webhooks: backlog, retries and dead_letters must not be negative"},
+		{"negative backlog", base + "webhooks:\n  backlog: -5\n", "webhooks: backlog, retries and dead_letters must not be negative"},
+		{"negative keystone cache", base + "auth:\n  keystone:\n    cache_size: -1\n", "auth.keystone: negative_ttl, cache_size and validation_rate must not be negative"},
+		{"keystone without burst", base + "auth:\n  keystone:\n    validation_burst: 0\n", "auth.keystone: validation_burst must be at least 1"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, fmt.Sprintf("conf-%d.yml", i))
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := readConfig(file)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("readConfig() = %v", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Fatalf("readConfig() = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
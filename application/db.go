@@ -12,6 +12,7 @@ governing permissions and limitations under the License.
 package application
 
 import (
+	"ossia/models"
 	"time"
 
 	"github.com/asdine/storm"
@@ -52,6 +53,20 @@ func InitDB(stumb string) {
 
 }
 
+// reindexProjects rebuilds the Project indexes created when
+// the project ID was not unique and the name was not indexed
+func reindexProjects() {
+	for _, deployment := range listDeployments() {
+		err := DB.From(deployment).ReIndex(&models.Project{})
+		if err != nil && err != storm.ErrNotFound {
+			log.WithFields(log.Fields{
+				"deployment": deployment,
+				"error":      err,
+			}).Error("Unable to reindex projects")
+		}
+	}
+}
+
 /*
 Need to be re-worked
 func save(i interface{}, db storm.Node) {
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"errors"
+	"ossia/models"
+	"ossia/utils"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	log "github.com/sirupsen/logrus"
+)
+
+// systemBucket keeps OSSIA own data, such as deployments
+// registered via API. It is not an OpenStack deployment
+const systemBucket = "__ossia__"
+
+// fileConfig is the config as defined in the config file,
+// Cfg adds the deployments registered via API
+var fileConfig *models.Configuration
+
+// Deployment management errors
+var (
+	errNoSecretKey       = errors.New("admin.secret_key is not configured")
+	errDeploymentExists  = errors.New("deployment already exists")
+	errDefinedInFile     = errors.New("deployment is defined in the config file")
+	errDeploymentMissing = errors.New("deployment not found")
+)
+
+// inventoryBucket is true for the DB buckets of OpenStack deployments
+func inventoryBucket(name string) bool {
+	return !strings.Contains(name, "storm") && name != systemBucket
+}
+
+// loadManagedDeployments adds deployments registered via API
+// to the config read from the file
+func loadManagedDeployments() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	fileConfig = Cfg()
+	setConfig(mergeDeployments(fileConfig))
+}
+
+// mergeDeployments returns a copy of the config with enabled
+// deployments registered via API. Deployments defined in
+// the config file take precedence
+func mergeDeployments(config *models.Configuration) *models.Configuration {
+	merged := *config
+	merged.Deployments = make(map[string]models.Deployment, len(config.Deployments))
+	for name, d := range config.Deployments {
+		merged.Deployments[name] = d
+	}
+
+	for _, d := range listManagedDeployments() {
+		if d.Disabled {
+			continue
+		}
+		if _, ok := config.Deployments[d.Name]; ok {
+			log.WithFields(log.Fields{
+				"deployment": d.Name,
+			}).Warn("Deployment is defined in the config file, ignoring the registered one")
+			continue
+		}
+		password, err := utils.Decrypt(config.Admin.SecretKey, d.Secret)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"deployment": d.Name,
+				"error":      err,
+			}).Error("Unable to decrypt deployment credentials")
+			continue
+		}
+		merged.Deployments[d.Name] = d.Deployment(password)
+	}
+	return &merged
+}
+
+// listManagedDeployments returns deployments registered via API
+func listManagedDeployments() []models.ManagedDeployment {
+	var deployments []models.ManagedDeployment
+
+	err := DB.From(systemBucket).All(&deployments)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+	}
+	return deployments
+}
+
+// getManagedDeployment returns the deployment registered via API
+func getManagedDeployment(name string) (models.ManagedDeployment, error) {
+	var d models.ManagedDeployment
+
+	err := DB.From(systemBucket).One("Name", name, &d)
+	if err == storm.ErrNotFound {
+		return d, errDeploymentMissing
+	}
+	return d, err
+}
+
+// isManagedDeployment is true for deployments registered via API,
+// including the disabled ones
+func isManagedDeployment(name string) bool {
+	_, err := getManagedDeployment(name)
+	return err == nil
+}
+
+// saveManagedDeployment stores the deployment registered via API
+// and applies it. The password is encrypted, an empty password
+// keeps the stored one. created is set for new registrations
+func saveManagedDeployment(d models.ManagedDeployment, created bool) (models.ManagedDeployment, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if fileConfig.Admin.SecretKey == "" {
+		return d, errNoSecretKey
+	}
+	if _, ok := fileConfig.Deployments[d.Name]; ok {
+		return d, errDefinedInFile
+	}
+
+	d.Secret = ""
+	stored, err := getManagedDeployment(d.Name)
+	switch {
+	case err == nil && created:
+		return d, errDeploymentExists
+	case err != nil && !created:
+		return d, err
+	case err == nil:
+		d.Created = stored.Created
+		d.Secret = stored.Secret
+	default:
+		d.Created = time.Now()
+	}
+
+	password := d.OsPassword
+	if password == "" && d.Secret != "" {
+		password, err = utils.Decrypt(fileConfig.Admin.SecretKey, d.Secret)
+		if err != nil {
+			return d, err
+		}
+	}
+	err = validateDeployment(d.Name, d.Deployment(password))
+	if err != nil {
+		return d, err
+	}
+
+	d.Secret, err = utils.Encrypt(fileConfig.Admin.SecretKey, password)
+	if err != nil {
+		return d, err
+	}
+	d.OsPassword = ""
+	d.Updated = time.Now()
+
+	err = DB.From(systemBucket).Save(&d)
+	if err != nil {
+		return d, err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": d.Name,
+		"disabled":   d.Disabled,
+	}).Info("Saved registered deployment")
+
+	applyConfig(mergeDeployments(fileConfig))
+	return d, nil
+}
+
+// deleteManagedDeployment removes the deployment registered via API.
+// Its inventory is purged by the next dbCleanup
+func deleteManagedDeployment(name string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	d, err := getManagedDeployment(name)
+	if err != nil {
+		return err
+	}
+	err = DB.From(systemBucket).DeleteStruct(&d)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": name,
+	}).Info("Deleted registered deployment")
+
+	applyConfig(mergeDeployments(fileConfig))
+	return nil
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/kataras/iris/v12"
+)
+
+// snakeAcronyms are rewritten before splitting the Go field
+// names, so FixedIPv4 is fixed_ipv4 and TotalRAMMB total_ram_mb
+var snakeAcronyms = strings.NewReplacer(
+	"VCPUs", "Vcpus",
+	"NICs", "Nics",
+	"VMs", "Vms",
+	"IPv4", "Ipv4",
+	"IPv6", "Ipv6",
+	"FQDN", "Fqdn",
+	"RAM", "Ram",
+	"MAC", "Mac",
+	"MB", "Mb",
+	"GB", "Gb",
+	"IP", "Ip",
+	"ID", "Id",
+)
+
+// snakeCase returns the v2 name of the Go field
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(snakeAcronyms.Replace(name))
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && !unicode.IsUpper(runes[i-1]) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// snakeObject is a JSON object keeping the order of its keys
+type snakeObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newSnakeObject() *snakeObject {
+	return &snakeObject{values: make(map[string]interface{})}
+}
+
+func (o *snakeObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *snakeObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// snakeValue converts the struct fields to snake_case recursively.
+// Map keys, such as metadata, are kept as is and nil slices are empty
+func snakeValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	if _, ok := v.Interface().(json.Marshaler); ok {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return snakeValue(v.Elem())
+	case reflect.Struct:
+		return snakeFields(v, nil)
+	case reflect.Map:
+		if v.IsNil() {
+			return map[string]interface{}{}
+		}
+		result := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			result[fmt.Sprint(iter.Key().Interface())] = snakeValue(iter.Value())
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			result[i] = snakeValue(v.Index(i))
+		}
+		return result
+	}
+	return v.Interface()
+}
+
+// snakeFields converts the struct with the selected fields only,
+// all the exported fields if none is selected
+func snakeFields(v reflect.Value, fields []string) *snakeObject {
+	selected := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		selected[f] = true
+	}
+
+	o := newSnakeObject()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || (len(fields) > 0 && !selected[f.Name]) {
+			continue
+		}
+		name := snakeCase(f.Name)
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		o.set(name, snakeValue(v.Field(i)))
+	}
+	return o
+}
+
+// snakeItems converts the list items with the fields of the list query
+func snakeItems(lq *listQuery, items interface{}) []interface{} {
+	v := reflect.ValueOf(items)
+	result := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		item := reflect.Indirect(v.Index(i))
+		if item.Kind() == reflect.Struct && lq != nil {
+			result = append(result, snakeFields(item, lq.fields))
+			continue
+		}
+		result = append(result, snakeValue(v.Index(i)))
+	}
+	return result
+}
+
+// envelope renders the v2 response. Lists are rendered
+// one item per row in CSV and NDJSON formats
+func envelope(c iris.Context, data interface{}, meta iris.Map, links iris.Map) {
+	if meta == nil {
+		meta = iris.Map{}
+	}
+	if links == nil {
+		links = iris.Map{}
+	}
+	links["self"] = c.Request().URL.RequestURI()
+
+	if reflect.ValueOf(data).Kind() == reflect.Slice {
+		setRows(c, data)
+	}
+
+	response := newSnakeObject()
+	response.set("data", data)
+	response.set("meta", meta)
+	response.set("links", links)
+	render(c, response)
+}
+
+// listEnvelope renders the page of the list. The next page is
+// linked in the links and the Link header
+func listEnvelope(c iris.Context, lq *listQuery, items interface{}, next string, meta iris.Map) {
+	data := snakeItems(lq, items)
+	if meta == nil {
+		meta = iris.Map{}
+	}
+	meta["count"] = len(data)
+
+	links := iris.Map{}
+	if next != "" {
+		u := *c.Request().URL
+		query := u.Query()
+		query.Set("marker", next)
+		u.RawQuery = query.Encode()
+
+		meta["next_marker"] = next
+		links["next"] = u.RequestURI()
+		c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	}
+	envelope(c, data, meta, links)
+}
+
+// problem responds RFC 7807 problem details. Extensions
+// are added as additional members
+func problem(c iris.Context, status int, detail string, extensions iris.Map) {
+	p := iris.NewProblem().
+		Status(status).
+		Detail(detail).
+		Instance(c.Request().URL.RequestURI())
+	for key, value := range extensions {
+		p.Key(key, value)
+	}
+	c.Problem(p)
+}
+
+// isV2 is true for the requests of the v2 API
+func isV2(c iris.Context) bool {
+	return strings.HasPrefix(c.Path(), "/v2/") || c.Path() == "/v2"
+}
+
+// apiError responds the error message of the API version,
+// a problem for v2 and a message object for v1
+func apiError(c iris.Context, status int, message string) {
+	if isV2(c) {
+		problem(c, status, message, nil)
+		return
+	}
+	c.StatusCode(status)
+	c.JSON(iris.Map{
+		"message": message,
+	})
+}
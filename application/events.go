@@ -0,0 +1,554 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"ossia/models"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// Inventory change events. reconcile compares the fetched objects
+// with the stored ones and publishes the changes on the event bus.
+// Recent events are kept in memory, so clients resume the stream
+
+// subscriberQueue is the amount of events queued per subscriber.
+// Slow subscribers are dropped and resume from the buffer
+const subscriberQueue = 256
+
+// eventKeepalive is the interval of keepalive messages,
+// so idle streams are not closed by proxies
+const eventKeepalive = 30 * time.Second
+
+// eventBus keeps the recent events and the subscribers. Sequence
+// numbers start from the startup time, so they grow across restarts
+var eventBus = struct {
+	sync.Mutex
+	next        uint64
+	events      []models.Event
+	subscribers map[*subscriber]bool
+}{
+	next:        uint64(time.Now().UnixNano() / int64(time.Millisecond) * 1000),
+	subscribers: make(map[*subscriber]bool),
+}
+
+// subscriber receives the events matching its filter
+type subscriber struct {
+	filter *eventFilter
+	events chan models.Event
+	// dropped is closed if the subscriber does not keep up
+	dropped chan struct{}
+}
+
+// publishEvents numbers the events in place, keeps them
+// in the buffer and sends them to the subscribers
+func publishEvents(events []models.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	loadEventProjects(events)
+
+	eventBus.Lock()
+	defer eventBus.Unlock()
+
+	for i := range events {
+		eventBus.next++
+		events[i].ID = eventBus.next
+		e := events[i]
+		eventBus.events = append(eventBus.events, e)
+
+		for s := range eventBus.subscribers {
+			if !s.filter.match(e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				close(s.dropped)
+				delete(eventBus.subscribers, s)
+			}
+		}
+	}
+	if size := Cfg().Events.BufferSize; len(eventBus.events) > size {
+		eventBus.events = append([]models.Event(nil), eventBus.events[len(eventBus.events)-size:]...)
+	}
+}
+
+// loadEventProjects caches the projects allowed to the subscribers in
+// the deployments of the events before they are matched under the event
+// bus lock. Project changes reload them
+func loadEventProjects(events []models.Event) {
+	reload := make(map[string]bool)
+	for _, e := range events {
+		reload[e.Deployment] = reload[e.Deployment] || e.Resource == "projects"
+	}
+
+	eventBus.Lock()
+	filters := make([]*eventFilter, 0, len(eventBus.subscribers))
+	for s := range eventBus.subscribers {
+		filters = append(filters, s.filter)
+	}
+	eventBus.Unlock()
+
+	for _, f := range filters {
+		for deployment, projectsChanged := range reload {
+			f.loadProjects(deployment, projectsChanged)
+		}
+	}
+}
+
+// subscribe registers the subscriber and returns the buffered events
+// following the last event ID. lost is set if some were dropped
+// from the buffer already
+func subscribe(filter *eventFilter, lastID uint64) (s *subscriber, replay []models.Event, lost bool) {
+	eventBus.Lock()
+	defer eventBus.Unlock()
+
+	if lastID > 0 {
+		oldest := eventBus.next + 1
+		if len(eventBus.events) > 0 {
+			oldest = eventBus.events[0].ID
+		}
+		lost = lastID+1 < oldest
+		for _, e := range eventBus.events {
+			if e.ID > lastID && filter.match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	s = &subscriber{
+		filter:  filter,
+		events:  make(chan models.Event, subscriberQueue),
+		dropped: make(chan struct{}),
+	}
+	eventBus.subscribers[s] = true
+	return s, replay, lost
+}
+
+// unsubscribe removes the subscriber
+func unsubscribe(s *subscriber) {
+	eventBus.Lock()
+	defer eventBus.Unlock()
+
+	delete(eventBus.subscribers, s)
+}
+
+// eventFilter selects the events by deployment, resource type and
+// project, within the deployments and projects allowed to the caller
+type eventFilter struct {
+	access      *access
+	deployments map[string]bool
+	resources   map[string]bool
+	projects    map[string]bool
+
+	// allowed caches the project IDs allowed to the caller, previous
+	// keeps the ones before the last reload for the deleted projects
+	mu       sync.Mutex
+	allowed  map[string]map[string]bool
+	previous map[string]map[string]bool
+}
+
+// instanceListFields are object fields listing instance names,
+// hidden from the callers restricted to some projects
+var instanceListFields = []string{"UsedBy", "VMs"}
+
+// match checks the event against the filter and the caller access
+func (f *eventFilter) match(e models.Event) bool {
+	if len(f.deployments) > 0 && !f.deployments[e.Deployment] {
+		return false
+	}
+	if len(f.resources) > 0 && !f.resources[e.Resource] {
+		return false
+	}
+	if len(f.projects) > 0 && !f.projects[e.ProjectID] && !f.projects[e.Deployment+"/"+e.ProjectID] {
+		return false
+	}
+	if !f.access.canRead(e.Deployment) {
+		return false
+	}
+
+	if f.access.allProjects(e.Deployment) {
+		return true
+	}
+	switch e.Resource {
+	case "instances", "projects":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if e.Resource == "projects" && e.Type == models.EventDeleted && f.previous[e.Deployment][e.ProjectID] {
+			return true
+		}
+		return f.allowed[e.Deployment][e.ProjectID]
+	}
+	return true
+}
+
+// loadProjects caches the project IDs allowed to the caller in the
+// deployment, loaded once unless reloaded after project changes.
+// The Inventory is queried without holding the cache lock
+func (f *eventFilter) loadProjects(deployment string, reload bool) {
+	if f.access.allProjects(deployment) {
+		return
+	}
+
+	f.mu.Lock()
+	_, ok := f.allowed[deployment]
+	f.mu.Unlock()
+	if ok && !reload {
+		return
+	}
+
+	ids := f.access.projectIDs(deployment)
+	f.mu.Lock()
+	f.previous[deployment] = f.allowed[deployment]
+	f.allowed[deployment] = ids
+	f.mu.Unlock()
+}
+
+// visible hides the instance lists of the event from the callers
+// restricted to some projects
+func (f *eventFilter) visible(e models.Event) models.Event {
+	if f.access.allProjects(e.Deployment) || len(e.Changes) == 0 {
+		return e
+	}
+	changes := make(map[string]models.Change, len(e.Changes))
+	for field, change := range e.Changes {
+		changes[field] = change
+	}
+	for _, field := range instanceListFields {
+		delete(changes, field)
+	}
+	e.Changes = changes
+	return e
+}
+
+// newEventFilter parses the comma separated deployments, resource
+// types and projects (IDs or names) of the request
+func newEventFilter(a *access, deployments string, resources string, projects string) (*eventFilter, error) {
+	f := &eventFilter{
+		access:      a,
+		deployments: make(map[string]bool),
+		resources:   make(map[string]bool),
+		projects:    make(map[string]bool),
+		allowed:     make(map[string]map[string]bool),
+		previous:    make(map[string]map[string]bool),
+	}
+
+	for _, d := range splitList(deployments) {
+		if !deploymentRegistered(d) {
+			return nil, fmt.Errorf("unknown deployment %s", d)
+		}
+		f.deployments[d] = true
+	}
+	for _, r := range splitList(resources) {
+		if _, ok := getCollector(r); !ok {
+			return nil, fmt.Errorf("unknown resource type %s", r)
+		}
+		f.resources[r] = true
+	}
+
+	// project names are resolved to IDs per deployment,
+	// IDs match in any deployment
+	for _, p := range splitList(projects) {
+		f.projects[p] = true
+		for _, d := range listDeployments() {
+			var matches []models.Project
+			err := DB.From(d).Find("Name", p, &matches)
+			if err != nil && err != storm.ErrNotFound {
+				log.Error(err)
+			}
+			for _, project := range matches {
+				f.projects[d+"/"+project.ID] = true
+			}
+		}
+	}
+
+	// the buffered events are replayed under the event bus lock
+	for _, d := range listDeployments() {
+		if a.canRead(d) {
+			f.loadProjects(d, false)
+		}
+	}
+	return f, nil
+}
+
+// splitList returns the non-empty values of the comma separated list
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// changeEvent returns the event of the object change, old is nil
+// for created objects and r is nil for deleted ones. Objects with
+// only a new PollTime are not changed
+func changeEvent(deployment string, c Collector, old models.Resource, r models.Resource) (models.Event, bool) {
+	e := models.Event{
+		Time:       time.Now(),
+		Deployment: deployment,
+		Resource:   c.Name(),
+	}
+	object := r
+	switch {
+	case old == nil && r == nil:
+		return e, false
+	case old == nil:
+		e.Type = models.EventCreated
+	case r == nil:
+		e.Type = models.EventDeleted
+		object = old
+	default:
+		e.Type = models.EventUpdated
+	}
+
+	e.ObjectID = object.Key()
+	v := reflect.ValueOf(object).Elem()
+	if oc, ok := c.(ObjectCollector); ok {
+		e.Name = v.FieldByName(oc.NameField()).String()
+	}
+	if p := v.FieldByName("ProjectID"); p.IsValid() {
+		e.ProjectID = p.String()
+	} else if _, ok := object.(*models.Project); ok {
+		e.ProjectID = object.Key()
+	}
+
+	e.Changes = objectChanges(old, r)
+	if e.Type == models.EventUpdated && len(e.Changes) == 0 {
+		return e, false
+	}
+	return e, true
+}
+
+// objectChanges compares the JSON values of the object fields
+func objectChanges(old models.Resource, r models.Resource) map[string]models.Change {
+	var before, after reflect.Value
+	if old != nil {
+		before = reflect.ValueOf(old).Elem()
+	}
+	if r != nil {
+		after = reflect.ValueOf(r).Elem()
+	}
+	t := reflect.TypeOf(r)
+	if r == nil {
+		t = reflect.TypeOf(old)
+	}
+	t = t.Elem()
+
+	changes := make(map[string]models.Change)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if name == "PollTime" || t.Field(i).PkgPath != "" {
+			continue
+		}
+
+		switch {
+		case old == nil:
+			if !after.Field(i).IsZero() {
+				changes[name] = models.Change{New: after.Field(i).Interface()}
+			}
+		case r == nil:
+			if !before.Field(i).IsZero() {
+				changes[name] = models.Change{Old: before.Field(i).Interface()}
+			}
+		default:
+			change := models.Change{
+				Old: before.Field(i).Interface(),
+				New: after.Field(i).Interface(),
+			}
+			oldJSON, _ := json.Marshal(change.Old)
+			newJSON, _ := json.Marshal(change.New)
+			if !bytes.Equal(oldJSON, newJSON) {
+				changes[name] = change
+			}
+		}
+	}
+	return changes
+}
+
+// eventStream is the transport of the events to the client
+type eventStream interface {
+	// send writes the event
+	send(e models.Event) error
+	// reset tells the client events after lastID were lost,
+	// so it reloads the Inventory
+	reset(lastID uint64) error
+	// keepalive writes a message ignored by the client
+	keepalive() error
+}
+
+// streamEvents sends the events following the last event ID and the
+// published ones until the client goes away. Dropped subscribers
+// end the stream, the client resumes from its last event
+func streamEvents(ctx context.Context, stream eventStream, filter *eventFilter, lastID uint64) error {
+	s, replay, lost := subscribe(filter, lastID)
+	defer unsubscribe(s)
+
+	if lost {
+		err := stream.reset(lastID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range replay {
+		err := stream.send(filter.visible(e))
+		if err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(eventKeepalive)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.dropped:
+			return nil
+		case e := <-s.events:
+			err = stream.send(filter.visible(e))
+		case <-ticker.C:
+			err = stream.keepalive()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// resetMessage explains the reset of the stream
+func resetMessage(lastID uint64) string {
+	return fmt.Sprintf("Events after %d are not available anymore, reload the inventory", lastID)
+}
+
+// sseStream writes Server-Sent Events
+type sseStream struct {
+	c iris.Context
+}
+
+func (s sseStream) send(e models.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data))
+}
+
+func (s sseStream) reset(lastID uint64) error {
+	data, err := json.Marshal(iris.Map{"message": resetMessage(lastID)})
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("event: reset\ndata: %s\n\n", data))
+}
+
+func (s sseStream) keepalive() error {
+	return s.write(": keepalive\n\n")
+}
+
+func (s sseStream) write(message string) error {
+	_, err := s.c.WriteString(message)
+	if err != nil {
+		return err
+	}
+	s.c.ResponseWriter().Flush()
+	return nil
+}
+
+// serveSSE streams the events as text/event-stream
+func serveSSE(c iris.Context, filter *eventFilter, lastID uint64) {
+	c.ContentType("text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.StatusCode(iris.StatusOK)
+
+	// the first keepalive sends the headers, so clients know
+	// the stream is open before the first event
+	stream := sseStream{c}
+	err := stream.keepalive()
+	if err == nil {
+		err = streamEvents(c.Request().Context(), stream, filter, lastID)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": c.Path(),
+		}).Debug(err)
+	}
+}
+
+// wsStream writes the events as WebSocket text messages
+type wsStream struct {
+	ws *websocket.Conn
+}
+
+func (s wsStream) send(e models.Event) error {
+	return websocket.JSON.Send(s.ws, e)
+}
+
+func (s wsStream) reset(lastID uint64) error {
+	return websocket.JSON.Send(s.ws, iris.Map{
+		"Type":    "reset",
+		"Message": resetMessage(lastID),
+	})
+}
+
+func (s wsStream) keepalive() error {
+	s.ws.PayloadType = websocket.PingFrame
+	defer func() { s.ws.PayloadType = websocket.TextFrame }()
+	_, err := s.ws.Write(nil)
+	return err
+}
+
+// serveWebSocket streams the events over WebSocket. Messages of the
+// client are ignored, the stream ends when the connection is closed
+func serveWebSocket(c iris.Context, filter *eventFilter, lastID uint64) {
+	server := websocket.Server{
+		// callers are authenticated already, any origin is accepted
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ctx, cancel := context.WithCancel(c.Request().Context())
+			defer cancel()
+			go func() {
+				defer cancel()
+				var message []byte
+				for websocket.Message.Receive(ws, &message) == nil {
+				}
+			}()
+
+			err := streamEvents(ctx, wsStream{ws}, filter, lastID)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"path": c.Path(),
+				}).Debug(err)
+			}
+		},
+	}
+	server.ServeHTTP(c.ResponseWriter(), c.Request())
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"testing"
+	"time"
+
+	"ossia/middleware"
+	"ossia/models"
+)
+
+func TestEventFilterProjects(t *testing.T) {
+	openTestDB(t)
+	useTestConfig(t, &models.Configuration{Events: models.Events{BufferSize: 100}})
+
+	bucket := DB.From("lab")
+	projects := []models.Project{{ID: "p-a", Name: "team-a-web"}, {ID: "p-b", Name: "team-b"}}
+	for i := range projects {
+		if err := bucket.Save(&projects[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &access{roles: []models.Role{{
+		Deployments: []string{"lab"},
+		Projects:    []string{"team-a-*"},
+		Operations:  []string{middleware.ScopeRead},
+	}}}
+	f, err := newEventFilter(a, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _, _ := subscribe(f, 0)
+	defer unsubscribe(s)
+
+	tests := []struct {
+		name     string
+		delete   *models.Project
+		event    models.Event
+		received bool
+	}{
+		{"allowed instance", nil, models.Event{Resource: "instances", Type: models.EventCreated, ProjectID: "p-a"}, true},
+		{"other instance", nil, models.Event{Resource: "instances", Type: models.EventCreated, ProjectID: "p-b"}, false},
+		{"other project deleted", &projects[1], models.Event{Resource: "projects", Type: models.EventDeleted, ProjectID: "p-b", Name: "team-b"}, false},
+		{"allowed project deleted", &projects[0], models.Event{Resource: "projects", Type: models.EventDeleted, ProjectID: "p-a", Name: "team-a-web"}, true},
+		{"instance of the deleted project", nil, models.Event{Resource: "instances", Type: models.EventDeleted, ProjectID: "p-a"}, false},
+		{"flavor", nil, models.Event{Resource: "flavors", Type: models.EventCreated}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.delete != nil {
+				if err := bucket.DeleteStruct(tt.delete); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tt.event.Deployment = "lab"
+			tt.event.Time = time.Now()
+			publishEvents([]models.Event{tt.event})
+
+			select {
+			case e := <-s.events:
+				if !tt.received {
+					t.Errorf("received %s %s of %s, want filtered", e.Resource, e.Type, e.ProjectID)
+				}
+			default:
+				if tt.received {
+					t.Error("event filtered, want received")
+				}
+			}
+		})
+	}
+}
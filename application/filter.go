@@ -0,0 +1,284 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"ossia/filter"
+	"ossia/models"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm/q"
+	log "github.com/sirupsen/logrus"
+)
+
+// join is a resource related to the filtered one,
+// such as the flavor or the project of an instance
+type join struct {
+	model reflect.Type
+	fetch func(r *resolver, v reflect.Value) []reflect.Value
+}
+
+// joins are the related resources available in the filter
+// field paths, e.g. flavor.vcpus or hypervisor.aggregate.name
+var joins = map[reflect.Type]map[string]join{
+	reflect.TypeOf(models.Instance{}): {
+		"flavor": {reflect.TypeOf(models.Flavor{}), func(r *resolver, v reflect.Value) []reflect.Value {
+			return r.lookup("flavors", v.FieldByName("Flavor").String())
+		}},
+		"project": {reflect.TypeOf(models.Project{}), func(r *resolver, v reflect.Value) []reflect.Value {
+			return r.lookup("projects", v.FieldByName("ProjectID").String())
+		}},
+		"image": {reflect.TypeOf(models.Image{}), func(r *resolver, v reflect.Value) []reflect.Value {
+			return r.lookup("images", v.FieldByName("ImageID").String())
+		}},
+		"hypervisor": {reflect.TypeOf(models.Hypervisor{}), func(r *resolver, v reflect.Value) []reflect.Value {
+			return r.lookup("hypervisors", v.FieldByName("Hypervisor").String())
+		}},
+	},
+	reflect.TypeOf(models.Hypervisor{}): {
+		"aggregate": {reflect.TypeOf(models.Aggregate{}), func(r *resolver, v reflect.Value) []reflect.Value {
+			return r.lookup("aggregates", v.FieldByName("Hostname").String())
+		}},
+	},
+}
+
+// computedFields are added to the responses only, the stored
+// objects can not be filtered or sorted on them
+var computedFields = map[reflect.Type][]string{
+	reflect.TypeOf(models.Hypervisor{}): {"VMs"},
+}
+
+// resolver resolves the filter field paths of the deployment
+// resources. Related resources are loaded once per request
+type resolver struct {
+	deployment string
+	related    map[string]map[string][]reflect.Value
+}
+
+func newResolver(deployment string) *resolver {
+	return &resolver{
+		deployment: deployment,
+		related:    make(map[string]map[string][]reflect.Value),
+	}
+}
+
+// matcher evaluates the expression in Storm queries
+func (r *resolver) matcher(expr filter.Expr) q.Matcher {
+	return matchFunc(func(v reflect.Value) bool {
+		return expr.Match(func(path string) []interface{} {
+			return r.values(v, path)
+		})
+	})
+}
+
+// values returns the values of the field path. Joins are tried
+// before the fields of the same name if the path continues
+func (r *resolver) values(v reflect.Value, path string) []interface{} {
+	v = reflect.Indirect(v)
+	if path == "" {
+		return leafValues(v)
+	}
+	head, rest := splitPath(path)
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			return nil
+		}
+		field, isField := modelField(v.Type(), head)
+		if j, ok := joins[v.Type()][normalizeField(head)]; ok && (rest != "" || !isField) {
+			var values []interface{}
+			for _, related := range j.fetch(r, v) {
+				values = append(values, r.values(related, rest)...)
+			}
+			return values
+		}
+		if !isField {
+			return nil
+		}
+		return r.values(v.FieldByName(field), rest)
+	case reflect.Map:
+		// Metadata keys may contain dots
+		value := v.MapIndex(reflect.ValueOf(path))
+		if !value.IsValid() {
+			return nil
+		}
+		return r.values(value, "")
+	case reflect.Slice:
+		var values []interface{}
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, r.values(v.Index(i), path)...)
+		}
+		return values
+	case reflect.Interface:
+		return r.values(v.Elem(), path)
+	case reflect.String:
+		// String lists, e.g. deployments, expose the name field
+		if rest == "" && normalizeField(head) == "name" {
+			return leafValues(v)
+		}
+	}
+	return nil
+}
+
+// leafValues returns the compared values. Related
+// resources are compared by their names
+func leafValues(v reflect.Value) []interface{} {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return leafValues(v.Elem())
+	case reflect.Slice:
+		var values []interface{}
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, leafValues(v.Index(i))...)
+		}
+		return values
+	case reflect.Map:
+		return nil
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			return []interface{}{v.Interface()}
+		}
+		for _, name := range []string{"Name", "Hostname"} {
+			if f := v.FieldByName(name); f.IsValid() {
+				return []interface{}{f.Interface()}
+			}
+		}
+		return nil
+	}
+	return []interface{}{v.Interface()}
+}
+
+// lookup returns the related resources of the deployment by key:
+// flavors, projects and images by ID, hypervisors by hostname
+// and aggregates by their hosts
+func (r *resolver) lookup(resource string, key string) []reflect.Value {
+	index, ok := r.related[resource]
+	if !ok {
+		index = make(map[string][]reflect.Value)
+		bucket := DB.From(r.deployment)
+		var err error
+
+		switch resource {
+		case "flavors":
+			var flavors []models.Flavor
+			err = bucket.All(&flavors)
+			for _, f := range flavors {
+				index[f.ID] = append(index[f.ID], reflect.ValueOf(f))
+			}
+		case "projects":
+			var projects []models.Project
+			err = bucket.All(&projects)
+			for _, p := range projects {
+				index[p.ID] = append(index[p.ID], reflect.ValueOf(p))
+			}
+		case "images":
+			var images []models.Image
+			err = bucket.All(&images)
+			for _, i := range images {
+				index[i.ID] = append(index[i.ID], reflect.ValueOf(i))
+			}
+		case "hypervisors":
+			var hypervisors []models.Hypervisor
+			err = bucket.All(&hypervisors)
+			for _, h := range hypervisors {
+				index[h.Hostname] = append(index[h.Hostname], reflect.ValueOf(h))
+			}
+		case "aggregates":
+			var aggregates []models.Aggregate
+			err = bucket.All(&aggregates)
+			for _, a := range aggregates {
+				for _, host := range a.Hosts {
+					index[host] = append(index[host], reflect.ValueOf(a))
+				}
+			}
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"deployment": r.deployment,
+				"resource":   resource,
+			}).Error(err)
+		}
+		r.related[resource] = index
+	}
+	return index[key]
+}
+
+// validPath checks the filter field path against the model
+func validPath(t reflect.Type, path string) bool {
+	head, rest := splitPath(path)
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return false
+		}
+		field, isField := modelField(t, head)
+		if j, ok := joins[t][normalizeField(head)]; ok && (rest != "" || !isField) {
+			return rest == "" || validPath(j.model, rest)
+		}
+		if !isField || contains(computedFields[t], field) {
+			return false
+		}
+		f, _ := t.FieldByName(field)
+		return rest == "" || validPath(f.Type, rest)
+	case reflect.Map:
+		return true
+	case reflect.Slice, reflect.Ptr:
+		return validPath(t.Elem(), path)
+	case reflect.Interface:
+		return true
+	case reflect.String:
+		return rest == "" && normalizeField(head) == "name"
+	}
+	return false
+}
+
+// indexedField returns the Storm indexed field of the model
+// compared by equality in every match, used to narrow the query
+func indexedField(t reflect.Type, expr filter.Expr) (string, string, bool) {
+	if t.Kind() != reflect.Struct {
+		return "", "", false
+	}
+	for _, c := range filter.Conjuncts(expr) {
+		if c.Op != filter.OpEqual || strings.Contains(c.Path, ".") {
+			continue
+		}
+		field, ok := modelField(t, c.Path)
+		if !ok {
+			continue
+		}
+		if _, join := joins[t][normalizeField(c.Path)]; join {
+			continue
+		}
+		f, _ := t.FieldByName(field)
+		tag := f.Tag.Get("storm")
+		if f.Type.Kind() == reflect.String && (tag == "index" || tag == "unique" || tag == "id") {
+			return field, c.Value, true
+		}
+	}
+	return "", "", false
+}
+
+func splitPath(path string) (string, string) {
+	i := strings.Index(path, ".")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
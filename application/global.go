@@ -0,0 +1,199 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"fmt"
+	"ossia/models"
+	"ossia/utils"
+	"sort"
+	"time"
+
+	"github.com/asdine/storm/q"
+	log "github.com/sirupsen/logrus"
+)
+
+// readableDeployments returns the sorted deployments visible to the caller
+func readableDeployments(a *access) []string {
+	deployments := []string{}
+	for _, d := range listDeployments() {
+		if a.canRead(d) {
+			deployments = append(deployments, d)
+		}
+	}
+	sort.Strings(deployments)
+	return deployments
+}
+
+// getGlobalUsage returns the usage of the deployments and their totals
+func getGlobalUsage(deployments []string, a *access) (models.Usage, map[string]models.Usage) {
+	defer utils.TimeTrack(time.Now(), getGlobalUsage)
+
+	totals := models.Usage{InstancesByStatus: make(map[string]int)}
+	usage := make(map[string]models.Usage, len(deployments))
+	for _, deployment := range deployments {
+		usage[deployment] = getUsage(deployment, a)
+		totals.Add(usage[deployment])
+	}
+	return totals, usage
+}
+
+// getUsage returns the inventory and capacity of the deployment.
+// Instances and projects are restricted to the caller projects
+func getUsage(deployment string, a *access) models.Usage {
+	usage := models.Usage{
+		InstancesByStatus: make(map[string]int),
+		Projects:          countResources(deployment, &models.Project{}, a.projectMatcher(deployment)),
+		Hypervisors:       countResources(deployment, &models.Hypervisor{}),
+		Images:            countResources(deployment, &models.Image{}),
+		Flavors:           countResources(deployment, &models.Flavor{}),
+	}
+
+	bucket := DB.From(deployment)
+
+	matchers := presentMatchers([]q.Matcher{a.instanceMatcher(deployment)})
+	err := bucket.Select(matchers...).Each(new(models.Instance), func(r interface{}) error {
+		usage.Instances++
+		usage.InstancesByStatus[r.(*models.Instance).Status]++
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"deployment": deployment}).Error(err)
+	}
+
+	// Let's make it more accurate than OS does
+	err = bucket.Select(q.Eq("Status", "enabled"), q.Eq("State", "up")).Each(new(models.Hypervisor), func(r interface{}) error {
+		h := r.(*models.Hypervisor)
+		usage.VCPUs += h.VCPUs
+		usage.VCPUsUsed += h.VCPUsUsed
+		usage.MemoryMB += h.TotalRAMMB
+		usage.MemoryUsedMB += h.TotalRAMMB - h.FreeRAMMB
+		usage.DiskGB += h.TotalDiskGB
+		usage.DiskUsedGB += h.TotalDiskGB - h.FreeDiskGB
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{"deployment": deployment}).Error(err)
+	}
+	return usage
+}
+
+// getCatalogs merges the flavors and images of the deployments.
+// Flavors are merged by name and size, images by name
+func getCatalogs(deployments []string, a *access) ([]models.FlavorCatalogEntry, []models.ImageCatalogEntry) {
+	defer utils.TimeTrack(time.Now(), getCatalogs)
+
+	flavors := make(map[string]*models.FlavorCatalogEntry)
+	images := make(map[string]*models.ImageCatalogEntry)
+
+	for _, deployment := range deployments {
+		for _, f := range listFlavors(deployment) {
+			key := fmt.Sprintf("%s/%d/%d/%d", f.Name, f.VCPUs, f.RAM, f.Disk)
+			entry, ok := flavors[key]
+			if !ok {
+				entry = &models.FlavorCatalogEntry{
+					Name:        f.Name,
+					VCPUs:       f.VCPUs,
+					RAM:         f.RAM,
+					Disk:        f.Disk,
+					Deployments: make(map[string]string),
+				}
+				flavors[key] = entry
+			}
+			entry.Deployments[deployment] = f.ID
+		}
+
+		usedBy := a.instanceNameFilter(deployment)
+		for _, i := range listImages(deployment) {
+			entry, ok := images[i.Name]
+			if !ok {
+				entry = &models.ImageCatalogEntry{
+					Name:        i.Name,
+					Deployments: make(map[string]string),
+				}
+				images[i.Name] = entry
+			}
+			entry.Deployments[deployment] = i.ID
+			entry.Instances += len(usedBy(i.UsedBy))
+		}
+	}
+
+	flavorCatalog := []models.FlavorCatalogEntry{}
+	for _, entry := range flavors {
+		flavorCatalog = append(flavorCatalog, *entry)
+	}
+	sort.Slice(flavorCatalog, func(i, j int) bool {
+		if flavorCatalog[i].Name != flavorCatalog[j].Name {
+			return flavorCatalog[i].Name < flavorCatalog[j].Name
+		}
+		return flavorCatalog[i].VCPUs < flavorCatalog[j].VCPUs
+	})
+
+	imageCatalog := []models.ImageCatalogEntry{}
+	for _, entry := range images {
+		imageCatalog = append(imageCatalog, *entry)
+	}
+	sort.Slice(imageCatalog, func(i, j int) bool {
+		return imageCatalog[i].Name < imageCatalog[j].Name
+	})
+	return flavorCatalog, imageCatalog
+}
+
+// getGlobalSnapshots returns the usage snapshots of the deployments
+// and their totals, sorted by day
+func getGlobalSnapshots(deployments []string, a *access) ([]models.Snapshot, map[string][]models.Snapshot) {
+	defer utils.TimeTrack(time.Now(), getGlobalSnapshots)
+
+	days := make(map[string]*models.Snapshot)
+	perDeployment := make(map[string][]models.Snapshot, len(deployments))
+
+	for _, deployment := range deployments {
+		snapshots := restrictSnapshots(deployment, a, listSnapshots(deployment))
+		for _, s := range snapshots {
+			if days[s.ID] == nil {
+				days[s.ID] = &models.Snapshot{ID: s.ID}
+			}
+			days[s.ID].Add(s)
+		}
+		perDeployment[deployment] = snapshots
+	}
+
+	totals := make([]models.Snapshot, 0, len(days))
+	for _, s := range days {
+		totals = append(totals, *s)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].ID < totals[j].ID })
+	return totals, perDeployment
+}
+
+// restrictSnapshots drops the instances and projects of the snapshots
+// for the callers restricted to some projects of the deployment. The
+// snapshots count them for the whole deployment
+func restrictSnapshots(deployment string, a *access, snapshots []models.Snapshot) []models.Snapshot {
+	if a.allProjects(deployment) {
+		return snapshots
+	}
+	for i := range snapshots {
+		snapshots[i].Instances = 0
+		snapshots[i].Projects = 0
+	}
+	return snapshots
+}
+
+// publicSnapshots returns the v1 representation of the snapshots per day
+func publicSnapshots(snapshots []models.Snapshot) map[string]interface{} {
+	public := make(map[string]interface{}, len(snapshots))
+	for _, s := range snapshots {
+		public[s.ID] = s.Public()
+	}
+	return public
+}
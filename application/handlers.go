@@ -13,21 +13,28 @@ package application
 
 import (
 	"fmt"
-
+	"ossia/middleware"
+	"ossia/models"
+	"ossia/scheduler"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
 	"github.com/kataras/iris/v12"
 )
 
 // Default Handlers
 
 func defaultHandler(c iris.Context) {
-	c.JSON(iris.Map{"message": "ok"})
+	render(c, iris.Map{"message": "ok"})
 
 }
 
 func notFoundHandler(c iris.Context) {
-	c.JSON(iris.Map{
-		"message": "Path not found.",
-	})
+	apiError(c, iris.StatusNotFound, "Path not found.")
 
 }
 
@@ -41,12 +48,44 @@ func notFoundHandler(c iris.Context) {
 // Returns all registered OpenStack Deployments
 //
 // ---
+// parameters:
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: Available Deployments
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployments:
 //           description: List of registered deployments
 //           type: array
@@ -54,10 +93,37 @@ func notFoundHandler(c iris.Context) {
 //             type: string
 //   '501':
 //      description: Application Issue
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 func deploymentsHandler(c iris.Context) {
-	c.JSON(iris.Map{
-		"deployments": listDeployments(),
-	})
+	lq, err := newListQuery(c, "")
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	deployments := []string{}
+	for _, d := range listDeployments() {
+		if getAccess(c).canRead(d) {
+			deployments = append(deployments, d)
+		}
+	}
+
+	page, next, err := lq.page(deployments)
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	response := iris.Map{}
+	lq.respond(c, response, "deployments", page, next)
+	render(c, response)
 }
 
 // projectsHandler represents OpenStack projects view
@@ -75,12 +141,43 @@ func deploymentsHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Projects"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -89,6 +186,14 @@ func deploymentsHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Project'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -107,15 +212,25 @@ func projectsHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		projects := listProjects(deployment)
+		lq, err := newListQuery(c, models.Project{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		projects := []models.Project{}
+		next, err := findResources(deployment, lq, &projects, getAccess(c).projectMatcher(deployment))
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
 		c.StatusCode(iris.StatusOK)
 		response = iris.Map{
 			"deployment": deployment,
-			"projects":   projects,
 		}
+		lq.respond(c, response, "projects", projects, next)
 	}
 
-	c.JSON(response)
+	render(c, response)
 }
 
 // instancesHandler represents OpenStack instances view
@@ -133,12 +248,43 @@ func projectsHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Instances"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -147,6 +293,14 @@ func projectsHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Instance'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -165,14 +319,24 @@ func instancesHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		instances := listInstances(deployment, "")
+		lq, err := newListQuery(c, models.Instance{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		instances := []models.Instance{}
+		next, err := findResources(deployment, lq, &instances, getAccess(c).instanceMatcher(deployment))
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
 		c.StatusCode(iris.StatusOK)
 		response = iris.Map{
 			"deployment": deployment,
-			"instances":  instances,
 		}
+		lq.respond(c, response, "instances", instances, next)
 	}
-	c.JSON(response)
+	render(c, response)
 }
 
 // projectInstancesHandler represents OpenStack instances view for
@@ -193,16 +357,47 @@ func instancesHandler(c iris.Context) {
 //    example: tm-lab-1a
 //  - name: project
 //    in: path
-//    description: OpenStack Project Name
+//    description: OpenStack Project ID or Name
 //    type: string
 //    required: true
 //    example: rtb
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Instances"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -211,6 +406,14 @@ func instancesHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Instance'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment or project"
 //     schema:
@@ -219,6 +422,19 @@ func instancesHandler(c iris.Context) {
 //         message:
 //           type: string
 //           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
 func projectInstancesHandler(c iris.Context) {
 
 	deployment := c.Params().Get("deployment")
@@ -230,23 +446,30 @@ func projectInstancesHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		if projectExists(deployment, project) {
-			instances := filterInstancesByProject(deployment, project)
+		p, err := getProject(deployment, newObjectRef(c, project))
+		if err == nil {
+			lq, err := newListQuery(c, models.Instance{})
+			if err != nil {
+				listQueryError(c, err)
+				return
+			}
+			instances := []models.Instance{}
+			next, err := findResources(deployment, lq, &instances, q.Eq("ProjectID", p.ID))
+			if err != nil {
+				listQueryError(c, err)
+				return
+			}
 			c.StatusCode(iris.StatusOK)
 			response = iris.Map{
-				"deployment":                         deployment,
-				fmt.Sprintf("%s:instances", project): instances,
+				"deployment": deployment,
 			}
+			lq.respond(c, response, fmt.Sprintf("%s:instances", project), instances, next)
 		} else {
-			c.StatusCode(iris.StatusNotFound)
-			response = iris.Map{
-				"message": fmt.Sprintf("Project %s not found", project),
-			}
-
+			response = lookupError(c, "Project", project, err)
 		}
 
 	}
-	c.JSON(response)
+	render(c, response)
 }
 
 // instancesHandler represents OpenStack instances view
@@ -270,12 +493,43 @@ func projectInstancesHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: instance
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Instances"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -284,6 +538,14 @@ func projectInstancesHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Instance'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -302,16 +564,30 @@ func filterInstancesHandler(c iris.Context) {
 		"message": fmt.Sprintf("Deployment %s not found", deployment),
 	}
 	if deploymentRegistered(deployment) {
-		instances := listInstances(deployment, name)
+		lq, err := newListQuery(c, models.Instance{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		instances := []models.Instance{}
+		next, err := findResources(deployment, lq, &instances,
+			getAccess(c).instanceMatcher(deployment),
+			matchFunc(func(v reflect.Value) bool {
+				return strings.Contains(v.FieldByName("Name").String(), name)
+			}))
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
 
 		response = iris.Map{
 			"deployment": deployment,
-			"instances":  instances,
 		}
+		lq.respond(c, response, "instances", instances, next)
 		c.StatusCode(iris.StatusOK)
 	}
 
-	c.JSON(response)
+	render(c, response)
 }
 
 // clustersHandler represents OpenStack instances view
@@ -365,7 +641,7 @@ func clustersHandler(c iris.Context) {
 
 	if deploymentRegistered(deployment) {
 
-		instances := listInstances(deployment, "")
+		instances := getAccess(c).filterInstances(deployment, listInstances(deployment, ""))
 		clusters := make(map[string]int, len(instances))
 		for _, i := range instances {
 			_, ok := clusters[i.Metadata["cluster"]]
@@ -383,7 +659,7 @@ func clustersHandler(c iris.Context) {
 		}
 	}
 
-	c.JSON(response)
+	render(c, response)
 }
 
 // imagesHandler represents OpenStack images view
@@ -401,12 +677,43 @@ func clustersHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Images"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -415,6 +722,14 @@ func clustersHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Image'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -432,15 +747,29 @@ func imagesHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		images := listImages(deployment)
+		lq, err := newListQuery(c, models.Image{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		images := []models.Image{}
+		next, err := findResources(deployment, lq, &images)
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		filter := getAccess(c).instanceNameFilter(deployment)
+		for i := range images {
+			images[i].UsedBy = filter(images[i].UsedBy)
+		}
 
 		response = iris.Map{
 			"deployment": deployment,
-			"images":     images,
 		}
+		lq.respond(c, response, "images", images, next)
 		c.StatusCode(iris.StatusOK)
 	}
-	c.JSON(response)
+	render(c, response)
 }
 
 // hypervisorsHandler represents OpenStack hypervisors view
@@ -458,12 +787,43 @@ func imagesHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Hypervisors"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -472,6 +832,14 @@ func imagesHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Hypervisor'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -489,15 +857,30 @@ func hypervisorsHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		hypervisors := listHypervisors(deployment)
+		lq, err := newListQuery(c, models.Hypervisor{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		hypervisors := []models.Hypervisor{}
+		next, err := findResources(deployment, lq, &hypervisors)
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		addHypervisorVMs(deployment, hypervisors)
+		filter := getAccess(c).instanceNameFilter(deployment)
+		for i := range hypervisors {
+			hypervisors[i].VMs = filter(hypervisors[i].VMs)
+		}
 
 		response = iris.Map{
-			"deployment":  deployment,
-			"hypervisors": hypervisors,
+			"deployment": deployment,
 		}
+		lq.respond(c, response, "hypervisors", hypervisors, next)
 		c.StatusCode(iris.StatusOK)
 	}
-	c.JSON(response)
+	render(c, response)
 }
 
 // hypervisorsEmptyHandler returns Empty OpenStack Hypervisors
@@ -515,12 +898,43 @@ func hypervisorsHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Hypervisors"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -529,6 +943,14 @@ func hypervisorsHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/EmptyHypervisor'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -547,6 +969,12 @@ func hypervisorsEmptyHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
+		lq, err := newListQuery(c, "")
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+
 		//var emptyHypervisors []models.EmptyHypervisor
 		var emptyHypervisors []string
 		hypervisors := listEmptyHypervisors(deployment)
@@ -556,15 +984,21 @@ func hypervisorsEmptyHandler(c iris.Context) {
 			emptyHypervisors = append(emptyHypervisors, h.Hostname)
 		}
 
+		page, next, err := lq.page(emptyHypervisors)
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+
 		response = iris.Map{
-			"deployment":        deployment,
-			"total_empty":       len(emptyHypervisors),
-			"empty_hypervisors": emptyHypervisors,
+			"deployment":  deployment,
+			"total_empty": len(emptyHypervisors),
 		}
+		lq.respond(c, response, "empty_hypervisors", page, next)
 		c.StatusCode(iris.StatusOK)
 	}
 
-	c.JSON(response)
+	render(c, response)
 
 }
 
@@ -583,12 +1017,43 @@ func hypervisorsEmptyHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Flavors"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -597,6 +1062,14 @@ func hypervisorsEmptyHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Flavor'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -614,16 +1087,26 @@ func flavorsHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		flavors := listFlavors(deployment)
+		lq, err := newListQuery(c, models.Flavor{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		flavors := []models.Flavor{}
+		next, err := findResources(deployment, lq, &flavors)
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
 
 		response = iris.Map{
 			"deployment": deployment,
-			"flavors":    flavors,
 		}
+		lq.respond(c, response, "flavors", flavors, next)
 		c.StatusCode(iris.StatusOK)
 	}
 
-	c.JSON(response)
+	render(c, response)
 }
 
 // aggregatesHandler represents OpenStack aggregates view
@@ -641,12 +1124,43 @@ func flavorsHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
 //     description: "List of OpenStack Aggregates"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
@@ -655,6 +1169,14 @@ func flavorsHandler(c iris.Context) {
 //           type: array
 //           items:
 //             $ref: '#/definitions/Aggregate'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -672,16 +1194,26 @@ func aggregatesHandler(c iris.Context) {
 	}
 
 	if deploymentRegistered(deployment) {
-		aggregates := listAggregates(deployment)
+		lq, err := newListQuery(c, models.Aggregate{})
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		aggregates := []models.Aggregate{}
+		next, err := findResources(deployment, lq, &aggregates)
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
 
 		response = iris.Map{
 			"deployment": deployment,
-			"aggregates": aggregates,
 		}
+		lq.respond(c, response, "aggregates", aggregates, next)
 		c.StatusCode(iris.StatusOK)
 	}
 
-	c.JSON(response)
+	render(c, response)
 }
 
 // OpenStack Resource handlers implemenation (by resource name)
@@ -726,6 +1258,9 @@ func aggregatesHandler(c iris.Context) {
 //         images:
 //           description: Number of Flavors for the deployment
 //           type: integer
+//         circuit_breaker:
+//           description: Circuit Breaker state of the deployment
+//           type: object
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -742,29 +1277,24 @@ func deploymentHandler(c iris.Context) {
 		"message": fmt.Sprintf("Deployment %s not found", deployment),
 	}
 	c.StatusCode(iris.StatusNotFound)
-	for _deployment := range Cfg.Deployments {
+	for _deployment := range Cfg().Deployments {
 
 		if _deployment == deployment {
-			images := listImages(deployment)
-			flavors := listFlavors(deployment)
-			projects := listProjects(deployment)
-			instances := listInstances(deployment, "")
-			hypervisors := listHypervisors(deployment)
-
 			response = iris.Map{
-				"images":      len(images),
-				"flavors":     len(flavors),
-				"projects":    len(projects),
-				"instances":   len(instances),
-				"deployment":  deployment,
-				"hypervisors": len(hypervisors),
+				"images":          countResources(deployment, &models.Image{}),
+				"flavors":         countResources(deployment, &models.Flavor{}),
+				"projects":        countResources(deployment, &models.Project{}, getAccess(c).projectMatcher(deployment)),
+				"instances":       countResources(deployment, &models.Instance{}, getAccess(c).instanceMatcher(deployment)),
+				"deployment":      deployment,
+				"hypervisors":     countResources(deployment, &models.Hypervisor{}),
+				"circuit_breaker": Breaker(deployment).Status(),
 			}
 			c.StatusCode(iris.StatusOK)
 		}
 
 	}
 
-	c.JSON(response)
+	render(c, response)
 
 }
 
@@ -814,10 +1344,10 @@ func deploymentSnapshotHandler(c iris.Context) {
 	}
 	c.StatusCode(iris.StatusNotFound)
 
-	for _deployment := range Cfg.Deployments {
+	for _deployment := range Cfg().Deployments {
 
 		if _deployment == deployment {
-			snapshots, err := getSnapshots(deployment)
+			snapshots, err := getSnapshots(deployment, getAccess(c))
 			if err != nil {
 				response = iris.Map{
 					"message": fmt.Sprintf("No Usage Snapshots for the %s deployment", deployment),
@@ -828,13 +1358,14 @@ func deploymentSnapshotHandler(c iris.Context) {
 					"usage_snapshots": snapshots,
 					"deployment":      deployment,
 				}
+				setRows(c, keyedRows("Day", snapshots))
+				c.StatusCode(iris.StatusOK)
 			}
-			c.StatusCode(iris.StatusOK)
 		}
 
 	}
 
-	c.JSON(response)
+	render(c, response)
 }
 
 // deploymentUpdateHandler triggers deployment Update
@@ -842,7 +1373,8 @@ func deploymentSnapshotHandler(c iris.Context) {
 //
 // Update OpenStack Deployment
 //
-// Returns 200 on succcess
+// Queues the update job and returns 202 on succcess.
+// Duplicate requests are merged into the job in progress
 //
 // ---
 // parameters:
@@ -853,14 +1385,16 @@ func deploymentSnapshotHandler(c iris.Context) {
 //    required: true
 //    example: tm-lab-1a
 // responses:
-//   '200':
-//     description: "Returns 200 on success"
+//   '202':
+//     description: "Returns 202 on success"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           description: Success Message
 //           type: string
+//         job:
+//           $ref: '#/definitions/Job'
 //   '404':
 //     description: "Returns 404 Code if there is no deployment"
 //     schema:
@@ -883,29 +1417,35 @@ func deploymentUpdateHandler(c iris.Context) {
 			"message": fmt.Sprintf("Deployment %s not found", deployment),
 		}
 		c.StatusCode(iris.StatusNotFound)
-		for _deployment := range Cfg.Deployments {
+		for _deployment := range Cfg().Deployments {
 
 			if _deployment == deployment {
-				go updateDeployment(deployment)
-				c.StatusCode(iris.StatusOK)
+				job, merged := submitJob(deployment, nil)
+				message := fmt.Sprintf("Triggered update for %s deployment", deployment)
+				if merged {
+					message = fmt.Sprintf("Update for %s deployment is already in progress", deployment)
+				}
+				c.StatusCode(iris.StatusAccepted)
 				response = iris.Map{
-					"message": fmt.Sprintf("Triggered update for %s deployment", deployment),
+					"message": message,
+					"job":     job,
 				}
 
 			}
 		}
 
 	}
-	c.JSON(response)
+	render(c, response)
 
 }
 
-// imageHandler returns OpenStack Image Object
-// swagger:operation GET /deployment/{deployment}/image/{image} resources getImage
+// resourceUpdateHandler triggers Update of a single resource type
+// swagger:operation POST /deployment/{deployment}/update/{resource} resources updateResource
 //
-// OpenStack Image
+// Update OpenStack Resource Type
 //
-// Returns OpenStack Image Object
+// Queues the update job for a single resource type and returns 202 on succcess.
+// Collectors the resource type depends on are not refreshed
 //
 // ---
 // parameters:
@@ -915,69 +1455,71 @@ func deploymentUpdateHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
-//  - name: image
+//  - name: resource
 //    in: path
-//    description: OpenStack Image Name
+//    description: Resource Type
 //    type: string
 //    required: true
-//    example: ubuntu-18.04-x86_64
+//    enum: [projects, aggregates, flavors, hypervisors, instances, images]
+//    example: instances
 // responses:
-//   '200':
-//     description: "Returns OpenStack Image"
+//   '202':
+//     description: "Returns 202 on success"
 //     schema:
 //       type: object
 //       properties:
-//         deployment:
-//           description: Name of the deployment
+//         message:
+//           description: Success Message
 //           type: string
-//         "image:image_name":
-//           $ref: '#/definitions/Image'
+//         job:
+//           $ref: '#/definitions/Job'
 //   '404':
-//     description: "Returns 404 Code if there is no deployment or image"
+//     description: "Returns 404 Code if there is no deployment or resource type"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func imageHandler(c iris.Context) {
+func resourceUpdateHandler(c iris.Context) {
+
 	deployment := c.Params().Get("deployment")
-	imageName := c.Params().Get("image")
+	resource := c.Params().Get("resource")
 
+	c.StatusCode(iris.StatusNotFound)
 	response := iris.Map{
 		"message": fmt.Sprintf("Deployment %s not found", deployment),
 	}
-	c.StatusCode(iris.StatusNotFound)
 
-	if deploymentRegistered(deployment) {
-		image, err := getImage(deployment, imageName)
-		if err != nil {
-			if err.Error() == "not found" {
-				response = iris.Map{"message": fmt.Sprintf("Image %s not found", imageName)}
-				c.StatusCode(iris.StatusNotFound)
-			} else {
-				c.StatusCode(iris.StatusInternalServerError)
-				response = iris.Map{"message": err.Error()}
+	if _, ok := Cfg().Deployments[deployment]; ok {
+		response = iris.Map{
+			"message": fmt.Sprintf("Resource type %s not found", resource),
+		}
+		if _, ok := getCollector(resource); ok {
+			job, merged := submitJob(deployment, []string{resource})
+			message := fmt.Sprintf("Triggered %s update for %s deployment", resource, deployment)
+			if merged {
+				message = fmt.Sprintf("Update of %s for %s deployment is already in progress", resource, deployment)
 			}
-
-		} else {
+			c.StatusCode(iris.StatusAccepted)
 			response = iris.Map{
-				"deployment":                        deployment,
-				fmt.Sprintf("image:%s", image.Name): image,
+				"message": message,
+				"job":     job,
 			}
-			c.StatusCode(iris.StatusOK)
 		}
 	}
-	c.JSON(response)
+	render(c, response)
 
 }
 
-// projectHandler returns OpenStack Project Object
-// swagger:operation GET /deployment/{deployment}/project/{project} resources getProject
+// objectUpdateHandler refreshes a single Inventory object
+// swagger:operation POST /deployment/{deployment}/update/{resource}/{object} resources updateObject
 //
-// OpenStack Project
+// Update OpenStack Object
 //
-// Returns OpenStack Project Object
+// Refreshes a single object by its ID or name from OpenStack API
+// and returns it. The object is removed from the Inventory
+// if OpenStack API does not return it anymore
 //
 // ---
 // parameters:
@@ -987,141 +1529,162 @@ func imageHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
-//  - name: project
+//  - name: resource
 //    in: path
-//    description: OpenStack Project Name
+//    description: Resource Type
 //    type: string
 //    required: true
-//    example: admin
+//    enum: [projects, aggregates, flavors, hypervisors, instances, images]
+//    example: instances
+//  - name: object
+//    in: path
+//    description: Object ID or Name (Hostname for hypervisors)
+//    type: string
+//    required: true
+//  - name: project
+//    in: query
+//    description: Project ID or Name of the instance, if the name is not unique
+//    type: string
+//    required: false
 // responses:
 //   '200':
-//     description: "Returns OpenStack Project"
+//     description: "Refreshed Object"
 //     schema:
 //       type: object
 //       properties:
-//         deployment:
-//           description: Name of the deployment
-//           type: string
-//         "project:project_name":
-//           $ref: '#/definitions/Project'
+//         object:
+//           type: object
 //   '404':
-//     description: "Returns 404 Code if there is no deployment or project"
+//     description: "Returns 404 Code if there is no deployment, resource type or object"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func projectHandler(c iris.Context) {
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           items:
+//             type: string
+//   '503':
+//     description: "Returns 503 Code if OpenStack API is unavailable"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func objectUpdateHandler(c iris.Context) {
+
 	deployment := c.Params().Get("deployment")
-	projectName := c.Params().Get("project")
+	resource := c.Params().Get("resource")
+	object := c.Params().Get("object")
 
+	c.StatusCode(iris.StatusNotFound)
 	response := iris.Map{
 		"message": fmt.Sprintf("Deployment %s not found", deployment),
 	}
-	c.StatusCode(iris.StatusNotFound)
 
-	if deploymentRegistered(deployment) {
+	if _, ok := Cfg().Deployments[deployment]; !ok {
+		render(c, response)
+		return
+	}
 
-		project, err := getProject(deployment, projectName)
-		if err != nil {
-			if err.Error() == "not found" {
-				response = iris.Map{"message": fmt.Sprintf("Project %s not found", projectName)}
-				c.StatusCode(iris.StatusNotFound)
-			} else {
-				c.StatusCode(iris.StatusInternalServerError)
-				response = iris.Map{"message": err.Error()}
-			}
+	collector, _ := getCollector(resource)
+	objectCollector, ok := collector.(ObjectCollector)
+	if !ok {
+		response["message"] = fmt.Sprintf("Resource type %s not found", resource)
+		render(c, response)
+		return
+	}
 
-		} else {
-			c.StatusCode(iris.StatusOK)
-			response = iris.Map{
-				"deployment":                            deployment,
-				fmt.Sprintf("project:%s", project.Name): project,
-			}
+	r, err := refreshObject(deployment, objectCollector, newObjectRef(c, object))
+	switch e := err.(type) {
+	case nil:
+		c.StatusCode(iris.StatusOK)
+		response = iris.Map{
+			"message": fmt.Sprintf("Refreshed %s %s", resource, object),
+			"object":  r,
+		}
+	case *ambiguousError:
+		c.StatusCode(iris.StatusConflict)
+		response = iris.Map{
+			"message":    fmt.Sprintf("Name %s is ambiguous, use one of the IDs", object),
+			"candidates": e.candidates,
+		}
+	default:
+		response["message"] = fmt.Sprintf("Object %s not found", object)
+		if err != storm.ErrNotFound {
+			c.StatusCode(iris.StatusServiceUnavailable)
+			response["message"] = fmt.Sprintf("Unable to refresh %s: %v", object, err)
 		}
-
 	}
-	c.JSON(response)
+	render(c, response)
+
 }
 
-// flavorHandler returns OpenStack Flavor Object
-// swagger:operation GET /deployment/{deployment}/flavor/{flavor} resources getFlavor
+// jobHandler returns the update Job
+// swagger:operation GET /jobs/{id} jobs getJob
 //
-// OpenStack Flavor
+// Update Job
 //
-// Returns OpenStack Flavor Object
+// Returns the update Job status and results per collector
 //
 // ---
 // parameters:
-//  - name: deployment
-//    in: path
-//    description: OpenStack Deployment Name
-//    type: string
-//    required: true
-//    example: tm-lab-1a
-//  - name: flavor
+//  - name: id
 //    in: path
-//    description: OpenStack Flavor Name
+//    description: Job ID
 //    type: string
 //    required: true
-//    example: m1.small
 // responses:
 //   '200':
-//     description: "Returns OpenStack Flavor"
+//     description: "Update Job"
 //     schema:
 //       type: object
 //       properties:
-//         deployment:
-//           description: Name of the deployment
-//           type: string
-//         "flavor:flavor_name":
-//           $ref: '#/definitions/Flavor'
+//         job:
+//           $ref: '#/definitions/Job'
 //   '404':
-//     description: "Returns 404 Code if there is no deployment or flavor"
+//     description: "Returns 404 Code if there is no job"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func flavorHandler(c iris.Context) {
-	deployment := c.Params().Get("deployment")
-	flavorName := c.Params().Get("flavor")
+func jobHandler(c iris.Context) {
+	id := c.Params().Get("id")
 
+	c.StatusCode(iris.StatusNotFound)
 	response := iris.Map{
-		"message": fmt.Sprintf("Deployment %s not found", deployment),
+		"message": fmt.Sprintf("Job %s not found", id),
 	}
-	c.StatusCode(iris.StatusNotFound)
-
-	if deploymentRegistered(deployment) {
-		flavor, err := getFlavor(deployment, flavorName)
 
-		if err != nil {
-			if err.Error() == "not found" {
-				response = iris.Map{"message": fmt.Sprintf("Flavor %s not found", flavorName)}
-				c.StatusCode(iris.StatusNotFound)
-			} else {
-				c.StatusCode(iris.StatusInternalServerError)
-				response = iris.Map{"message": err.Error()}
-			}
-		} else {
-			c.StatusCode(iris.StatusOK)
-			response = iris.Map{
-				"deployment":                          deployment,
-				fmt.Sprintf("flavor:%s", flavor.Name): flavor,
-			}
+	job, ok := getJob(id)
+	if ok && getAccess(c).canRead(job.Deployment) {
+		c.StatusCode(iris.StatusOK)
+		response = iris.Map{
+			"job": job,
 		}
 	}
-	c.JSON(response)
+	render(c, response)
 }
 
-// aggregateHandler returns OpenStack Aggregate Object
-// swagger:operation GET /deployment/{deployment}/aggregate/{aggregate} resources getAggregate
+// deploymentJobsHandler represents update jobs of the deployment
+// swagger:operation GET /deployment/{deployment}/jobs jobs listJobs
 //
-// OpenStack Aggregate
+// Deployment Update Jobs
 //
-// Returns OpenStack Aggregate Object
+// Returns recent update jobs of the deployment, newest first
 //
 // ---
 // parameters:
@@ -1131,320 +1694,2208 @@ func flavorHandler(c iris.Context) {
 //    type: string
 //    required: true
 //    example: tm-lab-1a
-//  - name: aggregate
-//    in: path
-//    description: OpenStack Aggregate Name
+//  - name: q
+//    in: query
+//    description: Filter expression
 //    type: string
-//    required: true
-//    example: staging
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
-//     description: "Returns OpenStack Aggregate"
+//     description: "List of Update Jobs"
 //     schema:
 //       type: object
 //       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
 //         deployment:
 //           description: Name of the deployment
 //           type: string
-//         "aggregate:aggregate_name":
-//           $ref: '#/definitions/Aggregate'
+//         jobs:
+//           type: array
+//           items:
+//             $ref: '#/definitions/Job'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
 //   '404':
-//     description: "Returns 404 Code if there is no deployment or aggregate"
+//     description: "Returns 404 Code if there is no deployment"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func aggregateHandler(c iris.Context) {
+func deploymentJobsHandler(c iris.Context) {
 	deployment := c.Params().Get("deployment")
-	aggregateName := c.Params().Get("aggregate")
 
+	c.StatusCode(iris.StatusNotFound)
 	response := iris.Map{
 		"message": fmt.Sprintf("Deployment %s not found", deployment),
 	}
-	c.StatusCode(iris.StatusNotFound)
 
 	if deploymentRegistered(deployment) {
-
-		aggregate, err := getAggregate(deployment, aggregateName)
-
+		lq, err := newListQuery(c, models.Job{})
 		if err != nil {
-			if err.Error() == "not found" {
-				response = iris.Map{"message": fmt.Sprintf("Aggregate %s not found", aggregateName)}
-				c.StatusCode(iris.StatusNotFound)
-			} else {
-				c.StatusCode(iris.StatusInternalServerError)
-				response = iris.Map{"message": err.Error()}
-			}
-		} else {
-			c.StatusCode(iris.StatusOK)
-			response = iris.Map{
-				"deployment": deployment,
-				fmt.Sprintf("aggregate:%s", aggregate.Name): aggregate,
-			}
+			listQueryError(c, err)
+			return
+		}
+		jobs, next, err := lq.page(listJobs(deployment))
+		if err != nil {
+			listQueryError(c, err)
+			return
+		}
+		c.StatusCode(iris.StatusOK)
+		response = iris.Map{
+			"deployment": deployment,
 		}
+		lq.respond(c, response, "jobs", jobs, next)
 	}
-	c.JSON(response)
+	render(c, response)
 }
 
-// hypervisorHandler returns OpenStack Hypervisor Object
-// swagger:operation GET /deployment/{deployment}/hypervisor/{hypervisor} resources getHypervisor
+// schedulerTasksHandler returns the scheduled tasks
+// swagger:operation GET /scheduler/tasks scheduler listTasks
 //
-// OpenStack Hypervisor
+// Scheduled Tasks
 //
-// Returns OpenStack Hypervisor Object
+// Returns the scheduled tasks with their last and next runs
 //
 // ---
 // parameters:
 //  - name: deployment
-//    in: path
+//    in: query
 //    description: OpenStack Deployment Name
 //    type: string
-//    required: true
+//    required: false
 //    example: tm-lab-1a
-//  - name: hypervisor
-//    in: path
-//    description: OpenStack Hypervisor Name
+//  - name: q
+//    in: query
+//    description: Filter expression
 //    type: string
-//    required: true
-//    example: cn07-1a
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
 // responses:
 //   '200':
-//     description: "Returns OpenStack Hypervisor"
+//     description: "Scheduled Tasks"
 //     schema:
 //       type: object
 //       properties:
-//         deployment:
-//           description: Name of the deployment
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
 //           type: string
-//         "hypervisor:hypervisor_name":
-//           $ref: '#/definitions/Hypervisor'
-//   '404':
-//     description: "Returns 404 Code if there is no deployment or hypervisor"
+//         tasks:
+//           type: array
+//           items:
+//             $ref: '#/definitions/ScheduledTask'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func hypervisorHandler(c iris.Context) {
-	deployment := c.Params().Get("deployment")
-	hostname := c.Params().Get("hostname")
+func schedulerTasksHandler(c iris.Context) {
+	deployment := c.URLParam("deployment")
 
-	response := iris.Map{
-		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	lq, err := newListQuery(c, models.ScheduledTask{})
+	if err != nil {
+		listQueryError(c, err)
+		return
 	}
-	c.StatusCode(iris.StatusNotFound)
-
-	if deploymentRegistered(deployment) {
-
-		hypervisor, err := getHypervisor(deployment, hostname)
 
-		if err != nil {
-			if err.Error() == "not found" {
-				response = iris.Map{"message": fmt.Sprintf("Hypervisor %s not found", hostname)}
-				c.StatusCode(iris.StatusNotFound)
-			} else {
-				c.StatusCode(iris.StatusInternalServerError)
-				response = iris.Map{"message": err.Error()}
-			}
-		} else {
-			c.StatusCode(iris.StatusOK)
-			response = iris.Map{
-				"deployment":                           deployment,
-				fmt.Sprintf("hypervisor:%s", hostname): hypervisor,
-			}
+	tasks := []models.ScheduledTask{}
+	for _, t := range scheduler.Tasks() {
+		if !getAccess(c).taskAllowed(middleware.ScopeRead, t) {
+			continue
 		}
+		if deployment == "" || t.Deployment == deployment {
+			tasks = append(tasks, t)
+		}
+	}
+
+	page, next, err := lq.page(tasks)
+	if err != nil {
+		listQueryError(c, err)
+		return
 	}
-	c.JSON(response)
 
+	response := iris.Map{}
+	lq.respond(c, response, "tasks", page, next)
+	c.StatusCode(iris.StatusOK)
+	render(c, response)
 }
 
-// instanceHandler returns OpenStack Instance Object
-// swagger:operation GET /deployment/{deployment}/instance/{instance} resources getInstance
+// schedulerTaskHandler returns the scheduled task
+// swagger:operation GET /scheduler/tasks/{name} scheduler getTask
 //
-// OpenStack Instance
+// Scheduled Task
 //
-// Returns OpenStack Instance Object
+// Returns the scheduled task with its last and next runs
 //
 // ---
 // parameters:
-//  - name: deployment
-//    in: path
-//    description: OpenStack Deployment Name
-//    type: string
-//    required: true
-//    example: tm-lab-1a
-//  - name: instance
+//  - name: name
 //    in: path
-//    description: OpenStack Instance Name
+//    description: Task Name
 //    type: string
 //    required: true
-//    example: instance01
+//    example: tm-lab-1a:instances
 // responses:
 //   '200':
-//     description: "Returns OpenStack Instance"
+//     description: "Scheduled Task"
 //     schema:
 //       type: object
 //       properties:
-//         deployment:
-//           description: Name of the deployment
-//           type: string
-//         "instance:instance_name":
-//           $ref: '#/definitions/Instance'
+//         task:
+//           $ref: '#/definitions/ScheduledTask'
 //   '404':
-//     description: "Returns 404 Code if there is no deployment or instance"
+//     description: "Returns 404 Code if there is no task"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func instanceHandler(c iris.Context) {
-	deployment := c.Params().Get("deployment")
-	instanceName := c.Params().Get("instance")
+func schedulerTaskHandler(c iris.Context) {
+	name := c.Params().Get("name")
 
+	c.StatusCode(iris.StatusNotFound)
 	response := iris.Map{
-		"message": fmt.Sprintf("Deployment %s not found", deployment),
+		"message": fmt.Sprintf("Task %s not found", name),
 	}
-	c.StatusCode(iris.StatusNotFound)
 
-	if deploymentRegistered(deployment) {
-		instance, err := getInstance(deployment, instanceName)
-
-		if err != nil {
-			if err.Error() == "not found" {
-				response = iris.Map{"message": fmt.Sprintf("Instance %s not found", instanceName)}
-				c.StatusCode(iris.StatusNotFound)
-			} else {
-				c.StatusCode(iris.StatusInternalServerError)
-				response = iris.Map{"message": err.Error()}
-			}
-		} else {
-			c.StatusCode(iris.StatusOK)
-			response = iris.Map{
-				"deployment":                             deployment,
-				fmt.Sprintf("instance:%s", instanceName): instance,
-			}
+	task, err := scheduler.GetTask(name)
+	if err == nil && getAccess(c).taskAllowed(middleware.ScopeRead, task) {
+		c.StatusCode(iris.StatusOK)
+		response = iris.Map{
+			"task": task,
 		}
 	}
-	c.JSON(response)
-
+	render(c, response)
 }
 
-// clusterHandler returns OpenStack Instances
-// filtered by Metadata Cluster Key
-// swagger:operation GET /deployment/{deployment}/instances/cluster/{cluster} resources listClusters
+// schedulerTaskActionHandler pauses, resumes or triggers the task
+// swagger:operation POST /scheduler/tasks/{name}/{action} scheduler controlTask
 //
-// OpenStack Instances Count by Metadata Cluster key
+// Scheduled Task Control
 //
-// Returns OpenStack Instances Count by Metadata Cluster key
+// Pauses or resumes periodic runs of the task, or triggers a run now.
+// A paused task can still be triggered
 //
 // ---
 // parameters:
-//  - name: deployment
+//  - name: name
 //    in: path
-//    description: OpenStack Deployment Name
+//    description: Task Name
 //    type: string
 //    required: true
-//    example: tm-lab-1a
-//  - name: cluster
+//    example: tm-lab-1a:instances
+//  - name: action
 //    in: path
-//    description: Cluster Name
+//    description: Action
 //    type: string
 //    required: true
-//    example: instance
+//    enum: [pause, resume, trigger]
 // responses:
 //   '200':
-//     description: "Returns OpenStack Instances by cluster name"
+//     description: "Returns 200 on success"
 //     schema:
 //       type: object
 //       properties:
-//         deployment:
-//           description: Name of the deployment
+//         message:
+//           description: Success Message
 //           type: string
-//         "cluster:cluster_name":
-//           type: object
-//           properties:
-//             instance_name_with_ip:
-//               description: "Instance name and IP"
-//               type: string
+//         task:
+//           $ref: '#/definitions/ScheduledTask'
 //   '404':
-//     description: "Returns 404 Code if there is no deployment"
+//     description: "Returns 404 Code if there is no task or action"
 //     schema:
 //       type: object
 //       properties:
 //         message:
 //           type: string
 //           description: Error Message
-func clusterHandler(c iris.Context) {
-
-	deployment := c.Params().Get("deployment")
-	cluster := c.Params().Get("cluster")
+//   '409':
+//     description: "Returns 409 Code if the task is already running"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func schedulerTaskActionHandler(c iris.Context) {
+	name := c.Params().Get("name")
+	action := c.Params().Get("action")
+
+	if task, err := scheduler.GetTask(name); err == nil && !getAccess(c).taskAllowed(middleware.ScopeRefresh, task) {
+		c.StatusCode(iris.StatusForbidden)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Operation refresh is not allowed on task %s", name),
+		})
+		return
+	}
 
-	response := iris.Map{
-		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	var err error
+	var message string
+	switch action {
+	case "pause":
+		err = scheduler.PauseTask(name)
+		message = fmt.Sprintf("Paused task %s", name)
+	case "resume":
+		err = scheduler.ResumeTask(name)
+		message = fmt.Sprintf("Resumed task %s", name)
+	case "trigger":
+		err = scheduler.TriggerTask(name)
+		message = fmt.Sprintf("Triggered task %s", name)
+	default:
+		c.StatusCode(iris.StatusNotFound)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Action %s not found", action),
+		})
+		return
 	}
-	c.StatusCode(iris.StatusNotFound)
 
-	if deploymentRegistered(deployment) {
+	switch err {
+	case nil:
+		task, _ := scheduler.GetTask(name)
 		c.StatusCode(iris.StatusOK)
-		instances := listInstances(deployment, "")
-		members := make(map[string]string)
-
-		for _, i := range instances {
-			if i.Metadata["cluster"] == cluster {
-				members[i.Name] = i.FixedIPv4
-			}
-		}
-		response = iris.Map{
-			"deployment":                       deployment,
-			fmt.Sprintf("cluster:%s", cluster): members,
-		}
+		render(c, iris.Map{
+			"message": message,
+			"task":    task,
+		})
+	case scheduler.ErrTaskRunning:
+		c.StatusCode(iris.StatusConflict)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Task %s is already running", name),
+		})
+	default:
+		c.StatusCode(iris.StatusNotFound)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Task %s not found", name),
+		})
 	}
-	c.JSON(response)
 }
 
-// Admin and Monitoring Handlers
-
-// statusHandler returns application health status
-// swagger:operation GET /status application getStatus
+// imageHandler returns OpenStack Image Object
+// swagger:operation GET /deployment/{deployment}/image/{image} resources getImage
 //
-// OSSIA Operational Status
+// OpenStack Image
 //
-// Used for the monitoring purposes
+// Returns OpenStack Image Object
 //
 // ---
-// produces:
-//  - application/json
-// responses:
-//   '200':
-//     description: "Returns OSSIA Status"
-//     schema:
-//       type: object
-//       properties:
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: image
+//    in: path
+//    description: OpenStack Image ID or Name
+//    type: string
+//    required: true
+//    example: ubuntu-18.04-x86_64
+// responses:
+//   '200':
+//     description: "Returns OpenStack Image"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "image:image_name":
+//           $ref: '#/definitions/Image'
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or image"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
+func imageHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	imageName := c.Params().Get("image")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+		image, err := getImage(deployment, newObjectRef(c, imageName))
+		if err != nil {
+			response = lookupError(c, "Image", imageName, err)
+		} else {
+			image.UsedBy = getAccess(c).filterInstanceNames(deployment, image.UsedBy)
+			response = iris.Map{
+				"deployment":                        deployment,
+				fmt.Sprintf("image:%s", image.Name): image,
+			}
+			c.StatusCode(iris.StatusOK)
+		}
+	}
+	render(c, response)
+
+}
+
+// projectHandler returns OpenStack Project Object
+// swagger:operation GET /deployment/{deployment}/project/{project} resources getProject
+//
+// OpenStack Project
+//
+// Returns OpenStack Project Object
+//
+// ---
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: project
+//    in: path
+//    description: OpenStack Project ID or Name
+//    type: string
+//    required: true
+//    example: admin
+// responses:
+//   '200':
+//     description: "Returns OpenStack Project"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "project:project_name":
+//           $ref: '#/definitions/Project'
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or project"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
+func projectHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	projectName := c.Params().Get("project")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+
+		project, err := getProject(deployment, newObjectRef(c, projectName))
+		if err != nil {
+			response = lookupError(c, "Project", projectName, err)
+		} else {
+			c.StatusCode(iris.StatusOK)
+			response = iris.Map{
+				"deployment":                            deployment,
+				fmt.Sprintf("project:%s", project.Name): project,
+			}
+		}
+
+	}
+	render(c, response)
+}
+
+// flavorHandler returns OpenStack Flavor Object
+// swagger:operation GET /deployment/{deployment}/flavor/{flavor} resources getFlavor
+//
+// OpenStack Flavor
+//
+// Returns OpenStack Flavor Object
+//
+// ---
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: flavor
+//    in: path
+//    description: OpenStack Flavor ID or Name
+//    type: string
+//    required: true
+//    example: m1.small
+// responses:
+//   '200':
+//     description: "Returns OpenStack Flavor"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "flavor:flavor_name":
+//           $ref: '#/definitions/Flavor'
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or flavor"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
+func flavorHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	flavorName := c.Params().Get("flavor")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+		flavor, err := getFlavor(deployment, newObjectRef(c, flavorName))
+
+		if err != nil {
+			response = lookupError(c, "Flavor", flavorName, err)
+		} else {
+			c.StatusCode(iris.StatusOK)
+			response = iris.Map{
+				"deployment":                          deployment,
+				fmt.Sprintf("flavor:%s", flavor.Name): flavor,
+			}
+		}
+	}
+	render(c, response)
+}
+
+// aggregateHandler returns OpenStack Aggregate Object
+// swagger:operation GET /deployment/{deployment}/aggregate/{aggregate} resources getAggregate
+//
+// OpenStack Aggregate
+//
+// Returns OpenStack Aggregate Object
+//
+// ---
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: aggregate
+//    in: path
+//    description: OpenStack Aggregate ID or Name
+//    type: string
+//    required: true
+//    example: staging
+// responses:
+//   '200':
+//     description: "Returns OpenStack Aggregate"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "aggregate:aggregate_name":
+//           $ref: '#/definitions/Aggregate'
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or aggregate"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
+func aggregateHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	aggregateName := c.Params().Get("aggregate")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+
+		aggregate, err := getAggregate(deployment, newObjectRef(c, aggregateName))
+
+		if err != nil {
+			response = lookupError(c, "Aggregate", aggregateName, err)
+		} else {
+			c.StatusCode(iris.StatusOK)
+			response = iris.Map{
+				"deployment": deployment,
+				fmt.Sprintf("aggregate:%s", aggregate.Name): aggregate,
+			}
+		}
+	}
+	render(c, response)
+}
+
+// hypervisorHandler returns OpenStack Hypervisor Object
+// swagger:operation GET /deployment/{deployment}/hypervisor/{hypervisor} resources getHypervisor
+//
+// OpenStack Hypervisor
+//
+// Returns OpenStack Hypervisor Object
+//
+// ---
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: hypervisor
+//    in: path
+//    description: OpenStack Hypervisor ID or Name
+//    type: string
+//    required: true
+//    example: cn07-1a
+// responses:
+//   '200':
+//     description: "Returns OpenStack Hypervisor"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "hypervisor:hypervisor_name":
+//           $ref: '#/definitions/Hypervisor'
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or hypervisor"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
+func hypervisorHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	hostname := c.Params().Get("hostname")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+
+		hypervisor, err := getHypervisor(deployment, newObjectRef(c, hostname))
+
+		if err != nil {
+			response = lookupError(c, "Hypervisor", hostname, err)
+		} else {
+			hypervisor.VMs = getAccess(c).filterInstanceNames(deployment, hypervisor.VMs)
+			c.StatusCode(iris.StatusOK)
+			response = iris.Map{
+				"deployment": deployment,
+				fmt.Sprintf("hypervisor:%s", hypervisor.Hostname): hypervisor,
+			}
+		}
+	}
+	render(c, response)
+
+}
+
+// instanceHandler returns OpenStack Instance Object
+// swagger:operation GET /deployment/{deployment}/instance/{instance} resources getInstance
+//
+// OpenStack Instance
+//
+// Returns OpenStack Instance Object
+//
+// ---
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: instance
+//    in: path
+//    description: OpenStack Instance ID or Name
+//    type: string
+//    required: true
+//    example: instance01
+//  - name: project
+//    in: query
+//    description: Project ID or Name of the instance, if the name is not unique
+//    type: string
+//    required: false
+// responses:
+//   '200':
+//     description: "Returns OpenStack Instance"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "instance:instance_name":
+//           $ref: '#/definitions/Instance'
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or instance"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//   '409':
+//     description: "Returns 409 Code if the name matches several objects"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+//         candidates:
+//           type: array
+//           description: ID, Name and ProjectID of the matching objects
+//           items:
+//             type: object
+func instanceHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	instanceName := c.Params().Get("instance")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+		instance, err := getInstance(deployment, newObjectRef(c, instanceName))
+
+		if err != nil {
+			response = lookupError(c, "Instance", instanceName, err)
+		} else {
+			c.StatusCode(iris.StatusOK)
+			response = iris.Map{
+				"deployment": deployment,
+				fmt.Sprintf("instance:%s", instance.Name): instance,
+			}
+		}
+	}
+	render(c, response)
+
+}
+
+// clusterHandler returns OpenStack Instances
+// filtered by Metadata Cluster Key
+// swagger:operation GET /deployment/{deployment}/instances/cluster/{cluster} resources listClusters
+//
+// OpenStack Instances Count by Metadata Cluster key
+//
+// Returns OpenStack Instances Count by Metadata Cluster key
+//
+// ---
+// parameters:
+//  - name: deployment
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: cluster
+//    in: path
+//    description: Cluster Name
+//    type: string
+//    required: true
+//    example: instance
+// responses:
+//   '200':
+//     description: "Returns OpenStack Instances by cluster name"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           description: Name of the deployment
+//           type: string
+//         "cluster:cluster_name":
+//           type: object
+//           properties:
+//             instance_name_with_ip:
+//               description: "Instance name and IP"
+//               type: string
+//   '404':
+//     description: "Returns 404 Code if there is no deployment"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func clusterHandler(c iris.Context) {
+
+	deployment := c.Params().Get("deployment")
+	cluster := c.Params().Get("cluster")
+
+	response := iris.Map{
+		"message": fmt.Sprintf("Deployment %s not found", deployment),
+	}
+	c.StatusCode(iris.StatusNotFound)
+
+	if deploymentRegistered(deployment) {
+		c.StatusCode(iris.StatusOK)
+		instances := getAccess(c).filterInstances(deployment, listInstances(deployment, ""))
+		members := make(map[string]string)
+
+		for _, i := range instances {
+			if i.Metadata["cluster"] == cluster {
+				members[i.Name] = i.FixedIPv4
+			}
+		}
+		response = iris.Map{
+			"deployment":                       deployment,
+			fmt.Sprintf("cluster:%s", cluster): members,
+		}
+	}
+	render(c, response)
+}
+
+// globalSummaryHandler returns the fleet-wide usage and catalogs
+// swagger:operation GET /global/summary global getGlobalSummary
+//
+// Global Summary
+//
+// Returns the inventory and capacity totals of all deployments with
+// per-deployment breakdowns, and the flavor and image catalogs merged
+// with the deployments where each exists
+//
+// ---
+// responses:
+//   '200':
+//     description: "Returns the global summary"
+//     schema:
+//       type: object
+//       properties:
+//         deployments:
+//           description: List of summarized deployments
+//           type: array
+//           items:
+//             type: string
+//         totals:
+//           $ref: '#/definitions/Usage'
+//         per_deployment:
+//           description: Usage per deployment
+//           type: object
+//           additionalProperties:
+//             $ref: '#/definitions/Usage'
+//         flavors:
+//           type: array
+//           items:
+//             $ref: '#/definitions/FlavorCatalogEntry'
+//         images:
+//           type: array
+//           items:
+//             $ref: '#/definitions/ImageCatalogEntry'
+func globalSummaryHandler(c iris.Context) {
+	deployments := readableDeployments(getAccess(c))
+	totals, usage := getGlobalUsage(deployments, getAccess(c))
+	flavors, images := getCatalogs(deployments, getAccess(c))
+
+	rows := make(map[string]interface{}, len(usage))
+	for deployment, u := range usage {
+		rows[deployment] = u
+	}
+	setRows(c, keyedRows("Deployment", rows))
+
+	render(c, iris.Map{
+		"deployments":    deployments,
+		"totals":         totals,
+		"per_deployment": usage,
+		"flavors":        flavors,
+		"images":         images,
+	})
+}
+
+// globalSnapshotsHandler returns usage snapshots of all deployments
+// swagger:operation GET /global/snapshots global getGlobalSnapshots
+//
+// Global Usage Snapshots
+//
+// Returns the usage snapshots of all deployments summed per day,
+// with per-deployment breakdowns
+//
+// ---
+// responses:
+//   '200':
+//     description: "Returns usage snapshots"
+//     schema:
+//       type: object
+//       properties:
+//         deployments:
+//           description: List of summarized deployments
+//           type: array
+//           items:
+//             type: string
+//         usage_snapshots:
+//           description: Usage snapshots of all deployments per day
+//           type: object
+//           additionalProperties:
+//             $ref: '#/definitions/Snapshot'
+//         per_deployment:
+//           description: Usage snapshots per deployment and day
+//           type: object
+func globalSnapshotsHandler(c iris.Context) {
+	a := getAccess(c)
+	deployments := readableDeployments(a)
+	totals, perDeployment := getGlobalSnapshots(deployments, a)
+
+	snapshots := make(map[string]interface{}, len(perDeployment))
+	for deployment, s := range perDeployment {
+		snapshots[deployment] = publicSnapshots(s)
+	}
+
+	rows := []keyedRow{}
+	for _, deployment := range keyedRows("Deployment", snapshots) {
+		for _, day := range keyedRows("Day", deployment.value.(map[string]interface{})) {
+			rows = append(rows, keyedRow{name: "Deployment", key: deployment.key, value: day})
+		}
+	}
+	setRows(c, rows)
+
+	render(c, iris.Map{
+		"deployments":     deployments,
+		"usage_snapshots": publicSnapshots(totals),
+		"per_deployment":  snapshots,
+	})
+}
+
+// searchHandler looks up the Inventory of all deployments
+// swagger:operation GET /search search searchInventory
+//
+// Global Search
+//
+// Returns the resources of all deployments matching an ID, an IP or MAC
+// address or a metadata value exactly, or whose name starts with the query
+//
+// ---
+// parameters:
+//  - name: q
+//    in: query
+//    description: ID, IPv4/IPv6 or MAC address, metadata value or name prefix
+//    type: string
+//    required: true
+//    example: 10.0.0.12
+//  - name: type
+//    in: query
+//    description: Resource types to search (instance, image, flavor, project, aggregate, hypervisor)
+//    type: string
+//    required: false
+//    example: instance,hypervisor
+//  - name: deployment
+//    in: query
+//    description: Deployments to search, all if not set
+//    type: string
+//    required: false
+//    example: tm-lab-1a
+//  - name: limit
+//    in: query
+//    description: Maximum number of hits, exact matches first (default 100)
+//    type: integer
+//    required: false
+// responses:
+//   '200':
+//     description: "Returns matching resources"
+//     schema:
+//       type: object
+//       properties:
+//         query:
+//           description: Search query
+//           type: string
+//         truncated:
+//           description: Set if there are more hits than the limit
+//           type: boolean
+//         hits:
+//           type: array
+//           items:
+//             $ref: '#/definitions/SearchHit'
+//   '400':
+//     description: "Returns 400 Code if search parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func searchHandler(c iris.Context) {
+	query, opts, err := newSearchOptions(c)
+	if err != nil {
+		searchError(c, err.Error())
+		return
+	}
+
+	hits, truncated := searchInventory(query, opts)
+	if hits == nil {
+		hits = []models.SearchHit{}
+	}
+	setRows(c, hits)
+
+	render(c, iris.Map{
+		"query":     query,
+		"truncated": truncated,
+		"hits":      hits,
+	})
+}
+
+// searchError returns 400 for invalid search parameters
+func searchError(c iris.Context, message string) {
+	c.StatusCode(iris.StatusBadRequest)
+	render(c, iris.Map{
+		"message": fmt.Sprintf("Invalid search parameters: %s", message),
+	})
+}
+
+// eventsHandler streams the Inventory changes
+// swagger:operation GET /events events streamEvents
+//
+// Inventory Changes
+//
+// Streams the objects created, updated or deleted by the polls as
+// Server-Sent Events, or as WebSocket messages if the connection is
+// upgraded. Clients resume the stream from the last received event
+//
+// ---
+// produces:
+//  - text/event-stream
+// parameters:
+//  - name: deployment
+//    in: query
+//    description: Deployments of the events, all readable ones if not set
+//    type: string
+//    required: false
+//    example: tm-lab-1a
+//  - name: resource
+//    in: query
+//    description: Resource types of the events (instances, images, flavors, projects, aggregates, hypervisors)
+//    type: string
+//    required: false
+//    example: instances
+//  - name: project
+//    in: query
+//    description: Project IDs or names of the instances and projects
+//    type: string
+//    required: false
+//  - name: Last-Event-ID
+//    in: header
+//    description: ID of the last received event, the following events are sent first
+//    type: integer
+//    required: false
+//  - name: last_event_id
+//    in: query
+//    description: Same as the Last-Event-ID header, for WebSocket clients
+//    type: integer
+//    required: false
+// responses:
+//   '200':
+//     description: "Event stream, event data is an Event object. A reset event tells the client to reload the inventory"
+//     schema:
+//       $ref: '#/definitions/Event'
+//   '400':
+//     description: "Returns 400 Code if event filters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func eventsHandler(c iris.Context) {
+	filter, err := newEventFilter(getAccess(c), c.URLParam("deployment"), c.URLParam("resource"), c.URLParam("project"))
+	if err != nil {
+		c.StatusCode(iris.StatusBadRequest)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Invalid event filter: %v", err),
+		})
+		return
+	}
+	for deployment := range filter.deployments {
+		if !getAccess(c).canRead(deployment) {
+			apiError(c, iris.StatusForbidden, fmt.Sprintf("Operation read is not allowed on %s deployment", deployment))
+			return
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.URLParam("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			c.StatusCode(iris.StatusBadRequest)
+			render(c, iris.Map{
+				"message": fmt.Sprintf("Invalid last event ID %s", lastEventID),
+			})
+			return
+		}
+	}
+
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		serveWebSocket(c, filter, lastID)
+		return
+	}
+	serveSSE(c, filter, lastID)
+}
+
+// alertsHandler returns the alerts
+// swagger:operation GET /alerts alerts listAlerts
+//
+// Alerts
+//
+// Returns the pending, firing and recently resolved alerts of the rules
+// defined in the config file, within the readable deployments and projects
+//
+// ---
+// parameters:
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: state==firing and severity==critical
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: -fired
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: rule,state,name,summary
+// responses:
+//   '200':
+//     description: "Alerts"
+//     schema:
+//       type: object
+//       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
+//         alerts:
+//           type: array
+//           items:
+//             $ref: '#/definitions/Alert'
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func alertsHandler(c iris.Context) {
+	lq, err := newListQuery(c, models.Alert{})
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	a := getAccess(c)
+	projects := make(map[string]map[string]bool)
+	alerts := []models.Alert{}
+	for _, alert := range listAlerts() {
+		if !a.canRead(alert.Deployment) {
+			continue
+		}
+		if alert.Resource == "instances" || alert.Resource == "projects" {
+			ids, ok := projects[alert.Deployment]
+			if !ok {
+				ids = a.projectIDs(alert.Deployment)
+				projects[alert.Deployment] = ids
+			}
+			if ids != nil && !ids[alert.ProjectID] {
+				continue
+			}
+		}
+		alerts = append(alerts, alert)
+	}
+
+	page, next, err := lq.page(alerts)
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	response := iris.Map{}
+	lq.respond(c, response, "alerts", page, next)
+	render(c, response)
+}
+
+// Admin and Monitoring Handlers
+
+// statusHandler returns application health status
+// swagger:operation GET /status application getStatus
+//
+// OSSIA Operational Status
+//
+// Used for the monitoring purposes, served without authentication
+//
+// ---
+// security: []
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: "Returns OSSIA Status"
+//     schema:
+//       type: object
+//       properties:
+//         status:
+//           description: Current Status
+//           type: string
+func statusHandler(c iris.Context) {
+	render(c, iris.Map{
+		"status": "alive",
+	})
+
+}
+
+// statusDetailsHandler returns datastore metrics and circuit breakers
+// of the deployments visible to the caller
+// swagger:operation GET /status/details application getStatusDetails
+//
+// OSSIA Operational Status Details
+//
+// Returns datastore metrics and the circuit breaker state
+// of the deployments visible to the caller
+//
+// ---
+// produces:
+//  - application/json
+// responses:
+//   '200':
+//     description: "Returns OSSIA Status Details"
+//     schema:
+//       type: object
+//       properties:
 //         status:
 //           description: Current Status
 //           type: string
-//         datastore:
-//           description: DB Statistics
-//           type: object
-//           properties:
-//             metric:
-//               type: integer
+//         datastore:
+//           description: DB Statistics
+//           type: object
+//           properties:
+//             metric:
+//               type: integer
+//         circuit_breakers:
+//           description: Circuit Breaker state per deployment
+//           type: object
+func statusDetailsHandler(c iris.Context) {
+	render(c, iris.Map{
+		"status":           "alive",
+		"datastore":        datastoreMetrics,
+		"circuit_breakers": breakerStatus(getAccess(c)),
+	})
+}
+
+// adminDeploymentsHandler returns deployments registered via API
+// swagger:operation GET /admin/deployments admin listManagedDeployments
+//
+// Registered Deployments
+//
+// Returns deployments registered via API and
+// the names of deployments defined in the config file
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: status==ACTIVE and flavor.vcpus>=8 and metadata.cluster~"kafka-*"
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,name
+// responses:
+//   '200':
+//     description: "Registered Deployments"
+//     schema:
+//       type: object
+//       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
+//         deployments:
+//           type: array
+//           items:
+//             $ref: '#/definitions/ManagedDeployment'
+//         config_file:
+//           description: Deployments defined in the config file
+//           type: array
+//           items:
+//             type: string
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func adminDeploymentsHandler(c iris.Context) {
+	lq, err := newListQuery(c, models.ManagedDeployment{})
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	deployments := []models.ManagedDeployment{}
+	for _, d := range listManagedDeployments() {
+		deployments = append(deployments, d.Redacted())
+	}
+
+	configured := []string{}
+	for name := range fileConfig.Deployments {
+		configured = append(configured, name)
+	}
+	sort.Strings(configured)
+
+	page, next, err := lq.page(deployments)
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	response := iris.Map{
+		"config_file": configured,
+	}
+	lq.respond(c, response, "deployments", page, next)
+	render(c, response)
+}
+
+// adminDeploymentHandler returns the deployment registered via API
+// swagger:operation GET /admin/deployments/{name} admin getManagedDeployment
+//
+// Registered Deployment
+//
+// Returns the deployment registered via API
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+// responses:
+//   '200':
+//     description: "Registered Deployment"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           $ref: '#/definitions/ManagedDeployment'
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no deployment"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           type: string
+//           description: Error Message
+func adminDeploymentHandler(c iris.Context) {
+	name := c.Params().Get("name")
+
+	d, err := getManagedDeployment(name)
+	if err != nil {
+		adminError(c, name, err)
+		return
+	}
+	render(c, iris.Map{
+		"deployment": d.Redacted(),
+	})
+}
+
+// adminRegisterDeploymentHandler registers a new deployment
+// swagger:operation POST /admin/deployments admin registerDeployment
+//
+// Register Deployment
+//
+// Registers OpenStack deployment and starts polling it unless disabled.
+// The password is stored encrypted and never returned
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: deployment
+//    in: body
+//    required: true
+//    schema:
+//      $ref: '#/definitions/ManagedDeployment'
+// responses:
+//   '201':
+//     description: "Registered Deployment"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           $ref: '#/definitions/ManagedDeployment'
+//   '400':
+//     description: "Returns 400 Code if the deployment is invalid"
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '409':
+//     description: "Returns 409 Code if the deployment already exists"
+func adminRegisterDeploymentHandler(c iris.Context) {
+	var d models.ManagedDeployment
+
+	err := c.ReadJSON(&d)
+	if err != nil {
+		c.StatusCode(iris.StatusBadRequest)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	d, err = saveManagedDeployment(d, true)
+	if err != nil {
+		adminError(c, d.Name, err)
+		return
+	}
+	c.StatusCode(iris.StatusCreated)
+	render(c, iris.Map{
+		"deployment": d.Redacted(),
+	})
+}
+
+// adminUpdateDeploymentHandler updates the deployment registered via API
+// swagger:operation PUT /admin/deployments/{name} admin updateDeployment
+//
+// Update Deployment
+//
+// Replaces the deployment settings. The stored password
+// is kept if the password is empty
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: deployment
+//    in: body
+//    required: true
+//    schema:
+//      $ref: '#/definitions/ManagedDeployment'
+// responses:
+//   '200':
+//     description: "Updated Deployment"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           $ref: '#/definitions/ManagedDeployment'
+//   '400':
+//     description: "Returns 400 Code if the deployment is invalid"
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no deployment"
+func adminUpdateDeploymentHandler(c iris.Context) {
+	var d models.ManagedDeployment
+
+	err := c.ReadJSON(&d)
+	if err != nil {
+		c.StatusCode(iris.StatusBadRequest)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+	d.Name = c.Params().Get("name")
+
+	d, err = saveManagedDeployment(d, false)
+	if err != nil {
+		adminError(c, d.Name, err)
+		return
+	}
+	render(c, iris.Map{
+		"deployment": d.Redacted(),
+	})
+}
+
+// adminDeploymentActionHandler enables or disables the deployment
+// swagger:operation POST /admin/deployments/{name}/{action} admin controlDeployment
+//
+// Enable or Disable Deployment
+//
+// Disabled deployments are not polled and not served,
+// their inventory is kept
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+//  - name: action
+//    in: path
+//    description: Action
+//    type: string
+//    required: true
+//    enum: [enable, disable]
+// responses:
+//   '200':
+//     description: "Updated Deployment"
+//     schema:
+//       type: object
+//       properties:
+//         deployment:
+//           $ref: '#/definitions/ManagedDeployment'
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no deployment or action"
+func adminDeploymentActionHandler(c iris.Context) {
+	name := c.Params().Get("name")
+	action := c.Params().Get("action")
+
+	if action != "enable" && action != "disable" {
+		c.StatusCode(iris.StatusNotFound)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Action %s not found", action),
+		})
+		return
+	}
+
+	d, err := getManagedDeployment(name)
+	if err == nil {
+		d.Disabled = action == "disable"
+		d, err = saveManagedDeployment(d, false)
+	}
+	if err != nil {
+		adminError(c, name, err)
+		return
+	}
+	render(c, iris.Map{
+		"deployment": d.Redacted(),
+	})
+}
+
+// adminDeleteDeploymentHandler deletes the deployment registered via API
+// swagger:operation DELETE /admin/deployments/{name} admin deleteDeployment
+//
+// Delete Deployment
+//
+// Stops polling the deployment. Its inventory is purged by the next DB cleanup
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: OpenStack Deployment Name
+//    type: string
+//    required: true
+//    example: tm-lab-1a
+// responses:
+//   '200':
+//     description: "Returns 200 on success"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           description: Success Message
+//           type: string
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no deployment"
+func adminDeleteDeploymentHandler(c iris.Context) {
+	name := c.Params().Get("name")
+
+	err := deleteManagedDeployment(name)
+	if err != nil {
+		adminError(c, name, err)
+		return
+	}
+	render(c, iris.Map{
+		"message": fmt.Sprintf("Deployment %s deleted", name),
+	})
+}
+
+// adminError maps deployment management errors to status codes
+func adminError(c iris.Context, name string, err error) {
+	switch err {
+	case errDeploymentMissing:
+		c.StatusCode(iris.StatusNotFound)
+	case errDeploymentExists, errDefinedInFile:
+		c.StatusCode(iris.StatusConflict)
+	case errNoSecretKey:
+		c.StatusCode(iris.StatusServiceUnavailable)
+	default:
+		c.StatusCode(iris.StatusBadRequest)
+	}
+	render(c, iris.Map{
+		"message": fmt.Sprintf("Deployment %s: %v", name, err),
+	})
+}
+
+// adminWebhooksHandler returns the webhooks
+// swagger:operation GET /admin/webhooks admin listWebhooks
+//
+// Webhooks
+//
+// Returns the webhooks defined in the config file and registered via API
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: source==api
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: name,-created
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: name,url
+// responses:
+//   '200':
+//     description: "Webhooks"
+//     schema:
+//       type: object
+//       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
+//         webhooks:
+//           type: array
+//           items:
+//             $ref: '#/definitions/Webhook'
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+func adminWebhooksHandler(c iris.Context) {
+	lq, err := newListQuery(c, models.Webhook{})
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	webhooks := []models.Webhook{}
+	for _, w := range listWebhooks() {
+		webhooks = append(webhooks, w.Redacted())
+	}
+
+	page, next, err := lq.page(webhooks)
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	response := iris.Map{}
+	lq.respond(c, response, "webhooks", page, next)
+	render(c, response)
+}
+
+// adminWebhookHandler returns the webhook
+// swagger:operation GET /admin/webhooks/{name} admin getWebhook
+//
+// Webhook
+//
+// Returns the webhook defined in the config file or registered via API
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: Webhook Name
+//    type: string
+//    required: true
+//    example: ops
+// responses:
+//   '200':
+//     description: "Webhook"
+//     schema:
+//       type: object
+//       properties:
+//         webhook:
+//           $ref: '#/definitions/Webhook'
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no webhook"
+func adminWebhookHandler(c iris.Context) {
+	name := c.Params().Get("name")
+
+	w, err := getWebhook(name)
+	if err != nil {
+		webhookError(c, "Webhook "+name, err)
+		return
+	}
+	render(c, iris.Map{
+		"webhook": w.Redacted(),
+	})
+}
+
+// adminRegisterWebhookHandler registers a new webhook
+// swagger:operation POST /admin/webhooks admin registerWebhook
+//
+// Register Webhook
+//
+// Registers the webhook receiving the events as signed JSON POST
+// requests. The secret is stored encrypted and never returned
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: webhook
+//    in: body
+//    required: true
+//    schema:
+//      $ref: '#/definitions/Webhook'
+// responses:
+//   '201':
+//     description: "Registered Webhook"
+//     schema:
+//       type: object
+//       properties:
+//         webhook:
+//           $ref: '#/definitions/Webhook'
+//   '400':
+//     description: "Returns 400 Code if the webhook is invalid"
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '409':
+//     description: "Returns 409 Code if the webhook already exists"
+func adminRegisterWebhookHandler(c iris.Context) {
+	var w models.Webhook
+
+	err := c.ReadJSON(&w)
+	if err != nil {
+		c.StatusCode(iris.StatusBadRequest)
+		render(c, iris.Map{
+			"message": fmt.Sprintf("Invalid request body: %v", err),
+		})
+		return
+	}
+
+	w, err = saveWebhook(w)
+	if err != nil {
+		webhookError(c, "Webhook "+w.Name, err)
+		return
+	}
+	c.StatusCode(iris.StatusCreated)
+	render(c, iris.Map{
+		"webhook": w.Redacted(),
+	})
+}
+
+// adminDeleteWebhookHandler deletes the webhook registered via API
+// swagger:operation DELETE /admin/webhooks/{name} admin deleteWebhook
+//
+// Delete Webhook
+//
+// Deletes the webhook registered via API. Queued deliveries are still sent
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: Webhook Name
+//    type: string
+//    required: true
+//    example: ops
+// responses:
+//   '200':
+//     description: "Returns 200 on success"
+//     schema:
+//       type: object
+//       properties:
+//         message:
+//           description: Success Message
+//           type: string
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no webhook"
+//   '409':
+//     description: "Returns 409 Code if the webhook is defined in the config file"
+func adminDeleteWebhookHandler(c iris.Context) {
+	name := c.Params().Get("name")
+
+	err := deleteWebhook(name)
+	if err != nil {
+		webhookError(c, "Webhook "+name, err)
+		return
+	}
+	render(c, iris.Map{
+		"message": fmt.Sprintf("Webhook %s deleted", name),
+	})
+}
+
+// adminPingWebhookHandler sends the ping event to the webhook
+// swagger:operation POST /admin/webhooks/{name}/ping admin pingWebhook
+//
+// Ping Webhook
+//
+// Queues the ping event for the webhook, so the receiver and
+// the signature are checked. Failed pings are dead-lettered
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: name
+//    in: path
+//    description: Webhook Name
+//    type: string
+//    required: true
+//    example: ops
+// responses:
+//   '202':
+//     description: "Returns 202 if the ping is queued"
+//     schema:
+//       type: object
+//       properties:
+//         delivery:
+//           description: Delivery ID
+//           type: string
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no webhook"
+func adminPingWebhookHandler(c iris.Context) {
+	name := c.Params().Get("name")
+
+	w, err := getWebhook(name)
+	if err != nil {
+		webhookError(c, "Webhook "+name, err)
+		return
+	}
+	id := queueWebhook(w, models.WebhookPayload{
+		Event: eventPing,
+	})
+	c.StatusCode(iris.StatusAccepted)
+	render(c, iris.Map{
+		"delivery": id,
+	})
+}
+
+// adminDeadLettersHandler returns the failed webhook deliveries
+// swagger:operation GET /admin/deadletters admin listDeadLetters
+//
+// Dead Letters
+//
+// Returns the webhook deliveries failed after all retries, the latest first
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: q
+//    in: query
+//    description: Filter expression
+//    type: string
+//    required: false
+//    example: webhook==ops
+//  - name: limit
+//    in: query
+//    description: Maximum number of items (up to 1000)
+//    type: integer
+//    required: false
+//  - name: marker
+//    in: query
+//    description: ID of the last item of the previous page (next_marker)
+//    type: string
+//    required: false
+//  - name: sort
+//    in: query
+//    description: Sort fields, descending if prefixed with -
+//    type: string
+//    required: false
+//    example: -failed
+//  - name: fields
+//    in: query
+//    description: Fields of the items to return
+//    type: string
+//    required: false
+//    example: id,event,error
+// responses:
+//   '200':
+//     description: "Dead Letters"
+//     schema:
+//       type: object
+//       properties:
+//         next_marker:
+//           description: Marker of the next page, set if there are more items
+//           type: string
+//         dead_letters:
+//           type: array
+//           items:
+//             $ref: '#/definitions/DeadLetter'
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '400':
+//     description: "Returns 400 Code if list parameters are invalid"
+func adminDeadLettersHandler(c iris.Context) {
+	lq, err := newListQuery(c, models.DeadLetter{})
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	page, next, err := lq.page(listDeadLetters())
+	if err != nil {
+		listQueryError(c, err)
+		return
+	}
+
+	response := iris.Map{}
+	lq.respond(c, response, "dead_letters", page, next)
+	render(c, response)
+}
+
+// adminRetryDeadLetterHandler queues the failed delivery again
+// swagger:operation POST /admin/deadletters/{id}/retry admin retryDeadLetter
+//
+// Retry Dead Letter
+//
+// Queues the failed delivery again to the current URL of its webhook.
+// The dead letter is removed, it is saved again if the delivery fails
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: id
+//    in: path
+//    description: Delivery ID
+//    type: string
+//    required: true
+// responses:
+//   '202':
+//     description: "Returns 202 if the delivery is queued"
+//     schema:
+//       type: object
+//       properties:
+//         dead_letter:
+//           $ref: '#/definitions/DeadLetter'
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
 //   '404':
-//     description: "Returns 404 Code if there is no path"
+//     description: "Returns 404 Code if there is no dead letter or webhook"
+func adminRetryDeadLetterHandler(c iris.Context) {
+	id := c.Params().Get("id")
+
+	letter, err := retryDeadLetter(id)
+	if err != nil {
+		webhookError(c, "Dead letter "+id, err)
+		return
+	}
+	c.StatusCode(iris.StatusAccepted)
+	render(c, iris.Map{
+		"dead_letter": letter,
+	})
+}
+
+// adminDeleteDeadLetterHandler deletes the failed delivery
+// swagger:operation DELETE /admin/deadletters/{id} admin deleteDeadLetter
+//
+// Delete Dead Letter
+//
+// Deletes the failed delivery without retrying it
+//
+// ---
+// security:
+//   - bearer: []
+//   - api_key: []
+// parameters:
+//  - name: id
+//    in: path
+//    description: Delivery ID
+//    type: string
+//    required: true
+// responses:
+//   '200':
+//     description: "Returns 200 on success"
 //     schema:
 //       type: object
 //       properties:
 //         message:
+//           description: Success Message
 //           type: string
-//           description: Error Message
-func statusHandler(c iris.Context) {
-	c.JSON(iris.Map{
-		"status":    "alive",
-		"datastore": datastoreMetrics,
+//   '401':
+//     description: "Returns 401 Code without credentials"
+//   '403':
+//     description: "Returns 403 Code without the admin scope"
+//   '404':
+//     description: "Returns 404 Code if there is no dead letter"
+func adminDeleteDeadLetterHandler(c iris.Context) {
+	id := c.Params().Get("id")
+
+	err := deleteDeadLetter(id)
+	if err != nil {
+		webhookError(c, "Dead letter "+id, err)
+		return
+	}
+	render(c, iris.Map{
+		"message": fmt.Sprintf("Dead letter %s deleted", id),
 	})
+}
 
+// webhookError maps webhook management errors to status codes
+func webhookError(c iris.Context, subject string, err error) {
+	switch err {
+	case errWebhookMissing, errDeadLetterMissing:
+		c.StatusCode(iris.StatusNotFound)
+	case errWebhookExists, errWebhookInConfig:
+		c.StatusCode(iris.StatusConflict)
+	case errNoSecretKey:
+		c.StatusCode(iris.StatusServiceUnavailable)
+	default:
+		c.StatusCode(iris.StatusBadRequest)
+	}
+	render(c, iris.Map{
+		"message": fmt.Sprintf("%s: %v", subject, err),
+	})
 }
@@ -0,0 +1,622 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"fmt"
+	"ossia/middleware"
+	"ossia/models"
+	"ossia/scheduler"
+	"reflect"
+	"sort"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/kataras/iris/v12"
+)
+
+// v2 API handlers. Responses are {data, meta, links} envelopes with
+// snake_case fields, errors are RFC 7807 problem details
+
+// v2Resource is an Inventory resource type of the v2 API
+type v2Resource struct {
+	// Model of the Inventory objects
+	model interface{}
+	// list returns a pointer to an empty slice of the model
+	list func() interface{}
+	// get returns the object by its ID or name
+	get func(deployment string, ref objectRef) (models.Resource, error)
+	// matcher selects the objects allowed to the caller, optional
+	matcher func(a *access, deployment string) q.Matcher
+	// expand adds related data to the listed objects, optional.
+	// It gets a pointer to the slice
+	expand func(deployment string, items interface{})
+	// prepare hides the instances of other projects referenced by
+	// the objects. It gets a pointer to the slice, optional
+	prepare func(c iris.Context, deployment string, items interface{})
+}
+
+var v2Resources = map[string]v2Resource{
+	"projects": {
+		model: models.Project{},
+		list:  func() interface{} { return &[]models.Project{} },
+		get: func(deployment string, ref objectRef) (models.Resource, error) {
+			p, err := getProject(deployment, ref)
+			return &p, err
+		},
+		matcher: (*access).projectMatcher,
+	},
+	"instances": {
+		model: models.Instance{},
+		list:  func() interface{} { return &[]models.Instance{} },
+		get: func(deployment string, ref objectRef) (models.Resource, error) {
+			i, err := getInstance(deployment, ref)
+			return &i, err
+		},
+		matcher: (*access).instanceMatcher,
+	},
+	"images": {
+		model: models.Image{},
+		list:  func() interface{} { return &[]models.Image{} },
+		get: func(deployment string, ref objectRef) (models.Resource, error) {
+			i, err := getImage(deployment, ref)
+			return &i, err
+		},
+		prepare: func(c iris.Context, deployment string, items interface{}) {
+			images := *items.(*[]models.Image)
+			filter := getAccess(c).instanceNameFilter(deployment)
+			for i := range images {
+				images[i].UsedBy = filter(images[i].UsedBy)
+			}
+		},
+	},
+	"flavors": {
+		model: models.Flavor{},
+		list:  func() interface{} { return &[]models.Flavor{} },
+		get: func(deployment string, ref objectRef) (models.Resource, error) {
+			f, err := getFlavor(deployment, ref)
+			return &f, err
+		},
+	},
+	"aggregates": {
+		model: models.Aggregate{},
+		list:  func() interface{} { return &[]models.Aggregate{} },
+		get: func(deployment string, ref objectRef) (models.Resource, error) {
+			a, err := getAggregate(deployment, ref)
+			return &a, err
+		},
+	},
+	"hypervisors": {
+		model: models.Hypervisor{},
+		list:  func() interface{} { return &[]models.Hypervisor{} },
+		get: func(deployment string, ref objectRef) (models.Resource, error) {
+			h, err := getHypervisor(deployment, ref)
+			return &h, err
+		},
+		expand: func(deployment string, items interface{}) {
+			addHypervisorVMs(deployment, *items.(*[]models.Hypervisor))
+		},
+		prepare: func(c iris.Context, deployment string, items interface{}) {
+			hypervisors := *items.(*[]models.Hypervisor)
+			filter := getAccess(c).instanceNameFilter(deployment)
+			for i := range hypervisors {
+				hypervisors[i].VMs = filter(hypervisors[i].VMs)
+			}
+		},
+	},
+}
+
+// v2Deployment checks the deployment of the request
+// and responds 404 if it is not registered
+func v2Deployment(c iris.Context) (string, bool) {
+	deployment := c.Params().Get("deployment")
+	if !deploymentRegistered(deployment) {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Deployment %s not found", deployment), nil)
+		return deployment, false
+	}
+	return deployment, true
+}
+
+// v2ListQuery parses the list parameters and responds 400 if invalid.
+// Pages have defaultListLimit items unless limited
+func v2ListQuery(c iris.Context, model interface{}) (*listQuery, bool) {
+	lq, err := newListQuery(c, model)
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid list parameters: %v", err), nil)
+		return nil, false
+	}
+	if lq.limit == 0 {
+		lq.limit = defaultListLimit
+	}
+	return lq, true
+}
+
+// v2FindResources lists the deployment resources and renders the page
+func v2FindResources(c iris.Context, deployment string, res v2Resource, matchers ...q.Matcher) {
+	lq, ok := v2ListQuery(c, res.model)
+	if !ok {
+		return
+	}
+
+	items := res.list()
+	if res.matcher != nil {
+		matchers = append(matchers, res.matcher(getAccess(c), deployment))
+	}
+	next, err := findResources(deployment, lq, items, matchers...)
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid list parameters: %v", err), nil)
+		return
+	}
+	if res.expand != nil {
+		res.expand(deployment, items)
+	}
+	if res.prepare != nil {
+		res.prepare(c, deployment, items)
+	}
+
+	c.StatusCode(iris.StatusOK)
+	listEnvelope(c, lq, reflect.ValueOf(items).Elem().Interface(), next, iris.Map{
+		"deployment": deployment,
+	})
+}
+
+// v2StatusHandler returns application health status
+func v2StatusHandler(c iris.Context) {
+	data := newSnakeObject()
+	data.set("status", "alive")
+	envelope(c, data, nil, nil)
+}
+
+// v2StatusDetailsHandler returns datastore metrics and circuit
+// breakers of the deployments visible to the caller
+func v2StatusDetailsHandler(c iris.Context) {
+	data := newSnakeObject()
+	data.set("status", "alive")
+	data.set("datastore", snakeValue(reflect.ValueOf(datastoreMetrics)))
+	data.set("circuit_breakers", snakeValue(reflect.ValueOf(breakerStatus(getAccess(c)))))
+	envelope(c, data, nil, nil)
+}
+
+// v2DeploymentsHandler returns the deployments visible to the caller
+func v2DeploymentsHandler(c iris.Context) {
+	lq, ok := v2ListQuery(c, "")
+	if !ok {
+		return
+	}
+
+	page, next, err := lq.page(readableDeployments(getAccess(c)))
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid list parameters: %v", err), nil)
+		return
+	}
+
+	deployments := []interface{}{}
+	for _, d := range page.([]string) {
+		deployment := newSnakeObject()
+		deployment.set("name", d)
+		deployment.set("links", iris.Map{"self": "/v2/deployments/" + d})
+		deployments = append(deployments, deployment)
+	}
+	listEnvelope(c, nil, deployments, next, nil)
+}
+
+// v2DeploymentHandler returns the resource counts of the deployment
+func v2DeploymentHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	a := getAccess(c)
+	data := newSnakeObject()
+	data.set("name", deployment)
+	data.set("projects", countResources(deployment, &models.Project{}, a.projectMatcher(deployment)))
+	data.set("instances", countResources(deployment, &models.Instance{}, a.instanceMatcher(deployment)))
+	data.set("images", countResources(deployment, &models.Image{}))
+	data.set("flavors", countResources(deployment, &models.Flavor{}))
+	data.set("aggregates", countResources(deployment, &models.Aggregate{}))
+	data.set("hypervisors", countResources(deployment, &models.Hypervisor{}))
+	data.set("circuit_breaker", snakeValue(reflect.ValueOf(Breaker(deployment).Status())))
+
+	links := iris.Map{}
+	for resource := range v2Resources {
+		links[resource] = fmt.Sprintf("/v2/deployments/%s/%s", deployment, resource)
+	}
+	links["snapshots"] = fmt.Sprintf("/v2/deployments/%s/snapshots", deployment)
+	envelope(c, data, nil, links)
+}
+
+// v2SnapshotsHandler returns the usage snapshots of the deployment
+func v2SnapshotsHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	snapshots := restrictSnapshots(deployment, getAccess(c), listSnapshots(deployment))
+	if len(snapshots) == 0 {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("No Usage Snapshots for the %s deployment", deployment), nil)
+		return
+	}
+	listEnvelope(c, nil, snapshots, "", iris.Map{
+		"deployment": deployment,
+	})
+}
+
+// v2ResourcesHandler returns the resources of the deployment
+func v2ResourcesHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	resource := c.Params().Get("resource")
+	res, ok := v2Resources[resource]
+	if !ok {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Resource type %s not found", resource), nil)
+		return
+	}
+	v2FindResources(c, deployment, res)
+}
+
+// v2ResourceHandler returns the resource of the deployment by its name
+func v2ResourceHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	resource := c.Params().Get("resource")
+	name := c.Params().Get("name")
+	if resource == "hypervisors" && name == "empty" {
+		v2EmptyHypervisorsHandler(c, deployment)
+		return
+	}
+	res, ok := v2Resources[resource]
+	if !ok {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Resource type %s not found", resource), nil)
+		return
+	}
+
+	r, err := res.get(deployment, newObjectRef(c, name))
+	switch e := err.(type) {
+	case nil:
+	case *ambiguousError:
+		v2Ambiguous(c, deployment, resource, e)
+		return
+	default:
+		if err == storm.ErrNotFound {
+			problem(c, iris.StatusNotFound, fmt.Sprintf("Object %s not found in %s", name, resource), nil)
+			return
+		}
+		problem(c, iris.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	if res.prepare != nil {
+		items := reflect.New(reflect.SliceOf(reflect.TypeOf(r).Elem()))
+		items.Elem().Set(reflect.Append(items.Elem(), reflect.ValueOf(r).Elem()))
+		res.prepare(c, deployment, items.Interface())
+		r = items.Elem().Index(0).Addr().Interface().(models.Resource)
+	}
+	envelope(c, snakeValue(reflect.ValueOf(r)), iris.Map{"deployment": deployment}, nil)
+}
+
+// v2Ambiguous responds 409 with the candidates of an ambiguous name
+func v2Ambiguous(c iris.Context, deployment string, resource string, e *ambiguousError) {
+	candidates := []interface{}{}
+	for _, r := range e.objects {
+		candidate := snakeFields(reflect.ValueOf(r).Elem(), candidateFields)
+		candidate.set("links", iris.Map{
+			"self": fmt.Sprintf("/v2/deployments/%s/%s/%s", deployment, resource, r.Key()),
+		})
+		candidates = append(candidates, candidate)
+	}
+	problem(c, iris.StatusConflict, fmt.Sprintf("Name %s is ambiguous, use one of the IDs or ?project=", e.ref), iris.Map{
+		"candidates": candidates,
+	})
+}
+
+// v2ProjectInstancesHandler returns the instances of the project
+func v2ProjectInstancesHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	if c.Params().Get("resource") != "projects" {
+		problem(c, iris.StatusNotFound, "Path not found.", nil)
+		return
+	}
+	name := c.Params().Get("name")
+	p, err := getProject(deployment, newObjectRef(c, name))
+	if e, ok := err.(*ambiguousError); ok {
+		v2Ambiguous(c, deployment, "projects", e)
+		return
+	}
+	if err != nil {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Project %s not found", name), nil)
+		return
+	}
+	v2FindResources(c, deployment, v2Resources["instances"], q.Eq("ProjectID", p.ID))
+}
+
+// v2EmptyHypervisorsHandler returns the hypervisors without instances
+func v2EmptyHypervisorsHandler(c iris.Context, deployment string) {
+	lq, ok := v2ListQuery(c, models.Hypervisor{})
+	if !ok {
+		return
+	}
+	hypervisors := listEmptyHypervisors(deployment)
+	page, next, err := lq.page(hypervisors)
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid list parameters: %v", err), nil)
+		return
+	}
+	listEnvelope(c, lq, page, next, iris.Map{
+		"deployment":  deployment,
+		"total_empty": len(hypervisors),
+	})
+}
+
+// v2ClustersHandler returns the amount of instances per cluster
+func v2ClustersHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, i := range getAccess(c).filterInstances(deployment, listInstances(deployment, "")) {
+		counts[i.Metadata["cluster"]]++
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clusters := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		cluster := newSnakeObject()
+		cluster.set("name", name)
+		cluster.set("instances", counts[name])
+		clusters = append(clusters, cluster)
+	}
+	listEnvelope(c, nil, clusters, "", iris.Map{
+		"deployment": deployment,
+	})
+}
+
+// v2ClusterHandler returns the instances of the cluster
+func v2ClusterHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	cluster := c.Params().Get("cluster")
+	v2FindResources(c, deployment, v2Resources["instances"], matchFunc(func(v reflect.Value) bool {
+		return v.Interface().(models.Instance).Metadata["cluster"] == cluster
+	}))
+}
+
+// v2UpdateHandler submits the update job of the deployment,
+// a resource type or an object
+func v2UpdateHandler(c iris.Context) {
+	deployment := c.Params().Get("deployment")
+	resource := c.Params().Get("resource")
+	object := c.Params().Get("object")
+
+	if _, ok := Cfg().Deployments[deployment]; !ok {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Deployment %s not found", deployment), nil)
+		return
+	}
+
+	collector, ok := getCollector(resource)
+	if resource != "" && !ok {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Resource type %s not found", resource), nil)
+		return
+	}
+
+	if object == "" {
+		var names []string
+		if resource != "" {
+			names = []string{resource}
+		}
+		job, merged := submitJob(deployment, names)
+		c.StatusCode(iris.StatusAccepted)
+		envelope(c, snakeValue(reflect.ValueOf(job)), iris.Map{
+			"merged": merged,
+		}, iris.Map{
+			"job": "/v2/jobs/" + job.ID,
+		})
+		return
+	}
+
+	objectCollector, ok := collector.(ObjectCollector)
+	if !ok {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Resource type %s does not support object refresh", resource), nil)
+		return
+	}
+
+	r, err := refreshObject(deployment, objectCollector, newObjectRef(c, object))
+	switch e := err.(type) {
+	case nil:
+		envelope(c, snakeValue(reflect.ValueOf(r)), iris.Map{"deployment": deployment}, nil)
+	case *ambiguousError:
+		problem(c, iris.StatusConflict, fmt.Sprintf("Name %s is ambiguous, use one of the IDs", object), iris.Map{
+			"candidates": e.candidates,
+		})
+	default:
+		if err == storm.ErrNotFound {
+			problem(c, iris.StatusNotFound, fmt.Sprintf("Object %s not found", object), nil)
+			return
+		}
+		problem(c, iris.StatusServiceUnavailable, fmt.Sprintf("Unable to refresh %s: %v", object, err), nil)
+	}
+}
+
+// v2JobHandler returns the update job
+func v2JobHandler(c iris.Context) {
+	id := c.Params().Get("id")
+
+	job, ok := getJob(id)
+	if !ok || !getAccess(c).canRead(job.Deployment) {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Job %s not found", id), nil)
+		return
+	}
+	envelope(c, snakeValue(reflect.ValueOf(job)), nil, nil)
+}
+
+// v2JobsHandler returns the update jobs of the deployment
+func v2JobsHandler(c iris.Context) {
+	deployment, ok := v2Deployment(c)
+	if !ok {
+		return
+	}
+
+	lq, ok := v2ListQuery(c, models.Job{})
+	if !ok {
+		return
+	}
+	jobs, next, err := lq.page(listJobs(deployment))
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid list parameters: %v", err), nil)
+		return
+	}
+	listEnvelope(c, lq, jobs, next, iris.Map{
+		"deployment": deployment,
+	})
+}
+
+// v2TasksHandler returns the scheduler tasks visible to the caller
+func v2TasksHandler(c iris.Context) {
+	deployment := c.URLParam("deployment")
+
+	lq, ok := v2ListQuery(c, models.ScheduledTask{})
+	if !ok {
+		return
+	}
+
+	tasks := []models.ScheduledTask{}
+	for _, t := range scheduler.Tasks() {
+		if getAccess(c).taskAllowed(middleware.ScopeRead, t) && (deployment == "" || t.Deployment == deployment) {
+			tasks = append(tasks, t)
+		}
+	}
+
+	page, next, err := lq.page(tasks)
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid list parameters: %v", err), nil)
+		return
+	}
+	listEnvelope(c, lq, page, next, nil)
+}
+
+// v2TaskHandler returns the scheduler task
+func v2TaskHandler(c iris.Context) {
+	name := c.Params().Get("name")
+
+	task, err := scheduler.GetTask(name)
+	if err != nil || !getAccess(c).taskAllowed(middleware.ScopeRead, task) {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Task %s not found", name), nil)
+		return
+	}
+	envelope(c, snakeValue(reflect.ValueOf(task)), nil, nil)
+}
+
+// v2TaskActionHandler pauses, resumes or triggers the scheduler task
+func v2TaskActionHandler(c iris.Context) {
+	name := c.Params().Get("name")
+	action := c.Params().Get("action")
+
+	task, err := scheduler.GetTask(name)
+	if err != nil || !getAccess(c).taskAllowed(middleware.ScopeRead, task) {
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Task %s not found", name), nil)
+		return
+	}
+	if !getAccess(c).taskAllowed(middleware.ScopeRefresh, task) {
+		problem(c, iris.StatusForbidden, fmt.Sprintf("Operation refresh is not allowed on task %s", name), nil)
+		return
+	}
+
+	switch action {
+	case "pause":
+		err = scheduler.PauseTask(name)
+	case "resume":
+		err = scheduler.ResumeTask(name)
+	case "trigger":
+		err = scheduler.TriggerTask(name)
+	default:
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Action %s not found", action), nil)
+		return
+	}
+
+	switch err {
+	case nil:
+		task, _ = scheduler.GetTask(name)
+		envelope(c, snakeValue(reflect.ValueOf(task)), nil, nil)
+	case scheduler.ErrTaskRunning:
+		problem(c, iris.StatusConflict, fmt.Sprintf("Task %s is already running", name), nil)
+	default:
+		problem(c, iris.StatusNotFound, fmt.Sprintf("Task %s not found", name), nil)
+	}
+}
+
+// v2SearchHandler looks up the Inventory of all deployments
+func v2SearchHandler(c iris.Context) {
+	query, opts, err := newSearchOptions(c)
+	if err != nil {
+		problem(c, iris.StatusBadRequest, fmt.Sprintf("Invalid search parameters: %v", err), nil)
+		return
+	}
+
+	hits, truncated := searchInventory(query, opts)
+	if hits == nil {
+		hits = []models.SearchHit{}
+	}
+	listEnvelope(c, nil, hits, "", iris.Map{
+		"query":     query,
+		"truncated": truncated,
+	})
+}
+
+// v2GlobalSummaryHandler returns the fleet-wide usage and catalogs
+func v2GlobalSummaryHandler(c iris.Context) {
+	deployments := readableDeployments(getAccess(c))
+	totals, usage := getGlobalUsage(deployments, getAccess(c))
+	flavors, images := getCatalogs(deployments, getAccess(c))
+
+	data := newSnakeObject()
+	data.set("deployments", deployments)
+	data.set("totals", snakeValue(reflect.ValueOf(totals)))
+	data.set("per_deployment", snakeValue(reflect.ValueOf(usage)))
+	data.set("flavors", snakeValue(reflect.ValueOf(flavors)))
+	data.set("images", snakeValue(reflect.ValueOf(images)))
+	envelope(c, data, nil, nil)
+}
+
+// v2GlobalSnapshotsHandler returns the usage snapshots of all
+// deployments per day, summed and per deployment
+func v2GlobalSnapshotsHandler(c iris.Context) {
+	a := getAccess(c)
+	deployments := readableDeployments(a)
+	totals, perDeployment := getGlobalSnapshots(deployments, a)
+
+	data := newSnakeObject()
+	data.set("deployments", deployments)
+	data.set("totals", snakeValue(reflect.ValueOf(totals)))
+	data.set("per_deployment", snakeValue(reflect.ValueOf(perDeployment)))
+	envelope(c, data, nil, nil)
+}
@@ -12,488 +12,778 @@ governing permissions and limitations under the License.
 package application
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"ossia/models"
+	"ossia/openstack"
 	"ossia/utils"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/aggregates"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/hypervisors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/pagination"
 	log "github.com/sirupsen/logrus"
 )
 
-func updateInstances(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateInstances)
+func init() {
+	RegisterCollector(&projectsCollector{})
+	RegisterCollector(&aggregatesCollector{})
+	RegisterCollector(&flavorsCollector{})
+	RegisterCollector(&hypervisorsCollector{})
+	RegisterCollector(&instancesCollector{})
+	RegisterCollector(&imagesCollector{})
+}
 
-	var inventoryInstances []models.Instance
-	var hash models.HypervisorHash
+// instancesCollector polls Nova Servers. Only changes since the
+// last poll are fetched, unless it is the time for the full
+// reconciliation. Hypervisor hashes are required
+type instancesCollector struct{}
+
+func (c *instancesCollector) Name() string           { return "instances" }
+func (c *instancesCollector) Dependencies() []string { return []string{"hypervisors"} }
+func (c *instancesCollector) Model() interface{}     { return &[]models.Instance{} }
+
+func (c *instancesCollector) Schedule(interval models.PollInterval) string {
+	return fmt.Sprintf("@every %s", interval.Instances)
+}
+
+func (c *instancesCollector) Fetch(ctx context.Context, deployment string) (FetchResult, error) {
+	var result FetchResult
+
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return result, err
+	}
+
+	state := getPollState(deployment, c.Name())
+	result.Complete = state.ChangesSince.IsZero() || time.Since(state.LastFullSync) >= fullReconcileInterval()
+	result.PolledAt = time.Now()
+
+	opts := servers.ListOpts{AllTenants: true, Limit: Cfg().APIClient.PageSize}
+	if !result.Complete {
+		opts.ChangesSince = state.ChangesSince.Add(-changesSinceOverlap).UTC().Format(time.RFC3339)
+	}
+
+	log.WithFields(log.Fields{
+		"deployment":    deployment,
+		"full":          result.Complete,
+		"changes_since": opts.ChangesSince,
+	}).Info("Updating Instances for the deployment")
+
+	var allPages pagination.Page
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		allPages, err = servers.List(cnx, opts).AllPages()
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	instances, err := servers.ExtractServers(allPages)
+	if err != nil {
+		return result, err
+	}
 
 	bucket := DB.From(deployment)
+	for _, i := range instances {
+		// changes-since includes deleted servers
+		if i.Status == "DELETED" || i.Status == "SOFT_DELETED" {
+			result.Deleted = append(result.Deleted, &models.Instance{ID: i.ID})
+			continue
+		}
+		result.Resources = append(result.Resources, newInstance(bucket, i))
+	}
+	return result, nil
+}
 
-	err := bucket.All(&inventoryInstances)
+func (c *instancesCollector) Reconcile(deployment string, result FetchResult) error {
+	err := reconcile(deployment, c, result)
 	if err != nil {
-		log.Error(err)
+		return err
 	}
 
-	cnx := Nova(deployment)
-	if cnx == nil {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-			"task":       "instances",
-		}).Error("Nothing to update for the deployment. No OpenStack Connectivity")
-	} else {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Updating Instances for the deployment")
+	state := getPollState(deployment, c.Name())
+	state.ChangesSince = result.PolledAt
+	savePollState(deployment, state)
+	return nil
+}
 
-		allPages, err := servers.List(cnx, servers.ListOpts{AllTenants: true}).AllPages()
+func (c *instancesCollector) NameField() string { return "Name" }
 
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("Unable to fetch Instances from OpenStack")
-		} else {
-			instances, err := servers.ExtractServers(allPages)
-			if err != nil {
-				log.Error(err)
-			}
+func (c *instancesCollector) FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error) {
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
 
-			for _, i := range instances {
-
-				// networks := []map[string]interface{}{}
-				// for _, instanceAddresses := range models.GetInstanceAddresses(i.Addresses) {
-				// 	for _, instanceNIC := range instanceAddresses.InstanceNICs {
-				// 		v := map[string]interface{}{
-				// 			"name":           instanceAddresses.NetworkName,
-				// 			"fixed_ip_v4":    instanceNIC.FixedIPv4,
-				// 			"fixed_ip_v6":    instanceNIC.FixedIPv6,
-				// 			"floating_ip_v4": instanceNIC.FloatingIPv4,
-				// 			"floating_ip_v6": instanceNIC.FloatingIPv6,
-				// 			"mac":            instanceNIC.MAC,
-				// 		}
-				// 		networks = append(networks, v)
-				// 	}
-				// }
-				networks := models.GetInstanceAddresses(i.Addresses)
-
-				_ = bucket.One("Hash", i.HostID, &hash)
-
-				var FixedIPv4, FloatingIPv4, FixedIPv6, FloatingIPv6 string
-
-				if len(networks) > 0 {
-					FixedIPv4 = networks[0].InstanceNICs[0].FixedIPv4
-					FloatingIPv4 = networks[0].InstanceNICs[0].FloatingIPv4
-					FixedIPv6 = networks[0].InstanceNICs[0].FixedIPv6
-					FloatingIPv6 = networks[0].InstanceNICs[0].FloatingIPv6
-				}
+	var server *servers.Server
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		server, err = servers.Get(cnx, id).Extract()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newInstance(DB.From(deployment), *server), nil
+}
 
-				inst := &models.Instance{
-					ID:     i.ID,
-					Status: i.Status,
-					//StatusMessage: i.Fault.Message,
-					Name:           i.Name,
-					HostID:         i.HostID,
-					ProjectID:      i.TenantID,
-					ImageID:        i.Image["id"].(string),
-					Flavor:         i.Flavor["id"].(string),
-					FixedIPv4:      FixedIPv4,
-					FloatingIPv4:   FloatingIPv4,
-					FixedIPv6:      FixedIPv6,
-					FloatingIPv6:   FloatingIPv6,
-					Hypervisor:     hash.Hostname,
-					Metadata:       i.Metadata,
-					Created:        i.Created,
-					SecurityGroups: i.SecurityGroups,
-					Updated:        i.Updated,
-					PollTime:       time.Now(),
-				}
-				bucket.Save(inst)
-				//updateOrSave("ID", inst.ID, inst, bucket)
+// newInstance converts OpenStack Server into Inventory Instance
+func newInstance(bucket storm.Node, i servers.Server) *models.Instance {
+	var hash models.HypervisorHash
 
-			}
+	networks := models.GetInstanceAddresses(i.Addresses)
 
-			// Instances DB Cleanup
-			for _, i := range inventoryInstances {
-				if !i.Exists(instances) {
-					log.Debug("Deleting instance ", i.ID)
-					err := bucket.DeleteStruct(&i)
-					if err != nil {
-						log.Error(err)
-					}
-				}
-			}
+	_ = bucket.One("Hash", i.HostID, &hash)
 
-		}
+	var FixedIPv4, FloatingIPv4, FixedIPv6, FloatingIPv6 string
 
+	if len(networks) > 0 && len(networks[0].InstanceNICs) > 0 {
+		FixedIPv4 = networks[0].InstanceNICs[0].FixedIPv4
+		FloatingIPv4 = networks[0].InstanceNICs[0].FloatingIPv4
+		FixedIPv6 = networks[0].InstanceNICs[0].FixedIPv6
+		FloatingIPv6 = networks[0].InstanceNICs[0].FloatingIPv6
 	}
 
+	// Image is empty for the instances booted from volume
+	imageID, _ := i.Image["id"].(string)
+	flavorID, _ := i.Flavor["id"].(string)
+
+	return &models.Instance{
+		ID:     i.ID,
+		Status: i.Status,
+		//StatusMessage: i.Fault.Message,
+		Name:           i.Name,
+		HostID:         i.HostID,
+		ProjectID:      i.TenantID,
+		ImageID:        imageID,
+		Flavor:         flavorID,
+		FixedIPv4:      FixedIPv4,
+		FloatingIPv4:   FloatingIPv4,
+		FixedIPv6:      FixedIPv6,
+		FloatingIPv6:   FloatingIPv6,
+		Addresses:      networks,
+		Hypervisor:     hash.Hostname,
+		Metadata:       i.Metadata,
+		Created:        i.Created,
+		SecurityGroups: i.SecurityGroups,
+		Updated:        i.Updated,
+		PollTime:       time.Now(),
+	}
 }
 
-func updateImages(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateImages)
+// imagesCollector polls Nova Images. Image usage is
+// calculated from the Inventory Instances
+type imagesCollector struct{}
 
-	var inventoryImages []models.Image
-	var inventoryInstances []models.Instance
-	var err error
+func (c *imagesCollector) Name() string           { return "images" }
+func (c *imagesCollector) Dependencies() []string { return []string{"instances"} }
+func (c *imagesCollector) Model() interface{}     { return &[]models.Image{} }
 
-	bucket := DB.From(deployment)
+func (c *imagesCollector) Schedule(interval models.PollInterval) string {
+	return fmt.Sprintf("@every %s", interval.Images)
+}
+
+func (c *imagesCollector) Fetch(ctx context.Context, deployment string) (FetchResult, error) {
+	result := FetchResult{Complete: true, PolledAt: time.Now()}
 
-	//Get all Images from inventory
-	err = bucket.All(&inventoryImages)
+	cnx, err := novaClient(ctx, deployment)
 	if err != nil {
-		log.Error(err)
+		return result, err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+	}).Info("Updating Images for the deployment")
+
+	var allPages pagination.Page
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		allPages, err = images.ListDetail(cnx, images.ListOpts{Limit: Cfg().APIClient.PageSize}).AllPages()
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	images, err := images.ExtractImages(allPages)
+	if err != nil {
+		return result, err
+	}
+
+	for _, i := range images {
+		result.Resources = append(result.Resources, &models.Image{
+			ID:       i.ID,
+			Name:     i.Name,
+			Status:   i.Status,
+			Metadata: i.Metadata,
+			Created:  i.Created,
+			Updated:  i.Updated,
+			PollTime: time.Now(),
+		})
 	}
+	return result, nil
+}
+
+func (c *imagesCollector) Reconcile(deployment string, result FetchResult) error {
+	var inventoryInstances []models.Instance
 
 	//Get all Instances from inventory
-	err = bucket.All(&inventoryInstances)
+	err := DB.From(deployment).All(&inventoryInstances)
 	if err != nil {
 		log.Error(err)
 	}
 
-	cnx := Nova(deployment)
-	if cnx == nil {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-			"task":       "images",
-		}).Error("Nothing to update for the deployment. No OpenStack Connectivity")
-	} else {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Updating Images for the deployment")
-		allPages, err := images.ListDetail(cnx, images.ListOpts{}).AllPages()
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("Unable to fetch Images from OpenStack")
-		} else {
-			images, err := images.ExtractImages(allPages)
-			if err != nil {
-				log.Error(err)
-			}
+	usedBy := make(map[string][]string)
+	for _, i := range inventoryInstances {
+		usedBy[i.ImageID] = append(usedBy[i.ImageID], i.Name)
+	}
+	for _, r := range result.Resources {
+		img := r.(*models.Image)
+		img.UsedBy = usedBy[img.ID]
+	}
 
-			for _, i := range images {
-				img := &models.Image{
-					ID:       i.ID,
-					Name:     i.Name,
-					Status:   i.Status,
-					Metadata: i.Metadata,
-					Created:  i.Created,
-					Updated:  i.Updated,
-					PollTime: time.Now(),
-				}
+	return reconcile(deployment, c, result)
+}
 
-				for _, i := range inventoryInstances {
-					if i.ImageID == img.ID {
-						img.UsedBy = append(img.UsedBy, i.Name)
-					}
-				}
+func (c *imagesCollector) NameField() string { return "Name" }
 
-				bucket.Save(img)
-				//updateOrSave("ID", img.ID, img, bucket)
-			}
+func (c *imagesCollector) FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error) {
+	var instances []models.Instance
 
-			// Images DB Cleanup
-			for _, i := range inventoryImages {
-				if !i.Exists(images) {
-					log.Debug("Deleting image ", i.ID)
-					err := bucket.DeleteStruct(&i)
-					if err != nil {
-						log.Error(err)
-					}
-				}
-			}
-		}
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	var i *images.Image
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		i, err = images.Get(cnx, id).Extract()
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	img := &models.Image{
+		ID:       i.ID,
+		Name:     i.Name,
+		Status:   i.Status,
+		Metadata: i.Metadata,
+		Created:  i.Created,
+		Updated:  i.Updated,
+		PollTime: time.Now(),
+	}
+
+	err = DB.From(deployment).Find("ImageID", img.ID, &instances)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+	}
+	for _, i := range instances {
+		img.UsedBy = append(img.UsedBy, i.Name)
+	}
+	return img, nil
 }
 
-func updateHypervisors(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateHypervisors)
+// hypervisorsCollector polls Nova Hypervisors and builds
+// HostID hashes for every project. Projects are required
+type hypervisorsCollector struct{}
+
+func (c *hypervisorsCollector) Name() string           { return "hypervisors" }
+func (c *hypervisorsCollector) Dependencies() []string { return []string{"projects"} }
+func (c *hypervisorsCollector) Model() interface{}     { return &[]models.Hypervisor{} }
+
+func (c *hypervisorsCollector) Schedule(interval models.PollInterval) string {
+	return fmt.Sprintf("@every %s", interval.Hypervisors)
+}
+
+func (c *hypervisorsCollector) Fetch(ctx context.Context, deployment string) (FetchResult, error) {
+	result := FetchResult{Complete: true, PolledAt: time.Now()}
+
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return result, err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+	}).Info("Updating Hypervisors for the deployment")
+
+	var allPages pagination.Page
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		allPages, err = hypervisors.List(cnx).AllPages()
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	hypervisors, err := hypervisors.ExtractHypervisors(allPages)
+	if err != nil {
+		return result, err
+	}
 
+	for _, h := range hypervisors {
+		result.Resources = append(result.Resources, newHypervisor(h))
+	}
+	return result, nil
+}
+
+func (c *hypervisorsCollector) Reconcile(deployment string, result FetchResult) error {
 	var inventoryProjects []models.Project
-	var inventoryHypervisors []models.Hypervisor
 
 	bucket := DB.From(deployment)
 
-	err := bucket.All(&inventoryHypervisors)
+	// Hashes
+	err := bucket.All(&inventoryProjects)
 	if err != nil {
 		log.Error(err)
 	}
 
-	cnx := Nova(deployment)
-	if cnx == nil {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-			"task":       "hypervisors",
-		}).Error("Nothing to update for the deployment. No OpenStack Connectivity")
-	} else {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Updating Hypervisors for the deployment")
+	for _, p := range inventoryProjects {
+		for _, r := range result.Resources {
+			hostname := r.(*models.Hypervisor).Hostname
+			hh := &models.HypervisorHash{
+				Hash:      utils.HashHypervisor(p.ID, hostname),
+				Hostname:  hostname,
+				ProjectID: p.ID,
+			}
 
-		allPages, err := hypervisors.List(cnx).AllPages()
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("Unable to fetch Hypervisors from OpenStack")
-		} else {
-			hypervisors, err := hypervisors.ExtractHypervisors(allPages)
+			err := bucket.Save(hh)
 			if err != nil {
 				log.Error(err)
 			}
 
-			for _, h := range hypervisors {
-				hyp := &models.Hypervisor{
-					ID:          h.ID,
-					Hostname:    strings.Split(h.HypervisorHostname, ".")[0],
-					FQDN:        h.HypervisorHostname,
-					Status:      h.Status,
-					State:       h.State,
-					HostIP:      h.HostIP,
-					VCPUs:       h.VCPUs,
-					VCPUsUsed:   h.VCPUsUsed,
-					FreeDiskGB:  h.FreeDiskGB,
-					TotalDiskGB: h.LocalGB,
-					FreeRAMMB:   h.FreeRamMB,
-					TotalRAMMB:  h.MemoryMB,
-					RunningVMs:  h.RunningVMs,
-					PollTime:    time.Now(),
-				}
-				//updateOrSave("ID", hyp.ID, hyp, bucket)
-				bucket.Save(hyp)
-			}
+		}
+	}
 
-			// Hashes
-			err = bucket.All(&inventoryProjects)
-			if err != nil {
-				log.Error(err)
-			}
+	return reconcile(deployment, c, result)
+}
 
-			for _, p := range inventoryProjects {
-				for _, h := range hypervisors {
-					hostname := strings.Split(h.HypervisorHostname, ".")[0]
-					hh := &models.HypervisorHash{
-						Hash:      utils.HashHypervisor(p.ID, hostname),
-						Hostname:  hostname,
-						ProjectID: p.ID,
-					}
+func (c *hypervisorsCollector) NameField() string { return "Hostname" }
 
-					err := bucket.Save(hh)
-					if err != nil {
-						log.Error(err)
-					}
+func (c *hypervisorsCollector) FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error) {
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
 
-				}
-			}
+	var h *hypervisors.Hypervisor
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		h, err = hypervisors.Get(cnx, id).Extract()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newHypervisor(*h), nil
+}
 
-			// Hypervisors DB Cleanup
-			for _, i := range inventoryHypervisors {
-				if !i.Exists(hypervisors) {
-					log.Debug("Deleting hypervisor ", i.ID)
-					err := bucket.DeleteStruct(&i)
-					if err != nil {
-						log.Error(err)
-					}
-				}
-			}
+// newHypervisor converts OpenStack Hypervisor into Inventory Hypervisor
+func newHypervisor(h hypervisors.Hypervisor) *models.Hypervisor {
+	return &models.Hypervisor{
+		ID:          h.ID,
+		Hostname:    strings.Split(h.HypervisorHostname, ".")[0],
+		FQDN:        h.HypervisorHostname,
+		Status:      h.Status,
+		State:       h.State,
+		HostIP:      h.HostIP,
+		VCPUs:       h.VCPUs,
+		VCPUsUsed:   h.VCPUsUsed,
+		FreeDiskGB:  h.FreeDiskGB,
+		TotalDiskGB: h.LocalGB,
+		FreeRAMMB:   h.FreeRamMB,
+		TotalRAMMB:  h.MemoryMB,
+		RunningVMs:  h.RunningVMs,
+		PollTime:    time.Now(),
+	}
+}
 
-		}
+// flavorsCollector polls Nova Flavors
+type flavorsCollector struct{}
+
+func (c *flavorsCollector) Name() string           { return "flavors" }
+func (c *flavorsCollector) Dependencies() []string { return nil }
+func (c *flavorsCollector) Model() interface{}     { return &[]models.Flavor{} }
+
+func (c *flavorsCollector) Schedule(interval models.PollInterval) string {
+	return fmt.Sprintf("@every %s", interval.Flavors)
+}
+
+func (c *flavorsCollector) Fetch(ctx context.Context, deployment string) (FetchResult, error) {
+	result := FetchResult{Complete: true, PolledAt: time.Now()}
+
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return result, err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+	}).Info("Updating Flavors for the deployment")
+
+	var allPages pagination.Page
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		allPages, err = flavors.ListDetail(cnx, flavors.ListOpts{Limit: Cfg().APIClient.PageSize}).AllPages()
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	flavors, err := flavors.ExtractFlavors(allPages)
+	if err != nil {
+		return result, err
 	}
 
+	for _, f := range flavors {
+		result.Resources = append(result.Resources, newFlavor(f))
+	}
+	return result, nil
 }
 
-func updateFlavors(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateFlavors)
+func (c *flavorsCollector) Reconcile(deployment string, result FetchResult) error {
+	return reconcile(deployment, c, result)
+}
 
-	var inventoryFlavors []models.Flavor
+func (c *flavorsCollector) NameField() string { return "Name" }
 
-	bucket := DB.From(deployment)
+func (c *flavorsCollector) FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error) {
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
 
-	//Get all Flavors from inventory
-	err := bucket.All(&inventoryFlavors)
+	var f *flavors.Flavor
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		f, err = flavors.Get(cnx, id).Extract()
+		return err
+	})
 	if err != nil {
-		log.Error(err)
+		return nil, err
 	}
+	return newFlavor(*f), nil
+}
 
-	cnx := Nova(deployment)
-	if cnx == nil {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-			"task":       "flavors",
-		}).Error("Nothing to update for the deployment. No OpenStack Connectivity")
-	} else {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Updating Flavors for the deployment")
-		allPages, err := flavors.ListDetail(cnx, flavors.ListOpts{}).AllPages()
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("Unable to fetch Flavors from OpenStack")
-		} else {
-			flavors, err := flavors.ExtractFlavors(allPages)
-			if err != nil {
-				log.Error(err)
-			}
+// newFlavor converts OpenStack Flavor into Inventory Flavor
+func newFlavor(f flavors.Flavor) *models.Flavor {
+	return &models.Flavor{
+		ID:         f.ID,
+		Name:       f.Name,
+		RAM:        f.RAM,
+		VCPUs:      f.VCPUs,
+		Disk:       f.Disk,
+		Swap:       f.Swap,
+		RxTxFactor: f.RxTxFactor,
+		IsPublic:   f.IsPublic,
+		Ephemeral:  f.Ephemeral,
+		PollTime:   time.Now(),
+	}
+}
 
-			for _, f := range flavors {
-				flv := &models.Flavor{
-					ID:         f.ID,
-					Name:       f.Name,
-					RAM:        f.RAM,
-					VCPUs:      f.VCPUs,
-					Disk:       f.Disk,
-					Swap:       f.Swap,
-					RxTxFactor: f.RxTxFactor,
-					IsPublic:   f.IsPublic,
-					Ephemeral:  f.Ephemeral,
-					PollTime:   time.Now(),
-				}
+// projectsCollector polls Keystone Projects
+type projectsCollector struct{}
 
-				bucket.Save(flv)
-				//updateOrSave("ID", flv.ID, flv, bucket)
-			}
+func (c *projectsCollector) Name() string           { return "projects" }
+func (c *projectsCollector) Dependencies() []string { return nil }
+func (c *projectsCollector) Model() interface{}     { return &[]models.Project{} }
 
-			for _, i := range inventoryFlavors {
-				if !i.Exists(flavors) {
-					log.Debug("Deleting flavor ", i.ID)
-					err := bucket.DeleteStruct(&i)
-					if err != nil {
-						log.Error(err)
-					}
-				}
-			}
+func (c *projectsCollector) Schedule(interval models.PollInterval) string {
+	return fmt.Sprintf("@every %s", interval.Projects)
+}
 
-		}
+func (c *projectsCollector) Fetch(ctx context.Context, deployment string) (FetchResult, error) {
+	result := FetchResult{Complete: true, PolledAt: time.Now()}
+
+	cnx, err := keystoneClient(ctx, deployment)
+	if err != nil {
+		return result, err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+	}).Info("Updating Projects for the deployment")
+
+	var allPages pagination.Page
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		allPages, err = projects.List(cnx, projects.ListOpts{}).AllPages()
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	projects, err := projects.ExtractProjects(allPages)
+	if err != nil {
+		return result, err
 	}
 
+	for _, p := range projects {
+		result.Resources = append(result.Resources, newProject(p))
+	}
+	return result, nil
 }
 
-func updateProjects(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateProjects)
+func (c *projectsCollector) Reconcile(deployment string, result FetchResult) error {
+	return reconcile(deployment, c, result)
+}
 
-	var inventoryProjects []models.Project
+func (c *projectsCollector) NameField() string { return "Name" }
 
-	bucket := DB.From(deployment)
+func (c *projectsCollector) FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error) {
+	cnx, err := keystoneClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
 
-	//Get all Projects from inventory
-	err := bucket.All(&inventoryProjects)
+	var p *projects.Project
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		p, err = projects.Get(cnx, id).Extract()
+		return err
+	})
 	if err != nil {
-		log.Error(err)
+		return nil, err
 	}
+	return newProject(*p), nil
+}
 
-	cnx := Keystone(deployment)
-	if cnx == nil {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-			"task":       "projects",
-		}).Error("Nothing to update for the deployment. No OpenStack Connectivity")
-	} else {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Updating Projects for the deployment")
-		allPages, err := projects.List(cnx, projects.ListOpts{}).AllPages()
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("Unable to fetch Projects from OpenStack")
-		} else {
-			projects, err := projects.ExtractProjects(allPages)
-			if err != nil {
-				log.Error(err)
-			}
+// newProject converts Keystone Project into Inventory Project
+func newProject(p projects.Project) *models.Project {
+	return &models.Project{
+		ID:          p.ID,
+		Name:        p.Name,
+		Enabled:     p.Enabled,
+		Description: p.Description,
+		PollTime:    time.Now(),
+	}
+}
 
-			for _, p := range projects {
-				prj := &models.Project{
-					ID:          p.ID,
-					Name:        p.Name,
-					Enabled:     p.Enabled,
-					Description: p.Description,
-					PollTime:    time.Now(),
-				}
-				bucket.Save(prj)
-				//updateOrSave("ID", prj.ID, prj, bucket)
-			}
+// aggregatesCollector polls Nova Host Aggregates
+type aggregatesCollector struct{}
 
-			// Projects DB Cleanup
-			for _, i := range inventoryProjects {
-				if !i.Exists(projects) {
-					log.Debug("Deleting project ", i.ID)
-					err := bucket.DeleteStruct(&i)
-					if err != nil {
-						log.Error(err)
-					}
-				}
-			}
+func (c *aggregatesCollector) Name() string           { return "aggregates" }
+func (c *aggregatesCollector) Dependencies() []string { return nil }
+func (c *aggregatesCollector) Model() interface{}     { return &[]models.Aggregate{} }
+
+func (c *aggregatesCollector) Schedule(interval models.PollInterval) string {
+	return fmt.Sprintf("@every %s", interval.Aggregates)
+}
+
+func (c *aggregatesCollector) Fetch(ctx context.Context, deployment string) (FetchResult, error) {
+	result := FetchResult{Complete: true, PolledAt: time.Now()}
+
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return result, err
+	}
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+	}).Info("Updating Aggregates for the deployment")
+
+	var allPages pagination.Page
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		allPages, err = aggregates.List(cnx).AllPages()
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	aggregates, err := aggregates.ExtractAggregates(allPages)
+	if err != nil {
+		return result, err
+	}
+
+	for _, a := range aggregates {
+		if !a.Deleted {
+			result.Resources = append(result.Resources, newAggregate(a))
 		}
 	}
+	return result, nil
+}
 
+func (c *aggregatesCollector) Reconcile(deployment string, result FetchResult) error {
+	return reconcile(deployment, c, result)
 }
 
-func updateAggregates(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateAggregates)
+func (c *aggregatesCollector) NameField() string { return "Name" }
 
-	var inventoryAggregates []models.Aggregate
+func (c *aggregatesCollector) FetchOne(ctx context.Context, deployment string, id string) (models.Resource, error) {
+	aggregateID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, storm.ErrNotFound
+	}
 
-	bucket := DB.From(deployment)
+	cnx, err := novaClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
 
-	//Get all Aggregates from inventory
-	err := bucket.All(&inventoryAggregates)
+	var a *aggregates.Aggregate
+	err = callAPI(ctx, deployment, func() error {
+		var err error
+		a, err = aggregates.Get(cnx, aggregateID).Extract()
+		return err
+	})
 	if err != nil {
-		log.Error(err)
+		return nil, err
 	}
+	return newAggregate(*a), nil
+}
 
+// newAggregate converts Nova Aggregate into Inventory Aggregate
+func newAggregate(a aggregates.Aggregate) *models.Aggregate {
+	return &models.Aggregate{
+		ID:               a.ID,
+		Name:             a.Name,
+		AvailabilityZone: a.AvailabilityZone,
+		Hosts:            a.Hosts,
+		Metadata:         a.Metadata,
+		Created:          a.CreatedAt,
+		Updated:          a.UpdatedAt,
+		PollTime:         time.Now(),
+	}
+}
+
+// novaClient returns Nova API Connection bound to ctx
+func novaClient(ctx context.Context, deployment string) (*gophercloud.ServiceClient, error) {
 	cnx := Nova(deployment)
 	if cnx == nil {
+		connectionFailed(deployment)
+		return nil, errNoConnectivity
+	}
+	return openstack.WithContext(ctx, cnx), nil
+}
+
+// keystoneClient returns Keystone API Connection bound to ctx
+func keystoneClient(ctx context.Context, deployment string) (*gophercloud.ServiceClient, error) {
+	cnx := Keystone(deployment)
+	if cnx == nil {
+		connectionFailed(deployment)
+		return nil, errNoConnectivity
+	}
+	return openstack.WithContext(ctx, cnx), nil
+}
+
+// errNoConnectivity is recorded by the Circuit Breaker when
+// the OpenStack API Connection could not be established
+var errNoConnectivity = errors.New("no OpenStack connectivity")
+
+// errNotRegistered is returned for deployments removed from the config
+var errNotRegistered = errors.New("deployment is not registered")
+
+// pollAllowed checks the deployment Circuit Breaker
+// before the task makes any API call
+func pollAllowed(deployment string, task string) bool {
+	err := Breaker(deployment).Allow()
+	if err != nil {
 		log.WithFields(log.Fields{
 			"deployment": deployment,
-			"task":       "aggregates",
-		}).Error("Nothing to update for the deployment. No OpenStack Connectivity")
-	} else {
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Updating Aggregates for the deployment")
-
-		allPages, err := aggregates.List(cnx).AllPages()
+			"task":       task,
+			"retry_at":   Breaker(deployment).Status().RetryAt,
+		}).Warn("Skipping update for the deployment. Circuit breaker is open")
+		return false
+	}
+	return true
+}
 
-		if err != nil {
-			log.WithFields(log.Fields{"error": err}).Error("Unable to fetch Aggregates from OpenStack")
+// callAPI executes an idempotent OpenStack API call with retries
+// and records the result in the deployment Circuit Breaker.
+// Calls cancelled by ctx are not counted as failures, nor are
+// requests rejected by the API (e.g. 404 of a deleted object).
+// A cancelled trial call reopens the Breaker for another cooldown
+func callAPI(ctx context.Context, deployment string, call func() error) error {
+	err := openstack.Retry(ctx, retryOptions(), call)
+	if err != nil && !openstack.RequestError(err) {
+		if ctx.Err() == nil {
+			Breaker(deployment).Failure(err)
 		} else {
-			aggregates, err := aggregates.ExtractAggregates(allPages)
-			if err != nil {
-				log.Error(err)
-			}
-			for _, a := range aggregates {
-				if !a.Deleted {
-					agg := &models.Aggregate{
-						ID:               a.ID,
-						Name:             a.Name,
-						AvailabilityZone: a.AvailabilityZone,
-						Hosts:            a.Hosts,
-						Metadata:         a.Metadata,
-						Created:          a.CreatedAt,
-						Updated:          a.UpdatedAt,
-						PollTime:         time.Now(),
-					}
-					bucket.Save(agg)
-				}
-			}
-
-			// Aggregates DB Cleanup
-			for _, a := range inventoryAggregates {
-				if !a.Exists(aggregates) {
-					log.Debug("Deleting ", a.Name, " aggregate")
-					err := bucket.DeleteStruct(&a)
-					if err != nil {
-						log.Error(err)
-					}
-				}
-			}
+			Breaker(deployment).Abort()
 		}
+		return err
+	}
+	Breaker(deployment).Success()
+	return err
+}
+
+// connectionFailed records failed API Connection
+// in the deployment Circuit Breaker
+func connectionFailed(deployment string) {
+	Breaker(deployment).Failure(errNoConnectivity)
+}
 
+// changesSinceOverlap is subtracted from the high-water mark to
+// tolerate clock skew between OSSIA and OpenStack API
+const changesSinceOverlap = time.Minute
+
+// fullReconcileInterval returns how often the incremental
+// polling is replaced by the full one
+func fullReconcileInterval() time.Duration {
+	setting := Cfg().PollInterval.FullReconcile
+	interval, err := time.ParseDuration(setting)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"full_reconcile": setting,
+		}).Warn("Invalid full reconciliation interval, using full polling")
+		return 0
 	}
+	return interval
+}
+
+// getPollState returns the polling state of
+// the resource type for the deployment
+func getPollState(deployment string, resource string) models.PollState {
+	var state models.PollState
 
+	bucket := DB.From(deployment)
+	err := bucket.One("Resource", resource, &state)
+	if err != nil {
+		if err != storm.ErrNotFound {
+			log.Error(err)
+		}
+		state.Resource = resource
+	}
+	return state
+}
+
+// savePollState stores the polling state so incremental
+// polling resumes after the restart
+func savePollState(deployment string, state models.PollState) {
+	bucket := DB.From(deployment)
+	err := bucket.Save(&state)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"resource":   state.Resource,
+		}).Error(err)
+	}
 }
 
 // deploymentRegistered - check if deployment from DB is defined
 // in configuration file
 func deploymentRegistered(deployment string) bool {
-	for i := range Cfg.Deployments {
+	for i := range Cfg().Deployments {
 		if i == deployment {
 			return true
 		}
@@ -524,8 +814,9 @@ func dbCleanup() {
 	deployments := DB.PrefixScan("")
 	for _, i := range deployments {
 		deployment := i.Bucket()[0]
-		if !strings.Contains(string(deployment), "storm") {
-			if !deploymentRegistered(deployment) {
+		if inventoryBucket(deployment) {
+			// Disabled deployments registered via API keep their inventory
+			if !deploymentRegistered(deployment) && !isManagedDeployment(deployment) {
 				log.WithFields(log.Fields{
 					"deployment": deployment,
 				}).Warn("Deployment is not registered, deleting")
@@ -533,6 +824,8 @@ func dbCleanup() {
 				if err != nil {
 					log.Error(err)
 				}
+				dropSearchIndex(deployment)
+				forgetInventory(deployment)
 			}
 		}
 
@@ -569,32 +862,68 @@ type NewHypervisor struct{ models.Hypervisor }
 // Instances method adds Instances for the Hypervisor
 // based on its Hash
 func (i *NewHypervisor) Instances(deployment string) []models.Instance {
+	return hypervisorInstances(deployment, []string{i.Hostname})[i.Hostname]
+}
+
+// hypervisorHashes maps HostID hashes to the hostnames,
+// all hypervisors if hostnames is nil
+func hypervisorHashes(deployment string, hostnames []string) map[string]string {
 	var hashes []models.HypervisorHash
-	var instances []models.Instance
 
-	bucket := DB.From(deployment)
-	err := bucket.Find("Hostname", i.Hostname, &hashes)
-	if err != nil {
-		if err == storm.ErrNotFound {
-			log.WithFields(log.Fields{
-				"deployment": deployment,
-				"function":   "Instances",
-			}).Warn("Hash not found")
-		} else {
-			log.Error(err)
+	var matchers []q.Matcher
+	if hostnames != nil {
+		selected := make(map[string]bool, len(hostnames))
+		for _, h := range hostnames {
+			selected[h] = true
 		}
+		matchers = append(matchers, matchFunc(func(v reflect.Value) bool {
+			return selected[v.FieldByName("Hostname").String()]
+		}))
+	}
+	err := DB.From(deployment).Select(matchers...).Find(&hashes)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
 	}
+
+	hosts := make(map[string]string, len(hashes))
 	for _, h := range hashes {
-		err := bucket.Find("HostID", h.Hash, &instances)
-		if err != nil {
-			if err != storm.ErrNotFound {
-				log.Error(err)
-			}
-		}
+		hosts[h.Hash] = h.Hostname
+	}
+	return hosts
+}
 
+// hypervisorInstances returns Instances of the hypervisors by hostname.
+// Hashes and Instances are fetched once for all the hypervisors
+func hypervisorInstances(deployment string, hostnames []string) map[string][]models.Instance {
+	var instances []models.Instance
+
+	result := make(map[string][]models.Instance, len(hostnames))
+	if len(hostnames) == 0 {
+		return result
+	}
+
+	hosts := hypervisorHashes(deployment, hostnames)
+	if len(hosts) == 0 {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"function":   "Instances",
+		}).Warn("Hash not found")
+		return result
 	}
-	return instances
 
+	err := DB.From(deployment).Select(matchFunc(func(v reflect.Value) bool {
+		_, ok := hosts[v.FieldByName("HostID").String()]
+		return ok
+	})).Find(&instances)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+	}
+
+	for _, i := range instances {
+		hostname := hosts[i.HostID]
+		result[hostname] = append(result[hostname], i)
+	}
+	return result
 }
 
 // usageSnapshot - Represent OpenStack Resources Utilization
@@ -668,5 +997,7 @@ func usageSnapshot(deployment string) {
 		MemoryUsedMB: MemoryMB - FreeMemoryMB,
 	}
 	bucket.Save(snapshot)
+	touchInventory(deployment, time.Now())
+	evaluateAlerts(deployment)
 
 }
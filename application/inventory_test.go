@@ -0,0 +1,67 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ossia/models"
+	"ossia/openstack"
+)
+
+func TestCallAPICancelledTrial(t *testing.T) {
+	useTestConfig(t, &models.Configuration{})
+	const deployment = "test-breaker"
+	breaker := openstack.NewBreaker(1, 50*time.Millisecond)
+	breakersMu.Lock()
+	breakers[deployment] = breaker
+	breakersMu.Unlock()
+	t.Cleanup(func() {
+		breakersMu.Lock()
+		delete(breakers, deployment)
+		breakersMu.Unlock()
+	})
+
+	timeout := errors.New("i/o timeout")
+	breaker.Failure(timeout)
+	time.Sleep(60 * time.Millisecond)
+	if !pollAllowed(deployment, "test") {
+		t.Fatal("pollAllowed() after cooldown = false, want trial call")
+	}
+
+	// The cycle deadline cancels the trial call
+	ctx, cancel := context.WithCancel(context.Background())
+	err := callAPI(ctx, deployment, func() error {
+		cancel()
+		return timeout
+	})
+	if err != timeout {
+		t.Fatalf("callAPI() = %v, want %v", err, timeout)
+	}
+	if s := breaker.Status(); s.State != openstack.BreakerOpen || s.Failures != 1 {
+		t.Fatalf("Status() after cancelled trial = %+v, want open without new failure", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !pollAllowed(deployment, "test") {
+		t.Fatal("pollAllowed() after next cooldown = false, want trial call")
+	}
+	if err := callAPI(context.Background(), deployment, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.Status().State; state != openstack.BreakerClosed {
+		t.Fatalf("State after successful trial = %s, want %s", state, openstack.BreakerClosed)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"ossia/models"
+	"ossia/utils"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// jobsHistory is the number of finished jobs kept in memory
+const jobsHistory = 500
+
+// jobEntry is a registered Job with its completion channel
+type jobEntry struct {
+	job  models.Job
+	done chan struct{}
+}
+
+var (
+	jobs   = make(map[string]*jobEntry)
+	jobsMu sync.Mutex
+
+	// deploymentLocks serialize jobs of the same deployment
+	deploymentLocks   = make(map[string]*sync.Mutex)
+	deploymentLocksMu sync.Mutex
+)
+
+// submitJob queues update Job for the deployment. Only the named
+// collectors are run if any. Duplicate request is merged into the
+// queued or running Job covering the same collectors
+func submitJob(deployment string, names []string) (models.Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	for _, e := range jobs {
+		if e.job.Deployment != deployment || !jobActive(e.job) {
+			continue
+		}
+		if covers(e.job.Collectors, names) {
+			e.job.Merged++
+			log.WithFields(log.Fields{
+				"deployment": deployment,
+				"job":        e.job.ID,
+			}).Info("Update is already in progress, merging the request")
+			return copyJob(e.job), true
+		}
+	}
+
+	job := models.Job{
+		ID:         utils.NewID(),
+		Deployment: deployment,
+		Collectors: names,
+		Status:     models.JobQueued,
+		Created:    time.Now(),
+	}
+	for _, c := range Collectors() {
+		if len(names) == 0 || contains(names, c.Name()) {
+			job.Results = append(job.Results, models.CollectorResult{
+				Name:   c.Name(),
+				Status: models.JobQueued,
+			})
+		}
+	}
+
+	jobs[job.ID] = &jobEntry{job: job, done: make(chan struct{})}
+	trimJobs()
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+		"job":        job.ID,
+		"collectors": names,
+	}).Info("Queued update job")
+
+	go runJob(job.ID, deployment, names)
+	return copyJob(job), false
+}
+
+// runJob executes the Job once the previous job
+// of the deployment is finished
+func runJob(id string, deployment string, names []string) {
+	lock := deploymentLock(deployment)
+	lock.Lock()
+	defer lock.Unlock()
+
+	updateJob(id, func(job *models.Job) {
+		job.Status = models.JobRunning
+		job.Started = time.Now()
+	})
+
+	refreshDeployment(deployment, names, func(result models.CollectorResult) {
+		updateJob(id, func(job *models.Job) {
+			for i := range job.Results {
+				if job.Results[i].Name == result.Name {
+					job.Results[i] = result
+				}
+			}
+		})
+	})
+	if len(names) == 0 {
+		dbCleanup()
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	e, ok := jobs[id]
+	if !ok {
+		return
+	}
+	job := &e.job
+	job.Status = models.JobSucceeded
+	for i, r := range job.Results {
+		switch r.Status {
+		case models.JobSucceeded:
+			continue
+		case models.JobQueued, models.JobRunning:
+			job.Results[i].Status = models.JobFailed
+			job.Results[i].Error = errDeadlineExceeded.Error()
+		}
+		job.Status = models.JobFailed
+	}
+	job.Finished = time.Now()
+	close(e.done)
+
+	log.WithFields(log.Fields{
+		"deployment": deployment,
+		"job":        id,
+		"status":     job.Status,
+		"duration":   job.Finished.Sub(job.Started),
+	}).Info("Update job finished")
+}
+
+// updateJob applies the change to the Job unless it is finished
+func updateJob(id string, change func(job *models.Job)) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	e, ok := jobs[id]
+	if !ok || !jobActive(e.job) {
+		return
+	}
+	change(&e.job)
+}
+
+// waitJob blocks until the Job is finished
+func waitJob(id string) {
+	jobsMu.Lock()
+	e, ok := jobs[id]
+	jobsMu.Unlock()
+	if ok {
+		<-e.done
+	}
+}
+
+// getJob returns the Job by its ID
+func getJob(id string) (models.Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	e, ok := jobs[id]
+	if !ok {
+		return models.Job{}, false
+	}
+	return copyJob(e.job), true
+}
+
+// listJobs returns jobs of the deployment, newest first
+func listJobs(deployment string) []models.Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	list := []models.Job{}
+	for _, e := range jobs {
+		if e.job.Deployment == deployment {
+			list = append(list, copyJob(e.job))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+// trimJobs drops the oldest finished jobs above the history size
+func trimJobs() {
+	if len(jobs) <= jobsHistory {
+		return
+	}
+
+	var finished []models.Job
+	for _, e := range jobs {
+		if !jobActive(e.job) {
+			finished = append(finished, e.job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Created.Before(finished[j].Created)
+	})
+	for i := 0; i < len(finished) && len(jobs) > jobsHistory; i++ {
+		delete(jobs, finished[i].ID)
+	}
+}
+
+// deploymentLock returns the jobs lock of the deployment
+func deploymentLock(deployment string) *sync.Mutex {
+	deploymentLocksMu.Lock()
+	defer deploymentLocksMu.Unlock()
+
+	lock, ok := deploymentLocks[deployment]
+	if !ok {
+		lock = &sync.Mutex{}
+		deploymentLocks[deployment] = lock
+	}
+	return lock
+}
+
+// jobActive checks if the Job is queued or running
+func jobActive(job models.Job) bool {
+	return job.Status == models.JobQueued || job.Status == models.JobRunning
+}
+
+// covers checks if collectors of the active job include
+// the requested ones. Empty list means all collectors
+func covers(active []string, requested []string) bool {
+	if len(active) == 0 {
+		return true
+	}
+	if len(requested) == 0 {
+		return false
+	}
+	for _, name := range requested {
+		if !contains(active, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyJob returns the Job copy safe to use without the lock
+func copyJob(job models.Job) models.Job {
+	job.Collectors = append([]string(nil), job.Collectors...)
+	job.Results = append([]models.CollectorResult(nil), job.Results...)
+	return job
+}
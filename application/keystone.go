@@ -0,0 +1,137 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"context"
+	"ossia/middleware"
+	"ossia/openstack"
+	"sort"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/roles"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/pagination"
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+// keystoneDeployments returns the deployment of the request, given in
+// the path or the deployment parameter, the home deployment otherwise.
+// Tokens are not checked against every deployment
+func keystoneDeployments(c iris.Context) []string {
+	deployment := c.Params().Get("deployment")
+	if deployment == "" {
+		deployment = c.URLParam("deployment")
+	}
+	if deployment != "" && deploymentRegistered(deployment) {
+		return []string{deployment}
+	}
+
+	home := Cfg().Auth.Keystone.Home
+	if home != "" && deploymentRegistered(home) {
+		return []string{home}
+	}
+	return nil
+}
+
+// validateKeystoneToken validates the OpenStack token with the OSSIA
+// service connection of the deployment and lists the projects
+// the user has roles in
+func validateKeystoneToken(deployment string, token string) (*middleware.KeystoneGrant, error) {
+	ctx := context.Background()
+	if timeout := Cfg().APIClient.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cnx, err := keystoneClient(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	var result tokens.GetResult
+	err = openstack.Retry(ctx, retryOptions(), func() error {
+		result = tokens.Get(cnx, token)
+		return result.Err
+	})
+	switch err.(type) {
+	case nil:
+	case gophercloud.ErrDefault401, gophercloud.ErrDefault404:
+		return nil, middleware.ErrInvalidCredentials
+	default:
+		return nil, err
+	}
+
+	t, err := result.ExtractToken()
+	if err != nil {
+		return nil, err
+	}
+	user, err := result.ExtractUser()
+	if err != nil {
+		return nil, err
+	}
+	tokenRoles, err := result.ExtractRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	grant := &middleware.KeystoneGrant{
+		Deployment: deployment,
+		UserID:     user.ID,
+		UserName:   user.Name,
+		ExpiresAt:  t.ExpiresAt,
+	}
+	for _, r := range tokenRoles {
+		grant.Roles = append(grant.Roles, r.Name)
+	}
+
+	projectIDs := make(map[string]bool)
+	if project, err := result.ExtractProject(); err == nil && project != nil && project.ID != "" {
+		projectIDs[project.ID] = true
+	}
+
+	// Token is scoped to one project, role assignments cover the others
+	effective := true
+	var allPages pagination.Page
+	err = openstack.Retry(ctx, retryOptions(), func() error {
+		var err error
+		allPages, err = roles.ListAssignments(cnx, roles.ListAssignmentsOpts{
+			UserID:    user.ID,
+			Effective: &effective,
+		}).AllPages()
+		return err
+	})
+	if err == nil {
+		var assignments []roles.RoleAssignment
+		assignments, err = roles.ExtractRoleAssignments(allPages)
+		for _, a := range assignments {
+			if a.Scope.Project.ID != "" {
+				projectIDs[a.Scope.Project.ID] = true
+			}
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"user":       user.Name,
+			"error":      err,
+		}).Warn("Unable to list role assignments, using the token project")
+	}
+
+	for id := range projectIDs {
+		grant.ProjectIDs = append(grant.ProjectIDs, id)
+	}
+	sort.Strings(grant.ProjectIDs)
+	return grant, nil
+}
@@ -0,0 +1,508 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"errors"
+	"fmt"
+	"ossia/filter"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/kataras/iris/v12"
+)
+
+// maxListLimit caps the page size of the list endpoints
+const maxListLimit = 1000
+
+// defaultListLimit is the page size of the /v2 list endpoints
+// without the limit parameter
+const defaultListLimit = 100
+
+var errInvalidMarker = errors.New("marker not found")
+
+// listQuery is the filter, pagination (limit, marker), sorting and
+// sparse field selection requested from the list endpoints:
+// ?q=status==ACTIVE&limit=100&marker=<id>&sort=name,-created&fields=id,name
+type listQuery struct {
+	expr   filter.Expr
+	limit  int
+	marker string
+	sort   []sortKey
+	fields []string
+}
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// newListQuery parses the list parameters of the request. Field names
+// are matched against the model ignoring case and underscores
+func newListQuery(c iris.Context, model interface{}) (*listQuery, error) {
+	l := &listQuery{marker: c.URLParam("marker")}
+	t := reflect.TypeOf(model)
+
+	if s := c.URLParam("q"); s != "" {
+		expr, err := filter.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, cmp := range filter.Comparisons(expr) {
+			if !validPath(t, cmp.Path) {
+				return nil, fmt.Errorf("invalid filter field %s", cmp.Path)
+			}
+		}
+		l.expr = expr
+	}
+
+	if limit := c.URLParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %s", limit)
+		}
+		if n > maxListLimit {
+			n = maxListLimit
+		}
+		l.limit = n
+	}
+
+	if s := c.URLParam("sort"); s != "" {
+		for _, key := range strings.Split(s, ",") {
+			key = strings.TrimSpace(key)
+			desc := strings.HasPrefix(key, "-")
+			key = strings.TrimLeft(key, "+-")
+			field, ok := modelField(t, key)
+			if !ok || !sortable(t, field) {
+				return nil, fmt.Errorf("invalid sort field %s", key)
+			}
+			l.sort = append(l.sort, sortKey{field: field, desc: desc})
+		}
+	}
+
+	if s := c.URLParam("fields"); s != "" {
+		if t.Kind() != reflect.Struct {
+			return nil, errors.New("fields are not supported")
+		}
+		for _, name := range strings.Split(s, ",") {
+			field, ok := modelField(t, strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("invalid field %s", name)
+			}
+			l.fields = append(l.fields, field)
+		}
+	}
+	return l, nil
+}
+
+// modelField returns the struct field matching name. The elements
+// of string lists are exposed as the name field
+func modelField(t reflect.Type, name string) (string, bool) {
+	key := normalizeField(name)
+	if t.Kind() != reflect.Struct {
+		return "", key == "name"
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if normalizeField(f.Name) == key || normalizeField(jsonName(f)) == key {
+			return f.Name, true
+		}
+	}
+	return "", false
+}
+
+func normalizeField(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// jsonName returns the field name in the JSON responses
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// sortable excludes lists, maps, computed fields
+// and nested objects except times
+func sortable(t reflect.Type, field string) bool {
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	if contains(computedFields[t], field) {
+		return false
+	}
+	f, _ := t.FieldByName(field)
+	switch f.Type.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		return false
+	case reflect.Struct:
+		return f.Type == reflect.TypeOf(time.Time{})
+	}
+	return true
+}
+
+// filterMatcher evaluates the filter on the deployment resources,
+// nil if there is no filter
+func (l *listQuery) filterMatcher(deployment string) q.Matcher {
+	if l == nil || l.expr == nil {
+		return nil
+	}
+	return newResolver(deployment).matcher(l.expr)
+}
+
+// find runs the Storm query with the matchers on the node into to,
+// a pointer to a slice of the model. Items are returned in the ID
+// order unless sorted. Filters comparing an indexed field by equality
+// fetch the items from the index. Returns the marker of the next page
+func (l *listQuery) find(node storm.Node, to interface{}, matchers ...q.Matcher) (string, error) {
+	if l == nil {
+		l = &listQuery{}
+	}
+
+	if l.marker != "" {
+		marker := reflect.New(reflect.TypeOf(to).Elem().Elem())
+		id, err := markerValue(marker.Elem().Type(), l.marker)
+		if err != nil {
+			return "", errInvalidMarker
+		}
+		err = node.One(idField(marker.Elem().Type()), id, marker.Interface())
+		if err == storm.ErrNotFound {
+			return "", errInvalidMarker
+		}
+		if err != nil {
+			return "", err
+		}
+		matchers = append(matchers, afterMatcher{query: l, marker: marker.Elem()})
+	}
+
+	if field, value, ok := indexedField(reflect.TypeOf(to).Elem().Elem(), l.expr); ok {
+		err := findIndexed(node, field, value, to)
+		if err != nil && err != storm.ErrNotFound {
+			return "", err
+		}
+		v := reflect.ValueOf(to).Elem()
+		err = filterItems(v, matchers)
+		if err != nil {
+			return "", err
+		}
+		return l.paginate(v), nil
+	}
+
+	query := node.Select(matchers...)
+	if len(l.sort) > 0 && l.limit > 0 {
+		err := l.findSorted(query, to)
+		if err != nil && err != storm.ErrNotFound {
+			return "", err
+		}
+		return l.paginate(reflect.ValueOf(to).Elem()), nil
+	}
+	if l.limit > 0 {
+		// One more item tells if there is a next page
+		query = query.Limit(l.limit + 1)
+	}
+	err := query.Find(to)
+	if err != nil && err != storm.ErrNotFound {
+		return "", err
+	}
+	return l.paginate(reflect.ValueOf(to).Elem()), nil
+}
+
+// findSorted keeps the first limit+1 items in the sort order while
+// iterating the query, instead of loading all the matching items
+func (l *listQuery) findSorted(query storm.Query, to interface{}) error {
+	v := reflect.ValueOf(to).Elem()
+	kept := reflect.MakeSlice(v.Type(), 0, l.limit+1)
+	err := query.Each(reflect.New(v.Type().Elem()).Interface(), func(i interface{}) error {
+		item := reflect.ValueOf(i).Elem()
+		n := kept.Len()
+		at := sort.Search(n, func(j int) bool { return l.compare(item, kept.Index(j)) < 0 })
+		if at > l.limit {
+			return nil
+		}
+		if n <= l.limit {
+			kept = reflect.Append(kept, item)
+			n++
+		}
+		reflect.Copy(kept.Slice(at+1, n), kept.Slice(at, n-1))
+		kept.Index(at).Set(item)
+		return nil
+	})
+	v.Set(kept)
+	return err
+}
+
+// page applies the list query to the items already in memory.
+// Unsorted items keep their order. Returns the page and the
+// marker of the next page
+func (l *listQuery) page(items interface{}) (interface{}, string, error) {
+	if l == nil {
+		return items, "", nil
+	}
+
+	src := reflect.ValueOf(items)
+	v := reflect.New(src.Type()).Elem()
+	v.Set(reflect.AppendSlice(reflect.MakeSlice(src.Type(), 0, src.Len()), src))
+	if l.expr != nil {
+		err := filterItems(v, []q.Matcher{newResolver("").matcher(l.expr)})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	l.sortItems(v)
+
+	if l.marker != "" {
+		found := false
+		for i := 0; i < v.Len(); i++ {
+			if itemID(v.Index(i)) == l.marker {
+				v.Set(v.Slice(i+1, v.Len()))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, "", errInvalidMarker
+		}
+	}
+
+	next := l.paginate(v)
+	return v.Interface(), next, nil
+}
+
+// findIndexed fetches the items with the field value from the
+// Storm index, or the single item if the field is the ID
+func findIndexed(node storm.Node, field string, value string, to interface{}) error {
+	v := reflect.ValueOf(to).Elem()
+	f, _ := v.Type().Elem().FieldByName(field)
+	if f.Tag.Get("storm") != "id" {
+		return node.Find(field, value, to)
+	}
+
+	item := reflect.New(v.Type().Elem())
+	err := node.One(field, value, item.Interface())
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.Append(reflect.MakeSlice(v.Type(), 0, 1), item.Elem()))
+	return nil
+}
+
+// filterItems keeps the items of the slice matching all the matchers
+func filterItems(v reflect.Value, matchers []q.Matcher) error {
+	kept := reflect.MakeSlice(v.Type(), 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		ok := true
+		for _, m := range matchers {
+			matched, err := m.Match(v.Index(i).Addr().Interface())
+			if err != nil {
+				return err
+			}
+			if !matched {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			kept = reflect.Append(kept, v.Index(i))
+		}
+	}
+	v.Set(kept)
+	return nil
+}
+
+// paginate sorts and truncates the slice to the limit
+func (l *listQuery) paginate(v reflect.Value) string {
+	l.sortItems(v)
+	if l.limit == 0 || v.Len() <= l.limit {
+		return ""
+	}
+	v.Set(v.Slice(0, l.limit))
+	return itemID(v.Index(l.limit - 1))
+}
+
+func (l *listQuery) sortItems(v reflect.Value) {
+	if len(l.sort) == 0 {
+		return
+	}
+	sort.SliceStable(v.Interface(), func(i, j int) bool {
+		return l.compare(v.Index(i), v.Index(j)) < 0
+	})
+}
+
+// compare orders the items by the sort keys and their IDs
+func (l *listQuery) compare(a reflect.Value, b reflect.Value) int {
+	for _, key := range l.sort {
+		c := compareValues(sortValue(a, key.field), sortValue(b, key.field))
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareValues(idValue(a), idValue(b))
+}
+
+// view returns the items with the selected fields only
+func (l *listQuery) view(items interface{}) interface{} {
+	if l == nil || len(l.fields) == 0 {
+		return items
+	}
+	v := reflect.ValueOf(items)
+	result := make([]map[string]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		item := reflect.Indirect(v.Index(i))
+		fields := make(map[string]interface{}, len(l.fields))
+		for _, name := range l.fields {
+			f, _ := item.Type().FieldByName(name)
+			fields[jsonName(f)] = item.FieldByName(name).Interface()
+		}
+		result = append(result, fields)
+	}
+	return result
+}
+
+// respond adds the selected items and the next page to the response.
+// The next page is linked with the Link header as well
+func (l *listQuery) respond(c iris.Context, response iris.Map, key string, items interface{}, next string) {
+	response[key] = l.view(items)
+	setRows(c, response[key])
+	if next == "" {
+		return
+	}
+	response["next_marker"] = next
+
+	u := *c.Request().URL
+	query := u.Query()
+	query.Set("marker", next)
+	u.RawQuery = query.Encode()
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
+
+// listQueryError responds 400 to invalid list parameters
+func listQueryError(c iris.Context, err error) {
+	c.StatusCode(iris.StatusBadRequest)
+	c.JSON(iris.Map{
+		"message": fmt.Sprintf("Invalid list parameters: %v", err),
+	})
+}
+
+// afterMatcher selects the items following the marker
+// in the order of the list query
+type afterMatcher struct {
+	query  *listQuery
+	marker reflect.Value
+}
+
+func (m afterMatcher) Match(i interface{}) (bool, error) {
+	return m.query.compare(reflect.Indirect(reflect.ValueOf(i)), m.marker) > 0, nil
+}
+
+// matchFunc adapts the function to the Storm query Matcher
+type matchFunc func(v reflect.Value) bool
+
+func (m matchFunc) Match(i interface{}) (bool, error) {
+	return m(reflect.Indirect(reflect.ValueOf(i))), nil
+}
+
+// idField is ID for the inventory models, Name for the others
+func idField(t reflect.Type) string {
+	if _, ok := t.FieldByName("ID"); ok {
+		return "ID"
+	}
+	return "Name"
+}
+
+// idValue returns the item identifier
+func idValue(v reflect.Value) reflect.Value {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return v
+	}
+	return v.FieldByName(idField(v.Type()))
+}
+
+// itemID returns the item identifier used as the marker
+func itemID(v reflect.Value) string {
+	return fmt.Sprint(idValue(v).Interface())
+}
+
+// markerValue converts the marker to the type of the model identifier
+func markerValue(t reflect.Type, marker string) (interface{}, error) {
+	f, _ := t.FieldByName(idField(t))
+	switch f.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(marker, 10, 64)
+		return reflect.ValueOf(n).Convert(f.Type).Interface(), err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(marker, 10, 64)
+		return reflect.ValueOf(n).Convert(f.Type).Interface(), err
+	}
+	return marker, nil
+}
+
+func sortValue(v reflect.Value, field string) reflect.Value {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return v
+	}
+	return v.FieldByName(field)
+}
+
+// compareValues compares numbers, strings, booleans and times
+func compareValues(a reflect.Value, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareFloats(float64(a.Int()), float64(b.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return compareFloats(float64(a.Uint()), float64(b.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return compareFloats(a.Float(), b.Float())
+	case reflect.Bool:
+		switch {
+		case a.Bool() == b.Bool():
+			return 0
+		case b.Bool():
+			return -1
+		}
+		return 1
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	}
+	if ta, ok := a.Interface().(time.Time); ok {
+		tb := b.Interface().(time.Time)
+		switch {
+		case ta.Before(tb):
+			return -1
+		case ta.After(tb):
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+}
+
+func compareFloats(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"ossia/models"
+)
+
+func TestFindSorted(t *testing.T) {
+	openTestDB(t)
+	bucket := DB.From("lab")
+	for i := 0; i < 20; i++ {
+		f := models.Flavor{ID: fmt.Sprintf("f%02d", i), Name: fmt.Sprintf("flavor-%d", i), VCPUs: (i * 7) % 10}
+		if err := bucket.Save(&f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query listQuery
+		want  []string
+		next  string
+	}{
+		{"unsorted", listQuery{limit: 3}, []string{"f00", "f01", "f02"}, "f02"},
+		{"ascending", listQuery{limit: 4, sort: []sortKey{{field: "VCPUs"}}}, []string{"f00", "f10", "f03", "f13"}, "f13"},
+		{"descending", listQuery{limit: 3, sort: []sortKey{{field: "VCPUs", desc: true}}}, []string{"f07", "f17", "f04"}, "f04"},
+		{"last page", listQuery{limit: 30, sort: []sortKey{{field: "VCPUs", desc: true}}}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flavors []models.Flavor
+			next, err := tt.query.find(bucket, &flavors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != tt.next {
+				t.Errorf("next marker = %q, want %q", next, tt.next)
+			}
+			if tt.want == nil {
+				if len(flavors) != 20 {
+					t.Errorf("found %d flavors, want 20", len(flavors))
+				}
+				return
+			}
+			var got []string
+			for _, f := range flavors {
+				got = append(got, f.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("found %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidPath(t *testing.T) {
+	tests := []struct {
+		model interface{}
+		path  string
+		want  bool
+	}{
+		{models.Instance{}, "status", true},
+		{models.Instance{}, "flavor.vcpus", true},
+		{models.Instance{}, "hypervisor.aggregate.name", true},
+		{models.Instance{}, "flavor.unknown", false},
+		{models.Hypervisor{}, "running_vms", true},
+		{models.Hypervisor{}, "vms", false},
+		{models.Instance{}, "hypervisor.vms", false},
+	}
+	for _, tt := range tests {
+		if got := validPath(reflect.TypeOf(tt.model), tt.path); got != tt.want {
+			t.Errorf("validPath(%T, %s) = %v, want %v", tt.model, tt.path, got, tt.want)
+		}
+	}
+}
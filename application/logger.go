@@ -19,7 +19,7 @@ import (
 )
 
 func getLogLevel() log.Level {
-	if Cfg.Debug {
+	if Cfg().Debug {
 		return log.DebugLevel
 	}
 	return log.InfoLevel
@@ -36,8 +36,8 @@ func setupLogger() {
 
 	// Output to stdout instead of the default stderr
 	// Can be any io.Writer, see below for File example
-	if Cfg.LogFile != "" {
-		f, err := os.OpenFile(Cfg.LogFile, os.O_WRONLY|os.O_CREATE, 0755)
+	if logFile := Cfg().LogFile; logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE, 0755)
 		if err != nil {
 			fmt.Println("Can't write to log: ", err)
 			fmt.Println("Writing to Stdout/Stderr")
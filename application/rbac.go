@@ -0,0 +1,314 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"fmt"
+	"ossia/middleware"
+	"ossia/models"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/asdine/storm/q"
+	"github.com/kataras/iris/v12"
+)
+
+const accessKey = "access"
+
+// access is the set of RBAC roles of the API caller.
+// nil access is not restricted
+type access struct {
+	roles []models.Role
+}
+
+// authorize resolves the caller roles and rejects operations
+// on deployments not allowed by them
+func authorize(c iris.Context) {
+	a := callerAccess(middleware.GetIdentity(c))
+	if a == nil {
+		c.Next()
+		return
+	}
+
+	operation := requiredScope(c)
+	deployment := c.Params().Get("deployment")
+	if !a.allows(operation, deployment) {
+		message := fmt.Sprintf("Operation %s is not allowed", operation)
+		if deployment != "" {
+			message = fmt.Sprintf("Operation %s is not allowed on %s deployment", operation, deployment)
+		}
+		apiError(c, iris.StatusForbidden, message)
+		c.StopExecution()
+		return
+	}
+
+	c.Values().Set(accessKey, a)
+	c.Next()
+}
+
+// callerAccess returns RBAC roles of the identity, nil if RBAC is not
+// configured, the request is public or the caller has the admin scope.
+// Callers without roles are denied
+func callerAccess(identity *middleware.Identity) *access {
+	if identity != nil && identity.Method == "keystone" {
+		return keystoneAccess(identity)
+	}
+
+	rbac := Cfg().RBAC
+	if identity == nil || len(rbac.Roles) == 0 || identity.HasScope(middleware.ScopeAdmin) {
+		return nil
+	}
+
+	names := identity.Roles
+	for _, b := range rbac.Bindings {
+		if b.Subject == identity.Subject {
+			names = append(names, b.Roles...)
+		}
+	}
+
+	a := &access{roles: []models.Role{}}
+	for _, name := range names {
+		// Role names are case insensitive in the config file
+		if role, ok := rbac.Roles[strings.ToLower(name)]; ok {
+			a.roles = append(a.roles, role)
+		}
+	}
+	return a
+}
+
+// keystoneAccess maps Keystone grants to roles. Admins see all projects
+// of the deployment, other users the projects they have roles in
+func keystoneAccess(identity *middleware.Identity) *access {
+	a := &access{roles: []models.Role{}}
+	for _, g := range identity.Keystone {
+		role := models.Role{
+			Deployments: []string{g.Deployment},
+			Operations:  identity.Scopes,
+		}
+		if !g.Admin {
+			// Empty Projects would allow all of them
+			if len(g.ProjectIDs) == 0 {
+				continue
+			}
+			role.Projects = g.ProjectIDs
+		}
+		a.roles = append(a.roles, role)
+	}
+	return a
+}
+
+// getAccess returns the access of the authorized request
+func getAccess(c iris.Context) *access {
+	a, _ := c.Values().Get(accessKey).(*access)
+	return a
+}
+
+// allows checks the operation on the deployment. Any deployment
+// matches if empty. The admin operation implies the others
+func (a *access) allows(operation string, deployment string) bool {
+	if a == nil {
+		return true
+	}
+	for _, role := range a.roles {
+		if !matchAny(role.Operations, operation) && !matchAny(role.Operations, middleware.ScopeAdmin) {
+			continue
+		}
+		if deployment == "" || matchAny(role.Deployments, deployment) {
+			return true
+		}
+	}
+	return false
+}
+
+// canRead checks the deployment is visible to the caller
+func (a *access) canRead(deployment string) bool {
+	return a.allows(middleware.ScopeRead, deployment)
+}
+
+// allProjects is true if the caller may see every project of the deployment
+func (a *access) allProjects(deployment string) bool {
+	if a == nil {
+		return true
+	}
+	for _, role := range a.roles {
+		if matchAny(role.Deployments, deployment) && (len(role.Projects) == 0 || matchAny(role.Projects, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectAllowed checks the project name or ID against the roles
+// allowing the deployment
+func (a *access) projectAllowed(deployment string, project models.Project) bool {
+	if a.allProjects(deployment) {
+		return true
+	}
+	for _, role := range a.roles {
+		if !matchAny(role.Deployments, deployment) {
+			continue
+		}
+		if matchAny(role.Projects, project.Name) || matchAny(role.Projects, project.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterProjects returns the projects allowed to the caller
+func (a *access) filterProjects(deployment string, projects []models.Project) []models.Project {
+	if a.allProjects(deployment) {
+		return projects
+	}
+	allowed := []models.Project{}
+	for _, p := range projects {
+		if a.projectAllowed(deployment, p) {
+			allowed = append(allowed, p)
+		}
+	}
+	return allowed
+}
+
+// projectIDs returns IDs of the projects allowed to the caller,
+// nil if all projects are allowed
+func (a *access) projectIDs(deployment string) map[string]bool {
+	if a.allProjects(deployment) {
+		return nil
+	}
+	ids := make(map[string]bool)
+	for _, p := range a.filterProjects(deployment, listProjects(deployment)) {
+		ids[p.ID] = true
+	}
+	return ids
+}
+
+// instanceAllowed checks the project of the instance
+func (a *access) instanceAllowed(deployment string, instance models.Instance) bool {
+	ids := a.projectIDs(deployment)
+	return ids == nil || ids[instance.ProjectID]
+}
+
+// filterInstances returns the instances of projects allowed to the caller
+func (a *access) filterInstances(deployment string, instances []models.Instance) []models.Instance {
+	ids := a.projectIDs(deployment)
+	if ids == nil {
+		return instances
+	}
+	allowed := []models.Instance{}
+	for _, i := range instances {
+		if ids[i.ProjectID] {
+			allowed = append(allowed, i)
+		}
+	}
+	return allowed
+}
+
+// filterInstanceNames returns the names of instances allowed to the caller,
+// used for hypervisor and image references
+func (a *access) filterInstanceNames(deployment string, names []string) []string {
+	return a.instanceNameFilter(deployment)(names)
+}
+
+// instanceNameFilter returns the filter of instance names allowed
+// to the caller. Instances are fetched once for all the lists
+func (a *access) instanceNameFilter(deployment string) func(names []string) []string {
+	if a.allProjects(deployment) {
+		return func(names []string) []string { return names }
+	}
+	visible := make(map[string]bool)
+	for _, i := range a.filterInstances(deployment, listInstances(deployment, "")) {
+		visible[i.Name] = true
+	}
+	return func(names []string) []string {
+		allowed := []string{}
+		for _, name := range names {
+			if visible[name] {
+				allowed = append(allowed, name)
+			}
+		}
+		return allowed
+	}
+}
+
+// projectMatcher selects the projects allowed to the caller
+// in Storm queries, nil if all projects are allowed
+func (a *access) projectMatcher(deployment string) q.Matcher {
+	if a.allProjects(deployment) {
+		return nil
+	}
+	return matchFunc(func(v reflect.Value) bool {
+		return a.projectAllowed(deployment, v.Interface().(models.Project))
+	})
+}
+
+// instanceMatcher selects the instances of projects allowed
+// to the caller in Storm queries, nil if all projects are allowed
+func (a *access) instanceMatcher(deployment string) q.Matcher {
+	ids := a.projectIDs(deployment)
+	if ids == nil {
+		return nil
+	}
+	return matchFunc(func(v reflect.Value) bool {
+		return ids[v.FieldByName("ProjectID").String()]
+	})
+}
+
+// resourceAllowed checks the project of instances and projects,
+// other resource types are allowed with the deployment
+func (a *access) resourceAllowed(deployment string, r models.Resource) bool {
+	switch v := r.(type) {
+	case *models.Instance:
+		return a.instanceAllowed(deployment, *v)
+	case *models.Project:
+		return a.projectAllowed(deployment, *v)
+	}
+	return true
+}
+
+// taskAllowed checks the operation on the scheduler task.
+// Global tasks require the admin operation on all deployments
+func (a *access) taskAllowed(operation string, task models.ScheduledTask) bool {
+	if task.Deployment == "" {
+		return a.allowsGlobal(middleware.ScopeAdmin)
+	}
+	return a.allows(operation, task.Deployment)
+}
+
+// allowsGlobal checks the operation is granted by a role
+// of all deployments ("*"), not only of some of them
+func (a *access) allowsGlobal(operation string) bool {
+	if a == nil {
+		return true
+	}
+	for _, role := range a.roles {
+		if !matchAny(role.Operations, operation) && !matchAny(role.Operations, middleware.ScopeAdmin) {
+			continue
+		}
+		for _, deployment := range role.Deployments {
+			if deployment == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchAny matches the value against glob patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"testing"
+
+	"ossia/middleware"
+	"ossia/models"
+)
+
+func TestTaskAllowed(t *testing.T) {
+	global := models.ScheduledTask{Name: "dbCleanup"}
+	deployment := models.ScheduledTask{Name: "us-west-1:instances", Deployment: "us-west-1"}
+
+	tests := []struct {
+		name       string
+		access     *access
+		global     bool
+		deployment bool
+	}{
+		{"unrestricted", nil, true, true},
+		{"admin of one deployment", &access{roles: []models.Role{
+			{Deployments: []string{"us-west-1"}, Operations: []string{middleware.ScopeAdmin}},
+		}}, false, true},
+		{"admin of matching deployments", &access{roles: []models.Role{
+			{Deployments: []string{"us-west-*"}, Operations: []string{middleware.ScopeAdmin}},
+		}}, false, true},
+		{"admin of all deployments", &access{roles: []models.Role{
+			{Deployments: []string{"*"}, Operations: []string{middleware.ScopeAdmin}},
+		}}, true, true},
+		{"operator of all deployments", &access{roles: []models.Role{
+			{Deployments: []string{"*"}, Operations: []string{middleware.ScopeRead, middleware.ScopeRefresh}},
+		}}, false, true},
+		{"admin of other deployment", &access{roles: []models.Role{
+			{Deployments: []string{"eu-west-1"}, Operations: []string{middleware.ScopeAdmin}},
+		}}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, op := range []string{middleware.ScopeRead, middleware.ScopeRefresh} {
+				if got := tt.access.taskAllowed(op, global); got != tt.global {
+					t.Errorf("taskAllowed(%s, global) = %v, want %v", op, got, tt.global)
+				}
+				if got := tt.access.taskAllowed(op, deployment); got != tt.deployment {
+					t.Errorf("taskAllowed(%s, deployment) = %v, want %v", op, got, tt.deployment)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Response formats, selected by ?format= or the Accept header
+const (
+	formatJSON   = "json"
+	formatYAML   = "yaml"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// formatTypes maps the media types to the response formats
+var formatTypes = map[string]string{
+	"application/json":     formatJSON,
+	"application/x-yaml":   formatYAML,
+	"application/yaml":     formatYAML,
+	"text/yaml":            formatYAML,
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+}
+
+// rowsKey is the context key of the rows rendered by CSV and NDJSON
+const rowsKey = "ossia.rows"
+
+// ndjsonFlushRows is the amount of NDJSON rows written between flushes
+const ndjsonFlushRows = 100
+
+// setRows registers the items of a list or a report. CSV and NDJSON
+// render them one per line instead of the whole response
+func setRows(c iris.Context, rows interface{}) {
+	c.Values().Set(rowsKey, rows)
+}
+
+// render writes the response in the format requested by the client.
+// Errors are rendered as JSON unless YAML is requested
+func render(c iris.Context, response interface{}) {
+	format, err := responseFormat(c)
+	if err != nil {
+		c.StatusCode(iris.StatusNotAcceptable)
+		c.JSON(iris.Map{"message": err.Error()})
+		return
+	}
+	if c.GetStatusCode() >= iris.StatusBadRequest && format != formatYAML {
+		format = formatJSON
+	}
+	setValidators(c)
+
+	switch format {
+	case formatYAML:
+		recordResponse(c)
+		err = renderYAML(c, response)
+	case formatCSV:
+		recordResponse(c)
+		err = renderCSV(c, responseRows(c, response))
+	case formatNDJSON:
+		// streamed, so compressed as soon as it spans several flushes
+		rows := responseRows(c, response)
+		if rows.Len() > ndjsonFlushRows && acceptsCompression(c) {
+			compress(c)
+		}
+		err = renderNDJSON(c, rows)
+	default:
+		recordResponse(c)
+		_, err = c.JSON(response)
+	}
+	endCompression(c)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":   c.Path(),
+			"format": format,
+		}).Error(err)
+	}
+}
+
+// responseFormat returns the format of ?format= or the first
+// supported media type of the Accept header, JSON by default
+func responseFormat(c iris.Context) (string, error) {
+	if format := strings.ToLower(c.URLParam("format")); format != "" {
+		switch format {
+		case formatJSON, formatYAML, formatCSV, formatNDJSON:
+			return format, nil
+		}
+		return "", fmt.Errorf("Unsupported format %s, use json, yaml, csv or ndjson", format)
+	}
+
+	for _, mediaRange := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(mediaRange, ";")[0])
+		if format, ok := formatTypes[strings.ToLower(mediaType)]; ok {
+			return format, nil
+		}
+	}
+	return formatJSON, nil
+}
+
+// responseRows returns the slice of the registered rows, the
+// response itself is a single row otherwise. The rows are
+// encoded one at a time from the slice
+func responseRows(c iris.Context, response interface{}) reflect.Value {
+	rows := c.Values().Get(rowsKey)
+	if rows == nil {
+		return reflect.ValueOf([]interface{}{response})
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(rows))
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return reflect.ValueOf([]interface{}{rows})
+	}
+	return v
+}
+
+// renderYAML converts the JSON representation to YAML,
+// so both formats have the same keys in the same order
+func renderYAML(c iris.Context, response interface{}) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	var node yaml.Node
+	err = yaml.Unmarshal(data, &node)
+	if err != nil {
+		return err
+	}
+	blockStyle(&node)
+
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return err
+	}
+	c.ContentType("application/x-yaml")
+	_, err = c.Write(out)
+	return err
+}
+
+// blockStyle resets the JSON flow style of the YAML nodes
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// renderNDJSON streams the rows as newline-delimited JSON
+func renderNDJSON(c iris.Context, rows reflect.Value) error {
+	c.ContentType("application/x-ndjson")
+
+	enc := json.NewEncoder(c)
+	for i := 0; i < rows.Len(); i++ {
+		err := enc.Encode(rows.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+		if (i+1)%ndjsonFlushRows == 0 {
+			c.ResponseWriter().Flush()
+		}
+	}
+	return nil
+}
+
+// renderCSV writes the flattened rows. Columns are the ones set by
+// ?columns=, all the flattened fields otherwise
+func renderCSV(c iris.Context, rows reflect.Value) error {
+	var (
+		columns []string
+		records []map[string]string
+		seen    = make(map[string]bool)
+	)
+
+	for i := 0; i < rows.Len(); i++ {
+		data, err := json.Marshal(rows.Index(i).Interface())
+		if err != nil {
+			return err
+		}
+		record := flatRecord{values: make(map[string]string)}
+		err = record.flatten(json.NewDecoder(bytes.NewReader(data)), "")
+		if err != nil {
+			return err
+		}
+		records = append(records, record.values)
+		for _, key := range record.keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+
+	header := columns
+	if requested := c.URLParam("columns"); requested != "" {
+		header, columns = nil, nil
+		for _, column := range strings.Split(requested, ",") {
+			column = strings.TrimSpace(column)
+			if column == "" {
+				continue
+			}
+			header = append(header, column)
+			columns = append(columns, csvColumn(column, seen))
+		}
+	}
+
+	c.ContentType("text/csv")
+	w := csv.NewWriter(c)
+	line := make([]string, len(header))
+	for i, column := range header {
+		line[i] = csvCell(column)
+	}
+	err := w.Write(line)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		line := make([]string, len(columns))
+		for i, column := range columns {
+			line[i] = csvCell(record[column])
+		}
+		err = w.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvCell neutralises cells read as formulas by spreadsheets
+// (names, metadata and other tenant values) with a ' prefix.
+// Numbers such as -1 are kept
+func csvCell(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// csvColumn returns the flattened field matching the requested
+// column, case and underscores are ignored
+func csvColumn(column string, fields map[string]bool) string {
+	if fields[column] {
+		return column
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if normalizeColumn(key) == normalizeColumn(column) {
+			return key
+		}
+	}
+	return column
+}
+
+func normalizeColumn(column string) string {
+	return strings.ToLower(strings.Replace(column, "_", "", -1))
+}
+
+// flatRecord is a row flattened to dotted paths in the order
+// of the JSON fields
+type flatRecord struct {
+	keys   []string
+	values map[string]string
+}
+
+func (r *flatRecord) set(key string, value string) {
+	if _, ok := r.values[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.values[key] = value
+}
+
+// flatten reads the next JSON value. Objects are flattened to
+// dotted paths, arrays of scalars are joined with ";" and
+// other arrays are kept as JSON
+func (r *flatRecord) flatten(dec *json.Decoder, prefix string) error {
+	dec.UseNumber()
+
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				path := fmt.Sprint(key)
+				if prefix != "" {
+					path = prefix + "." + path
+				}
+				err = r.flatten(dec, path)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+
+		var items []json.RawMessage
+		for dec.More() {
+			var item json.RawMessage
+			err = dec.Decode(&item)
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		r.set(prefix, joinScalars(items))
+	case nil:
+		r.set(prefix, "")
+	default:
+		r.set(prefix, fmt.Sprint(t))
+	}
+	return nil
+}
+
+// joinScalars joins the array of scalars, the JSON array is
+// returned if it contains objects or arrays
+func joinScalars(items []json.RawMessage) string {
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		var value interface{}
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.UseNumber()
+		if dec.Decode(&value) != nil {
+			return ""
+		}
+		switch v := value.(type) {
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(items)
+			return string(data)
+		case nil:
+			values = append(values, "")
+		default:
+			values = append(values, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(values, ";")
+}
+
+// keyedRow is a report row of a map entry, the key is
+// added as the first field of the value
+type keyedRow struct {
+	name  string
+	key   string
+	value interface{}
+}
+
+func (r keyedRow) MarshalJSON() ([]byte, error) {
+	key, err := json.Marshal(map[string]string{r.name: r.key})
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(r.value)
+	if err != nil {
+		return nil, err
+	}
+	value = bytes.TrimSpace(value)
+	if len(value) < 2 || value[0] != '{' {
+		return json.Marshal(map[string]interface{}{r.name: r.key, "value": r.value})
+	}
+	if string(value) == "{}" {
+		return key, nil
+	}
+	return append(key[:len(key)-1], append([]byte{','}, value[1:]...)...), nil
+}
+
+// keyedRows returns the entries of the map sorted by key
+func keyedRows(name string, entries map[string]interface{}) []keyedRow {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rows := make([]keyedRow, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, keyedRow{name: name, key: key, value: entries[key]})
+	}
+	return rows
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ossia/models"
+
+	"github.com/kataras/iris/v12"
+)
+
+func TestRenderRows(t *testing.T) {
+	useTestConfig(t, &models.Configuration{})
+	flavors := []models.Flavor{
+		{ID: "f1", Name: "small", VCPUs: 1},
+		{ID: "f2", Name: "large", VCPUs: 8},
+	}
+
+	app := iris.New()
+	app.Get("/list", func(c iris.Context) {
+		response := iris.Map{"flavors": flavors}
+		setRows(c, response["flavors"])
+		render(c, response)
+	})
+	app.Get("/object", func(c iris.Context) {
+		render(c, iris.Map{"name": "small", "tags": []string{"a", "b"}})
+	})
+	app.Get("/formulas", func(c iris.Context) {
+		render(c, iris.Map{"name": "=cmd|' /C calc'!A0", "note": "-2+3", "tag": "@SUM(1)", "count": -1, "desc": "\tx"})
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"ndjson rows", "/list?format=ndjson", `{"ID":"f1","Name":"small","RAM":0,"VCPUs":1,"Disk":0,"Swap":0,"RxTxFactor":0,"IsPublic":false,"Ephemeral":0,"PollTime":"0001-01-01T00:00:00Z"}` + "\n" +
+			`{"ID":"f2","Name":"large","RAM":0,"VCPUs":8,"Disk":0,"Swap":0,"RxTxFactor":0,"IsPublic":false,"Ephemeral":0,"PollTime":"0001-01-01T00:00:00Z"}` + "\n"},
+		{"ndjson object", "/object?format=ndjson", `{"name":"small","tags":["a","b"]}` + "\n"},
+		{"csv rows", "/list?format=csv&columns=id,vcpus", "id,vcpus\nf1,1\nf2,8\n"},
+		{"csv object", "/object?format=csv", "name,tags\nsmall,a;b\n"},
+		{"csv formulas", "/formulas?format=csv", "count,desc,name,note,tag\n-1,'\tx,'=cmd|' /C calc'!A0,'-2+3,'@SUM(1)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"os"
+	"ossia/models"
+	"ossia/scheduler"
+	"ossia/utils"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Report sections
+const (
+	sectionSummary          = "summary"
+	sectionTopProjects      = "top_projects"
+	sectionGrowth           = "growth"
+	sectionEmptyHypervisors = "empty_hypervisors"
+	sectionErrorInstances   = "error_instances"
+)
+
+// reportSections are rendered in this order, all of them by default
+var reportSections = []string{sectionSummary, sectionTopProjects, sectionGrowth, sectionEmptyHypervisors, sectionErrorInstances}
+
+// reportFormats maps the report formats to the file extensions
+var reportFormats = map[string]string{
+	"html":     "html",
+	"markdown": "md",
+	"text":     "txt",
+}
+
+// Report defaults
+const (
+	defaultReportFormat = "html"
+	defaultReportTop    = 10
+	defaultGrowthDays   = 7
+)
+
+// reportData is passed to the report templates
+type reportData struct {
+	Name        string
+	Generated   time.Time
+	GrowthDays  int
+	Sections    map[string]bool
+	Total       models.Usage
+	Deployments []deploymentReport
+}
+
+// deploymentReport is the report section data of the deployment
+type deploymentReport struct {
+	Name             string
+	Usage            models.Usage
+	TopProjects      []projectUsage
+	Growth           *reportGrowth
+	EmptyHypervisors []string
+	ErrorInstances   []errorInstance
+}
+
+// projectUsage is the resources allocated to the project instances
+type projectUsage struct {
+	Name      string
+	ID        string
+	Instances int
+	VCPUs     int
+	RAMMB     int
+}
+
+// reportGrowth compares the oldest and the latest snapshots of the period
+type reportGrowth struct {
+	From models.Snapshot
+	To   models.Snapshot
+}
+
+// errorInstance is the instance in ERROR status
+type errorInstance struct {
+	Name       string
+	ID         string
+	Project    string
+	Hypervisor string
+	Updated    time.Time
+}
+
+// withReportDefaults sets the default format, sections and sizes
+func withReportDefaults(r models.Report) models.Report {
+	if r.Format == "" {
+		r.Format = defaultReportFormat
+	}
+	if len(r.Sections) == 0 {
+		r.Sections = reportSections
+	}
+	if r.Top <= 0 {
+		r.Top = defaultReportTop
+	}
+	if r.GrowthDays <= 0 {
+		r.GrowthDays = defaultGrowthDays
+	}
+	return r
+}
+
+// validateReports checks the report definitions
+func validateReports(config *models.Configuration) error {
+	names := make(map[string]bool, len(config.Reports))
+	for _, r := range config.Reports {
+		r = withReportDefaults(r)
+		if r.Name == "" {
+			return fmt.Errorf("reports: name is required")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("reports %s: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+
+		if err := scheduler.ValidateSchedule(r.Schedule); err != nil {
+			return fmt.Errorf("reports %s: %v", r.Name, err)
+		}
+		if _, ok := reportFormats[r.Format]; !ok {
+			return fmt.Errorf("reports %s: unknown format %s", r.Name, r.Format)
+		}
+		for _, section := range r.Sections {
+			if !contains(reportSections, section) {
+				return fmt.Errorf("reports %s: unknown section %s", r.Name, section)
+			}
+		}
+		if len(r.To) == 0 && r.Directory == "" {
+			return fmt.Errorf("reports %s: to or directory is required", r.Name)
+		}
+		if len(r.To) > 0 && config.SMTP.Host == "" {
+			return fmt.Errorf("reports %s: smtp.host is required", r.Name)
+		}
+	}
+	return nil
+}
+
+// scheduleReports registers the report tasks and removes
+// the tasks of the reports removed from the config
+func scheduleReports() {
+	configured := make(map[string]bool, len(Cfg().Reports))
+	for _, r := range Cfg().Reports {
+		name := r.Name
+		configured["report:"+name] = true
+		scheduler.AddTask(scheduler.Task{
+			Name:     "report:" + name,
+			Resource: "reports",
+			Schedule: r.Schedule,
+			Run:      func() error { return runReport(name) },
+		})
+	}
+	for _, t := range scheduler.Tasks() {
+		if t.Resource == "reports" && !configured[t.Name] {
+			scheduler.RemoveTask(t.Name)
+		}
+	}
+}
+
+// runReport renders the named report and delivers it
+func runReport(name string) error {
+	defer utils.TimeTrack(time.Now(), runReport)
+
+	var (
+		report models.Report
+		found  bool
+	)
+	for _, r := range Cfg().Reports {
+		if r.Name == name {
+			report, found = withReportDefaults(r), true
+		}
+	}
+	if !found {
+		return fmt.Errorf("report %s is not configured", name)
+	}
+
+	data := buildReport(report)
+	body, err := renderReport(report.Format, data)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	if report.Directory != "" {
+		file, err := writeReport(report, data.Generated, body)
+		if err != nil {
+			failed = append(failed, err.Error())
+		} else {
+			log.WithFields(log.Fields{
+				"report": name,
+				"file":   file,
+			}).Info("Report written")
+		}
+	}
+	if len(report.To) > 0 {
+		err := mailReport(report, data.Generated, body)
+		if err != nil {
+			failed = append(failed, err.Error())
+		} else {
+			log.WithFields(log.Fields{
+				"report": name,
+				"to":     report.To,
+			}).Info("Report mailed")
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// buildReport collects the data of the report sections
+func buildReport(report models.Report) reportData {
+	data := reportData{
+		Name:       report.Name,
+		Generated:  time.Now(),
+		GrowthDays: report.GrowthDays,
+		Sections:   make(map[string]bool, len(report.Sections)),
+		Total:      models.Usage{InstancesByStatus: make(map[string]int)},
+	}
+	for _, section := range report.Sections {
+		data.Sections[section] = true
+	}
+
+	for _, deployment := range readableDeployments(nil) {
+		if !deploymentRegistered(deployment) {
+			continue
+		}
+		if len(report.Deployments) > 0 && !matchAny(report.Deployments, deployment) {
+			continue
+		}
+
+		d := deploymentReport{
+			Name:  deployment,
+			Usage: getUsage(deployment, nil),
+		}
+		data.Total.Add(d.Usage)
+
+		if data.Sections[sectionTopProjects] {
+			d.TopProjects = topProjects(deployment, report.Top)
+		}
+		if data.Sections[sectionGrowth] {
+			d.Growth = snapshotGrowth(deployment, data.Generated, report.GrowthDays)
+		}
+		if data.Sections[sectionEmptyHypervisors] {
+			for _, h := range listEmptyHypervisors(deployment) {
+				d.EmptyHypervisors = append(d.EmptyHypervisors, h.Hostname)
+			}
+			sort.Strings(d.EmptyHypervisors)
+		}
+		if data.Sections[sectionErrorInstances] {
+			d.ErrorInstances = errorInstances(deployment)
+		}
+		data.Deployments = append(data.Deployments, d)
+	}
+	return data
+}
+
+// topProjects returns the projects allocating most vCPUs, then memory
+func topProjects(deployment string, top int) []projectUsage {
+	flavors := make(map[string]models.Flavor)
+	for _, f := range listFlavors(deployment) {
+		flavors[f.ID] = f
+	}
+
+	usage := make(map[string]*projectUsage)
+	for _, p := range listProjects(deployment) {
+		usage[p.ID] = &projectUsage{Name: p.Name, ID: p.ID}
+	}
+	for _, i := range listInstances(deployment, "") {
+		p, ok := usage[i.ProjectID]
+		if !ok {
+			p = &projectUsage{Name: i.ProjectID, ID: i.ProjectID}
+			usage[i.ProjectID] = p
+		}
+		p.Instances++
+		p.VCPUs += flavors[i.Flavor].VCPUs
+		p.RAMMB += flavors[i.Flavor].RAM
+	}
+
+	projects := make([]projectUsage, 0, len(usage))
+	for _, p := range usage {
+		if p.Instances > 0 {
+			projects = append(projects, *p)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		a, b := projects[i], projects[j]
+		if a.VCPUs != b.VCPUs {
+			return a.VCPUs > b.VCPUs
+		}
+		if a.RAMMB != b.RAMMB {
+			return a.RAMMB > b.RAMMB
+		}
+		return a.Name < b.Name
+	})
+	if len(projects) > top {
+		projects = projects[:top]
+	}
+	return projects
+}
+
+// snapshotGrowth compares the first snapshot of the period with the
+// latest one, nil without two snapshots in the period
+func snapshotGrowth(deployment string, now time.Time, days int) *reportGrowth {
+	since := now.AddDate(0, 0, -days).Format("2006-01-02")
+
+	var period []models.Snapshot
+	for _, s := range listSnapshots(deployment) {
+		if s.ID >= since {
+			period = append(period, s)
+		}
+	}
+	if len(period) < 2 {
+		return nil
+	}
+	sort.Slice(period, func(i, j int) bool { return period[i].ID < period[j].ID })
+	return &reportGrowth{From: period[0], To: period[len(period)-1]}
+}
+
+// errorInstances returns the instances in ERROR status by name
+func errorInstances(deployment string) []errorInstance {
+	projects := make(map[string]string)
+	for _, p := range listProjects(deployment) {
+		projects[p.ID] = p.Name
+	}
+
+	var instances []errorInstance
+	for _, i := range listInstances(deployment, "") {
+		if i.Status != "ERROR" {
+			continue
+		}
+		project := projects[i.ProjectID]
+		if project == "" {
+			project = i.ProjectID
+		}
+		instances = append(instances, errorInstance{
+			Name:       i.Name,
+			ID:         i.ID,
+			Project:    project,
+			Hypervisor: i.Hypervisor,
+			Updated:    i.Updated,
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	return instances
+}
+
+// reportFuncs are the helpers of the report templates
+var reportFuncs = map[string]interface{}{
+	"percent": usagePercent,
+	"delta": func(from int, to int) string {
+		return fmt.Sprintf("%+d", to-from)
+	},
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04")
+	},
+	"gb": func(mb int) string {
+		return fmt.Sprintf("%.1f", float64(mb)/1024)
+	},
+}
+
+// renderReport renders the report in the format
+func renderReport(format string, data reportData) (string, error) {
+	var (
+		body bytes.Buffer
+		err  error
+	)
+	switch format {
+	case "html":
+		t := htmltemplate.Must(htmltemplate.New("html").Funcs(reportFuncs).Parse(htmlReport))
+		err = t.Execute(&body, data)
+	case "markdown":
+		t := template.Must(template.New("markdown").Funcs(reportFuncs).Parse(markdownReport))
+		err = t.Execute(&body, data)
+	default:
+		t := template.Must(template.New("text").Funcs(reportFuncs).Parse(textReport))
+		err = t.Execute(&body, data)
+	}
+	return body.String(), err
+}
+
+// writeReport writes the report to its directory, named
+// after the report and the generation time
+func writeReport(report models.Report, generated time.Time, body string) (string, error) {
+	err := os.MkdirAll(report.Directory, 0755)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(report.Directory, fmt.Sprintf("%s-%s.%s", report.Name, generated.Format("20060102-150405"), reportFormats[report.Format]))
+	return file, ioutil.WriteFile(file, []byte(body), 0644)
+}
+
+// mailReport sends the report to its recipients
+func mailReport(report models.Report, generated time.Time, body string) error {
+	subject := report.Subject
+	if subject == "" {
+		subject = fmt.Sprintf("%s capacity report %s", strings.ToUpper(AppName), report.Name)
+	}
+	subject += " " + generated.Format("2006-01-02")
+
+	contentType := "text/plain"
+	if report.Format == "html" {
+		contentType = "text/html"
+	}
+	return sendMail(report.To, subject, contentType, body)
+}
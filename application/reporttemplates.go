@@ -0,0 +1,241 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+// textReport is the plain text report template
+const textReport = `OSSIA capacity report {{.Name}}
+Generated {{date .Generated}}
+{{- if .Sections.summary}}
+
+SUMMARY
+{{range .Deployments}}
+{{.Name}}: {{.Usage.Instances}} instances, {{.Usage.Projects}} projects, {{.Usage.Hypervisors}} hypervisors
+  vCPUs  {{.Usage.VCPUsUsed}}/{{.Usage.VCPUs}} ({{percent .Usage.VCPUsUsed .Usage.VCPUs}}%)
+  Memory {{gb .Usage.MemoryUsedMB}}/{{gb .Usage.MemoryMB}} GB ({{percent .Usage.MemoryUsedMB .Usage.MemoryMB}}%)
+  Disk   {{.Usage.DiskUsedGB}}/{{.Usage.DiskGB}} GB ({{percent .Usage.DiskUsedGB .Usage.DiskGB}}%)
+{{- end}}
+
+Total: {{.Total.Instances}} instances, vCPUs {{.Total.VCPUsUsed}}/{{.Total.VCPUs}} ({{percent .Total.VCPUsUsed .Total.VCPUs}}%), memory {{gb .Total.MemoryUsedMB}}/{{gb .Total.MemoryMB}} GB ({{percent .Total.MemoryUsedMB .Total.MemoryMB}}%)
+{{- end}}
+{{- if .Sections.top_projects}}
+
+TOP PROJECTS
+{{- range .Deployments}}
+
+{{.Name}}:
+{{- range .TopProjects}}
+  {{printf "%-32s" .Name}} {{printf "%5d" .Instances}} instances {{printf "%6d" .VCPUs}} vCPUs {{printf "%9s" (gb .RAMMB)}} GB
+{{- else}}
+  no instances
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.growth}}
+
+GROWTH (last {{.GrowthDays}} days)
+{{- range .Deployments}}
+
+{{.Name}}:
+{{- with .Growth}} {{.From.ID}} to {{.To.ID}}
+  Instances  {{.To.Instances}} ({{delta .From.Instances .To.Instances}})
+  vCPUs used {{.To.VCPUsUsed}} ({{delta .From.VCPUsUsed .To.VCPUsUsed}})
+  Memory MB  {{.To.MemoryUsedMB}} ({{delta .From.MemoryUsedMB .To.MemoryUsedMB}})
+{{- else}}
+  not enough snapshots
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.empty_hypervisors}}
+
+EMPTY HYPERVISORS
+{{- range .Deployments}}
+
+{{.Name}}: {{len .EmptyHypervisors}}
+{{- range .EmptyHypervisors}}
+  {{.}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.error_instances}}
+
+ERROR INSTANCES
+{{- range .Deployments}}
+
+{{.Name}}: {{len .ErrorInstances}}
+{{- range .ErrorInstances}}
+  {{.Name}} ({{.ID}}) project {{.Project}}, hypervisor {{.Hypervisor}}, updated {{date .Updated}}
+{{- end}}
+{{- end}}
+{{- end}}
+`
+
+// markdownReport is the Markdown report template
+const markdownReport = `# OSSIA capacity report {{.Name}}
+
+Generated {{date .Generated}}
+{{- if .Sections.summary}}
+
+## Summary
+
+| Deployment | Instances | Projects | Hypervisors | vCPUs | vCPU usage | Memory GB | Memory usage | Disk usage |
+|---|---:|---:|---:|---:|---:|---:|---:|---:|
+{{- range .Deployments}}
+| {{.Name}} | {{.Usage.Instances}} | {{.Usage.Projects}} | {{.Usage.Hypervisors}} | {{.Usage.VCPUsUsed}}/{{.Usage.VCPUs}} | {{percent .Usage.VCPUsUsed .Usage.VCPUs}}% | {{gb .Usage.MemoryUsedMB}}/{{gb .Usage.MemoryMB}} | {{percent .Usage.MemoryUsedMB .Usage.MemoryMB}}% | {{percent .Usage.DiskUsedGB .Usage.DiskGB}}% |
+{{- end}}
+| **Total** | {{.Total.Instances}} | {{.Total.Projects}} | {{.Total.Hypervisors}} | {{.Total.VCPUsUsed}}/{{.Total.VCPUs}} | {{percent .Total.VCPUsUsed .Total.VCPUs}}% | {{gb .Total.MemoryUsedMB}}/{{gb .Total.MemoryMB}} | {{percent .Total.MemoryUsedMB .Total.MemoryMB}}% | {{percent .Total.DiskUsedGB .Total.DiskGB}}% |
+{{- end}}
+{{- if .Sections.top_projects}}
+
+## Top projects
+{{- range .Deployments}}
+
+### {{.Name}}
+{{if .TopProjects}}
+| Project | Instances | vCPUs | Memory GB |
+|---|---:|---:|---:|
+{{- range .TopProjects}}
+| {{.Name}} | {{.Instances}} | {{.VCPUs}} | {{gb .RAMMB}} |
+{{- end}}
+{{- else}}
+No instances
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.growth}}
+
+## Growth (last {{.GrowthDays}} days)
+
+| Deployment | Period | Instances | vCPUs used | Memory MB used |
+|---|---|---:|---:|---:|
+{{- range $d := .Deployments}}
+{{- with $d.Growth}}
+| {{$d.Name}} | {{.From.ID}} to {{.To.ID}} | {{.To.Instances}} ({{delta .From.Instances .To.Instances}}) | {{.To.VCPUsUsed}} ({{delta .From.VCPUsUsed .To.VCPUsUsed}}) | {{.To.MemoryUsedMB}} ({{delta .From.MemoryUsedMB .To.MemoryUsedMB}}) |
+{{- else}}
+| {{$d.Name}} | not enough snapshots | | | |
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.empty_hypervisors}}
+
+## Empty hypervisors
+{{- range .Deployments}}
+
+### {{.Name}} ({{len .EmptyHypervisors}})
+{{range .EmptyHypervisors}}
+- {{.}}
+{{- else}}
+None
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.error_instances}}
+
+## ERROR instances
+{{- range .Deployments}}
+
+### {{.Name}} ({{len .ErrorInstances}})
+{{if .ErrorInstances}}
+| Instance | ID | Project | Hypervisor | Updated |
+|---|---|---|---|---|
+{{- range .ErrorInstances}}
+| {{.Name}} | {{.ID}} | {{.Project}} | {{.Hypervisor}} | {{date .Updated}} |
+{{- end}}
+{{- else}}
+None
+{{- end}}
+{{- end}}
+{{- end}}
+`
+
+// htmlReport is the HTML report template, styled inline for mail clients
+const htmlReport = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>OSSIA capacity report {{.Name}}</title>
+</head>
+<body style="font-family: Helvetica, Arial, sans-serif; font-size: 14px; color: #222;">
+<h1 style="font-size: 20px;">OSSIA capacity report {{.Name}}</h1>
+<p style="color: #666;">Generated {{date .Generated}}</p>
+{{- if .Sections.summary}}
+<h2 style="font-size: 16px;">Summary</h2>
+<table cellpadding="4" style="border-collapse: collapse;" border="1">
+<tr><th>Deployment</th><th>Instances</th><th>Projects</th><th>Hypervisors</th><th>vCPUs</th><th>vCPU usage</th><th>Memory GB</th><th>Memory usage</th><th>Disk usage</th></tr>
+{{- range .Deployments}}
+<tr><td>{{.Name}}</td><td align="right">{{.Usage.Instances}}</td><td align="right">{{.Usage.Projects}}</td><td align="right">{{.Usage.Hypervisors}}</td><td align="right">{{.Usage.VCPUsUsed}}/{{.Usage.VCPUs}}</td><td align="right">{{percent .Usage.VCPUsUsed .Usage.VCPUs}}%</td><td align="right">{{gb .Usage.MemoryUsedMB}}/{{gb .Usage.MemoryMB}}</td><td align="right">{{percent .Usage.MemoryUsedMB .Usage.MemoryMB}}%</td><td align="right">{{percent .Usage.DiskUsedGB .Usage.DiskGB}}%</td></tr>
+{{- end}}
+<tr><th align="left">Total</th><th align="right">{{.Total.Instances}}</th><th align="right">{{.Total.Projects}}</th><th align="right">{{.Total.Hypervisors}}</th><th align="right">{{.Total.VCPUsUsed}}/{{.Total.VCPUs}}</th><th align="right">{{percent .Total.VCPUsUsed .Total.VCPUs}}%</th><th align="right">{{gb .Total.MemoryUsedMB}}/{{gb .Total.MemoryMB}}</th><th align="right">{{percent .Total.MemoryUsedMB .Total.MemoryMB}}%</th><th align="right">{{percent .Total.DiskUsedGB .Total.DiskGB}}%</th></tr>
+</table>
+{{- end}}
+{{- if .Sections.top_projects}}
+<h2 style="font-size: 16px;">Top projects</h2>
+{{- range .Deployments}}
+<h3 style="font-size: 14px;">{{.Name}}</h3>
+{{- if .TopProjects}}
+<table cellpadding="4" style="border-collapse: collapse;" border="1">
+<tr><th>Project</th><th>Instances</th><th>vCPUs</th><th>Memory GB</th></tr>
+{{- range .TopProjects}}
+<tr><td>{{.Name}}</td><td align="right">{{.Instances}}</td><td align="right">{{.VCPUs}}</td><td align="right">{{gb .RAMMB}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No instances</p>
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.growth}}
+<h2 style="font-size: 16px;">Growth (last {{.GrowthDays}} days)</h2>
+<table cellpadding="4" style="border-collapse: collapse;" border="1">
+<tr><th>Deployment</th><th>Period</th><th>Instances</th><th>vCPUs used</th><th>Memory MB used</th></tr>
+{{- range $d := .Deployments}}
+{{- with $d.Growth}}
+<tr><td>{{$d.Name}}</td><td>{{.From.ID}} to {{.To.ID}}</td><td align="right">{{.To.Instances}} ({{delta .From.Instances .To.Instances}})</td><td align="right">{{.To.VCPUsUsed}} ({{delta .From.VCPUsUsed .To.VCPUsUsed}})</td><td align="right">{{.To.MemoryUsedMB}} ({{delta .From.MemoryUsedMB .To.MemoryUsedMB}})</td></tr>
+{{- else}}
+<tr><td>{{$d.Name}}</td><td colspan="4">not enough snapshots</td></tr>
+{{- end}}
+{{- end}}
+</table>
+{{- end}}
+{{- if .Sections.empty_hypervisors}}
+<h2 style="font-size: 16px;">Empty hypervisors</h2>
+{{- range .Deployments}}
+<h3 style="font-size: 14px;">{{.Name}} ({{len .EmptyHypervisors}})</h3>
+{{- if .EmptyHypervisors}}
+<ul>
+{{- range .EmptyHypervisors}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- else}}
+<p>None</p>
+{{- end}}
+{{- end}}
+{{- end}}
+{{- if .Sections.error_instances}}
+<h2 style="font-size: 16px;">ERROR instances</h2>
+{{- range .Deployments}}
+<h3 style="font-size: 14px;">{{.Name}} ({{len .ErrorInstances}})</h3>
+{{- if .ErrorInstances}}
+<table cellpadding="4" style="border-collapse: collapse;" border="1">
+<tr><th>Instance</th><th>ID</th><th>Project</th><th>Hypervisor</th><th>Updated</th></tr>
+{{- range .ErrorInstances}}
+<tr><td>{{.Name}}</td><td>{{.ID}}</td><td>{{.Project}}</td><td>{{.Hypervisor}}</td><td>{{date .Updated}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>None</p>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`
@@ -0,0 +1,437 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"ossia/models"
+	"ossia/utils"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+)
+
+// searchTypes maps the collectors to the resource types of
+// search hits. The type is also the path of the detail endpoint
+var searchTypes = map[string]string{
+	"instances":   "instance",
+	"images":      "image",
+	"flavors":     "flavor",
+	"projects":    "project",
+	"aggregates":  "aggregate",
+	"hypervisors": "hypervisor",
+}
+
+// searchTerm is an indexed value of the object. Names are
+// matched by prefix, other values exactly
+type searchTerm struct {
+	field  string
+	value  string
+	prefix bool
+}
+
+// searchDoc is an indexed Inventory object
+type searchDoc struct {
+	hit   models.SearchHit
+	terms []searchTerm
+}
+
+// searchRef references the matching term of the object
+type searchRef struct {
+	key  string
+	norm string
+	term searchTerm
+}
+
+// searchSegment indexes the objects of a collector in a deployment.
+// Segments are replaced, never modified once built
+type searchSegment struct {
+	docs  map[string]searchDoc
+	exact map[string][]searchRef
+	names []searchRef
+}
+
+// searchIndex is the secondary index of all the deployments,
+// maintained by reconcile
+var searchIndex = struct {
+	sync.RWMutex
+	segments map[string]map[string]*searchSegment
+}{segments: make(map[string]map[string]*searchSegment)}
+
+// BuildSearchIndex indexes the Inventory of all the deployments,
+// so the first searches after a restart do not load it
+func BuildSearchIndex() {
+	defer utils.TimeTrack(time.Now(), BuildSearchIndex)
+
+	for _, deployment := range listDeployments() {
+		for _, c := range collectors {
+			if _, ok := searchTypes[c.Name()]; ok {
+				getSearchSegment(deployment, c)
+			}
+		}
+	}
+}
+
+// indexResources updates the search index with the reconciled result
+func indexResources(deployment string, c Collector, result FetchResult) {
+	typ, ok := searchTypes[c.Name()]
+	if !ok {
+		return
+	}
+
+	docs := make(map[string]searchDoc)
+	if !result.Complete {
+		for key, doc := range getSearchSegment(deployment, c).docs {
+			docs[key] = doc
+		}
+		for _, r := range result.Deleted {
+			delete(docs, r.Key())
+		}
+	}
+	for _, r := range result.Resources {
+		docs[r.Key()] = newSearchDoc(deployment, typ, r)
+	}
+	setSearchSegment(deployment, c, newSearchSegment(docs))
+}
+
+// dropSearchIndex removes the deployment from the search index
+func dropSearchIndex(deployment string) {
+	searchIndex.Lock()
+	defer searchIndex.Unlock()
+
+	delete(searchIndex.segments, deployment)
+}
+
+// getSearchSegment returns the index of the collector objects,
+// loading them from the Inventory if not indexed yet
+func getSearchSegment(deployment string, c Collector) *searchSegment {
+	searchIndex.RLock()
+	segment := searchIndex.segments[deployment][c.Name()]
+	searchIndex.RUnlock()
+	if segment != nil {
+		return segment
+	}
+
+	inventory := c.Model()
+	err := DB.From(deployment).All(inventory)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"task":       c.Name(),
+		}).Error(err)
+	}
+
+	docs := make(map[string]searchDoc)
+	items := reflect.ValueOf(inventory).Elem()
+	for i := 0; i < items.Len(); i++ {
+		r := items.Index(i).Addr().Interface().(models.Resource)
+		docs[r.Key()] = newSearchDoc(deployment, searchTypes[c.Name()], r)
+	}
+	return addSearchSegment(deployment, c, newSearchSegment(docs))
+}
+
+// addSearchSegment stores the segment loaded from the Inventory unless
+// a poll indexed the objects meanwhile, its segment is returned instead
+func addSearchSegment(deployment string, c Collector, segment *searchSegment) *searchSegment {
+	searchIndex.Lock()
+	defer searchIndex.Unlock()
+
+	if current := searchIndex.segments[deployment][c.Name()]; current != nil {
+		return current
+	}
+	if searchIndex.segments[deployment] == nil {
+		searchIndex.segments[deployment] = make(map[string]*searchSegment)
+	}
+	searchIndex.segments[deployment][c.Name()] = segment
+	return segment
+}
+
+func setSearchSegment(deployment string, c Collector, segment *searchSegment) {
+	searchIndex.Lock()
+	defer searchIndex.Unlock()
+
+	if searchIndex.segments[deployment] == nil {
+		searchIndex.segments[deployment] = make(map[string]*searchSegment)
+	}
+	searchIndex.segments[deployment][c.Name()] = segment
+}
+
+// newSearchSegment indexes the terms of the objects
+func newSearchSegment(docs map[string]searchDoc) *searchSegment {
+	segment := &searchSegment{
+		docs:  docs,
+		exact: make(map[string][]searchRef),
+	}
+	for key, doc := range docs {
+		for _, term := range doc.terms {
+			ref := searchRef{key: key, norm: normalizeSearch(term.value), term: term}
+			if term.prefix {
+				segment.names = append(segment.names, ref)
+			} else {
+				segment.exact[ref.norm] = append(segment.exact[ref.norm], ref)
+			}
+		}
+	}
+	for _, refs := range segment.exact {
+		sort.Slice(refs, func(i, j int) bool { return refs[i].key < refs[j].key })
+	}
+	sort.Slice(segment.names, func(i, j int) bool {
+		if segment.names[i].norm != segment.names[j].norm {
+			return segment.names[i].norm < segment.names[j].norm
+		}
+		return segment.names[i].key < segment.names[j].key
+	})
+	return segment
+}
+
+// newSearchDoc extracts the searchable values of the object
+func newSearchDoc(deployment string, typ string, r models.Resource) searchDoc {
+	doc := searchDoc{
+		hit: models.SearchHit{
+			Type:       typ,
+			Deployment: deployment,
+			ID:         r.Key(),
+		},
+	}
+	doc.add("id", r.Key(), false)
+
+	switch v := r.(type) {
+	case *models.Instance:
+		doc.hit.Name = v.Name
+		doc.hit.ProjectID = v.ProjectID
+		doc.add("name", v.Name, true)
+		doc.add("fixed_ipv4", v.FixedIPv4, false)
+		doc.add("floating_ipv4", v.FloatingIPv4, false)
+		doc.add("fixed_ipv6", v.FixedIPv6, false)
+		doc.add("floating_ipv6", v.FloatingIPv6, false)
+		for _, network := range v.Addresses {
+			for _, nic := range network.InstanceNICs {
+				doc.add("fixed_ipv4", nic.FixedIPv4, false)
+				doc.add("floating_ipv4", nic.FloatingIPv4, false)
+				doc.add("fixed_ipv6", nic.FixedIPv6, false)
+				doc.add("floating_ipv6", nic.FloatingIPv6, false)
+				doc.add("mac", nic.MAC, false)
+			}
+		}
+		for key, value := range v.Metadata {
+			doc.add("metadata."+key, value, false)
+		}
+	case *models.Image:
+		doc.hit.Name = v.Name
+		doc.add("name", v.Name, true)
+		for key, value := range v.Metadata {
+			switch value.(type) {
+			case string, float64, bool:
+				doc.add("metadata."+key, fmt.Sprint(value), false)
+			}
+		}
+	case *models.Flavor:
+		doc.hit.Name = v.Name
+		doc.add("name", v.Name, true)
+	case *models.Project:
+		doc.hit.Name = v.Name
+		doc.hit.ProjectID = v.ID
+		doc.add("name", v.Name, true)
+	case *models.Aggregate:
+		doc.hit.Name = v.Name
+		doc.add("name", v.Name, true)
+		for key, value := range v.Metadata {
+			doc.add("metadata."+key, value, false)
+		}
+	case *models.Hypervisor:
+		doc.hit.Name = v.Hostname
+		doc.add("hostname", v.Hostname, true)
+		doc.add("fqdn", v.FQDN, true)
+		doc.add("host_ip", v.HostIP, false)
+	}
+
+	// links use the ID, names are not unique
+	doc.hit.Link = fmt.Sprintf("/v1/deployment/%s/%s/%s",
+		url.PathEscape(deployment), typ, url.PathEscape(doc.hit.ID))
+	return doc
+}
+
+// add indexes the non-empty value once per field
+func (d *searchDoc) add(field string, value string, prefix bool) {
+	if value == "" {
+		return
+	}
+	for _, term := range d.terms {
+		if term.field == field && term.value == value {
+			return
+		}
+	}
+	d.terms = append(d.terms, searchTerm{field: field, value: value, prefix: prefix})
+}
+
+// normalizeSearch returns the canonical form of IP and MAC
+// addresses, other values are matched case-insensitively
+func normalizeSearch(value string) string {
+	value = strings.TrimSpace(value)
+	if ip := net.ParseIP(strings.Trim(value, "[]")); ip != nil {
+		return ip.String()
+	}
+	if strings.ContainsAny(value, ":-.") {
+		if mac, err := net.ParseMAC(value); err == nil {
+			return mac.String()
+		}
+	}
+	return strings.ToLower(value)
+}
+
+// searchOptions restricts the search
+type searchOptions struct {
+	deployments []string
+	types       map[string]bool
+	limit       int
+	allowed     func(hit models.SearchHit) bool
+}
+
+// searchInventory returns the objects matching the query exactly,
+// followed by the objects whose name starts with the query.
+// truncated is set if there are more hits than the limit
+func searchInventory(query string, opts searchOptions) (hits []models.SearchHit, truncated bool) {
+	defer utils.TimeTrack(time.Now(), searchInventory)
+
+	norm := normalizeSearch(query)
+	seen := make(map[string]bool)
+	var exact, prefix []models.SearchHit
+
+	collect := func(list *[]models.SearchHit, segment *searchSegment, ref searchRef) bool {
+		doc := segment.docs[ref.key]
+		id := doc.hit.Deployment + "/" + doc.hit.Type + "/" + ref.key
+		if seen[id] || !opts.allowed(doc.hit) {
+			return true
+		}
+		if len(exact)+len(prefix) >= opts.limit {
+			truncated = true
+			return false
+		}
+		seen[id] = true
+		hit := doc.hit
+		hit.Field = ref.term.field
+		hit.Value = ref.term.value
+		*list = append(*list, hit)
+		return true
+	}
+
+	// Exact matches go first, prefix matches fill the rest
+	for _, pass := range []bool{false, true} {
+		for _, deployment := range opts.deployments {
+			for _, c := range collectors {
+				typ, ok := searchTypes[c.Name()]
+				if !ok || (len(opts.types) > 0 && !opts.types[typ]) {
+					continue
+				}
+				segment := getSearchSegment(deployment, c)
+
+				if !pass {
+					for _, ref := range segment.exact[norm] {
+						if !collect(&exact, segment, ref) {
+							return append(exact, prefix...), truncated
+						}
+					}
+					continue
+				}
+
+				i := sort.Search(len(segment.names), func(i int) bool {
+					return segment.names[i].norm >= norm
+				})
+				for ; i < len(segment.names) && strings.HasPrefix(segment.names[i].norm, norm); i++ {
+					if !collect(&prefix, segment, segment.names[i]) {
+						return append(exact, prefix...), truncated
+					}
+				}
+			}
+		}
+	}
+	return append(exact, prefix...), truncated
+}
+
+// newSearchOptions parses the query and the options of
+// the search request
+func newSearchOptions(c iris.Context) (string, searchOptions, error) {
+	opts := searchOptions{
+		types: make(map[string]bool),
+		limit: 100,
+	}
+
+	query := strings.TrimSpace(c.URLParam("q"))
+	if query == "" {
+		return query, opts, errors.New("missing query q")
+	}
+
+	if limit := c.URLParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, opts, fmt.Errorf("invalid limit %s", limit)
+		}
+		if n > maxListLimit {
+			n = maxListLimit
+		}
+		opts.limit = n
+	}
+
+	known := make(map[string]bool, len(searchTypes))
+	for _, typ := range searchTypes {
+		known[typ] = true
+	}
+	for _, typ := range strings.Split(c.URLParam("type"), ",") {
+		typ = strings.TrimSpace(typ)
+		if typ == "" {
+			continue
+		}
+		if !known[typ] {
+			return query, opts, fmt.Errorf("unknown type %s", typ)
+		}
+		opts.types[typ] = true
+	}
+
+	selected := make(map[string]bool)
+	for _, d := range strings.Split(c.URLParam("deployment"), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			selected[d] = true
+		}
+	}
+
+	access := getAccess(c)
+	for _, d := range listDeployments() {
+		if (len(selected) == 0 || selected[d]) && access.canRead(d) {
+			opts.deployments = append(opts.deployments, d)
+		}
+	}
+	sort.Strings(opts.deployments)
+
+	// Instances and projects are checked against the allowed projects
+	projects := make(map[string]map[string]bool)
+	opts.allowed = func(hit models.SearchHit) bool {
+		if hit.Type != "instance" && hit.Type != "project" {
+			return true
+		}
+		ids, ok := projects[hit.Deployment]
+		if !ok {
+			ids = access.projectIDs(hit.Deployment)
+			projects[hit.Deployment] = ids
+		}
+		return ids == nil || ids[hit.ProjectID]
+	}
+	return query, opts, nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"testing"
+	"time"
+
+	"ossia/models"
+)
+
+func TestSearchSegmentLoad(t *testing.T) {
+	openTestDB(t)
+	defer dropSearchIndex("lab")
+
+	c := &flavorsCollector{}
+	err := DB.From("lab").Save(&models.Flavor{ID: "f1", Name: "small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := getSearchSegment("lab", c)
+	if len(loaded.docs) != 1 {
+		t.Fatalf("loaded %d objects, want 1", len(loaded.docs))
+	}
+	if got := getSearchSegment("lab", c); got != loaded {
+		t.Error("getSearchSegment() loaded the objects again")
+	}
+
+	// a poll indexes the objects while a stale segment is loaded
+	indexResources("lab", c, FetchResult{
+		Complete: true,
+		PolledAt: time.Now(),
+		Resources: []models.Resource{
+			&models.Flavor{ID: "f1", Name: "small"},
+			&models.Flavor{ID: "f2", Name: "large"},
+		},
+	})
+	if got := addSearchSegment("lab", c, loaded); len(got.docs) != 2 {
+		t.Errorf("stale segment replaced the indexed one, %d objects", len(got.docs))
+	}
+	if got := getSearchSegment("lab", c); len(got.docs) != 2 {
+		t.Errorf("getSearchSegment() = %d objects, want 2", len(got.docs))
+	}
+}
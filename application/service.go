@@ -104,6 +104,7 @@ func (s *Service) Run() {
 // Engine is reponsible for routing and middleware
 func (s *Service) Engine() *iris.Application {
 
+	config := Cfg()
 	engine := iris.New()
 
 	engine.Configure()
@@ -111,51 +112,128 @@ func (s *Service) Engine() *iris.Application {
 	for _, ware := range middleware.Provider {
 		engine.UseGlobal(ware)
 	}
+	engine.UseGlobal(middleware.Auth(middleware.AuthOptions{
+		Authenticators: authenticators,
+		Public:         publicRequest,
+		Scope:          requiredScope,
+		Error:          apiError,
+	}))
 
 	//metrics := prometheusMiddleware.New(AppName, 300, 1200, 5000)
 	crs := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"}, // allows everything, use that to change the hosts.
-		AllowCredentials: true,
+		AllowedOrigins:   config.CORS.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "HEAD"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-API-Key", "X-Auth-Token", "If-None-Match", "If-Modified-Since", "Last-Event-ID"},
+		ExposedHeaders:   []string{"ETag", "Last-Modified"},
+		AllowCredentials: config.CORS.AllowCredentials,
 	})
 
 	engine.Use(crs)
+	// Routes answer CORS preflight requests
+	engine.AllowMethods(iris.MethodOptions)
 	//engine.Use(metrics.ServeHTTP)
 
-	engine.HandleDir("/", AssetFile())
+	if config.Auth.SwaggerUI {
+		engine.HandleDir("/", AssetFile())
+		engine.Get("/", apiReference)
+	}
 	v1 := engine.Party("/v1")
+	v1.Use(authorize)
 
 	// Application Handlers
-	engine.Get("/", apiReference)
 	//engine.Get("/metrics", iris.FromStd(promhttp.Handler()))
 	engine.OnErrorCode(iris.StatusNotFound, notFoundHandler)
 	v1.Get("/status", statusHandler)
+	v1.Get("/status/details", statusDetailsHandler)
 
 	// OpenStack Resource View (all resources per deployment)
 	v1.Get("/deployments", deploymentsHandler)
-	v1.Get("/deployment/{deployment:string}/projects", projectsHandler)
-	v1.Get("/deployment/{deployment:string}/images", imagesHandler)
-	v1.Get("/deployment/{deployment:string}/flavors", flavorsHandler)
-	v1.Get("/deployment/{deployment:string}/aggregates", aggregatesHandler)
-	v1.Get("/deployment/{deployment:string}/hypervisors", hypervisorsHandler)
-	v1.Get("/deployment/{deployment:string}/hypervisors/empty", hypervisorsEmptyHandler)
-	v1.Get("/deployment/{deployment:string}/instances", instancesHandler)
-	v1.Get("/deployment/{deployment:string}/project/{project:string}/instances", projectInstancesHandler)
-	v1.Get("/deployment/{deployment:string}/instances/clusters", clustersHandler)
+	v1.Get("/search", conditional, searchHandler)
+	v1.Get("/events", eventsHandler)
+	v1.Get("/alerts", alertsHandler)
+	v1.Get("/global/summary", conditional, globalSummaryHandler)
+	v1.Get("/global/snapshots", conditional, globalSnapshotsHandler)
+	v1.Get("/deployment/{deployment:string}/projects", conditional, projectsHandler)
+	v1.Get("/deployment/{deployment:string}/images", conditional, imagesHandler)
+	v1.Get("/deployment/{deployment:string}/flavors", conditional, flavorsHandler)
+	v1.Get("/deployment/{deployment:string}/aggregates", conditional, aggregatesHandler)
+	v1.Get("/deployment/{deployment:string}/hypervisors", conditional, hypervisorsHandler)
+	v1.Get("/deployment/{deployment:string}/hypervisors/empty", conditional, hypervisorsEmptyHandler)
+	v1.Get("/deployment/{deployment:string}/instances", conditional, instancesHandler)
+	v1.Get("/deployment/{deployment:string}/project/{project:string}/instances", conditional, projectInstancesHandler)
+	v1.Get("/deployment/{deployment:string}/instances/clusters", conditional, clustersHandler)
 
 	// OpenStack Resource (by resource name)
 	v1.Get("/deployment/{deployment:string}", deploymentHandler)
-	v1.Get("/deployment/{deployment:string}/snapshots", deploymentSnapshotHandler)
-	v1.Get("/deployment/{deployment:string}/project/{project:string}", projectHandler)
-	v1.Get("/deployment/{deployment:string}/image/{image:string}", imageHandler)
-	v1.Get("/deployment/{deployment:string}/flavor/{flavor:string}", flavorHandler)
-	v1.Get("/deployment/{deployment:string}/aggregate/{aggregate:string}", aggregateHandler)
-	v1.Get("/deployment/{deployment:string}/instance/{instance:string}", instanceHandler)
-	v1.Get("/deployment/{deployment:string}/instances/cluster/{cluster:string}", clusterHandler)
-	v1.Get("/deployment/{deployment:string}/instances/filter/{name:string}", filterInstancesHandler)
-	v1.Get("/deployment/{deployment:string}/hypervisor/{hostname:string}", hypervisorHandler)
+	v1.Get("/deployment/{deployment:string}/snapshots", conditional, deploymentSnapshotHandler)
+	v1.Get("/deployment/{deployment:string}/project/{project:string}", conditional, projectHandler)
+	v1.Get("/deployment/{deployment:string}/image/{image:string}", conditional, imageHandler)
+	v1.Get("/deployment/{deployment:string}/flavor/{flavor:string}", conditional, flavorHandler)
+	v1.Get("/deployment/{deployment:string}/aggregate/{aggregate:string}", conditional, aggregateHandler)
+	v1.Get("/deployment/{deployment:string}/instance/{instance:string}", conditional, instanceHandler)
+	v1.Get("/deployment/{deployment:string}/instances/cluster/{cluster:string}", conditional, clusterHandler)
+	v1.Get("/deployment/{deployment:string}/instances/filter/{name:string}", conditional, filterInstancesHandler)
+	v1.Get("/deployment/{deployment:string}/hypervisor/{hostname:string}", conditional, hypervisorHandler)
 
 	// Resource Update (POST)
 	v1.Post("/deployment/{deployment:string}/update", deploymentUpdateHandler)
+	v1.Post("/deployment/{deployment:string}/update/{resource:string}", resourceUpdateHandler)
+	v1.Post("/deployment/{deployment:string}/update/{resource:string}/{object:string}", objectUpdateHandler)
+
+	// Update Jobs
+	v1.Get("/jobs/{id:string}", jobHandler)
+	v1.Get("/deployment/{deployment:string}/jobs", deploymentJobsHandler)
+
+	// Scheduler
+	v1.Get("/scheduler/tasks", schedulerTasksHandler)
+	v1.Get("/scheduler/tasks/{name:string}", schedulerTaskHandler)
+	v1.Post("/scheduler/tasks/{name:string}/{action:string}", schedulerTaskActionHandler)
+
+	// Deployment Management (admin scope required)
+	admin := v1.Party("/admin")
+	admin.Get("/deployments", adminDeploymentsHandler)
+	admin.Post("/deployments", adminRegisterDeploymentHandler)
+	admin.Get("/deployments/{name:string}", adminDeploymentHandler)
+	admin.Put("/deployments/{name:string}", adminUpdateDeploymentHandler)
+	admin.Delete("/deployments/{name:string}", adminDeleteDeploymentHandler)
+	admin.Post("/deployments/{name:string}/{action:string}", adminDeploymentActionHandler)
+
+	// Webhooks (admin scope required)
+	admin.Get("/webhooks", adminWebhooksHandler)
+	admin.Post("/webhooks", adminRegisterWebhookHandler)
+	admin.Get("/webhooks/{name:string}", adminWebhookHandler)
+	admin.Delete("/webhooks/{name:string}", adminDeleteWebhookHandler)
+	admin.Post("/webhooks/{name:string}/ping", adminPingWebhookHandler)
+	admin.Get("/deadletters", adminDeadLettersHandler)
+	admin.Post("/deadletters/{id:string}/retry", adminRetryDeadLetterHandler)
+	admin.Delete("/deadletters/{id:string}", adminDeleteDeadLetterHandler)
+
+	// v2 API: {data, meta, links} envelopes and problem details
+	v2 := engine.Party("/v2")
+	v2.Use(authorize)
+	v2.Get("/status", v2StatusHandler)
+	v2.Get("/status/details", v2StatusDetailsHandler)
+	v2.Get("/search", conditional, v2SearchHandler)
+	v2.Get("/global/summary", conditional, v2GlobalSummaryHandler)
+	v2.Get("/global/snapshots", conditional, v2GlobalSnapshotsHandler)
+	v2.Get("/deployments", v2DeploymentsHandler)
+	v2.Get("/deployments/{deployment:string}", v2DeploymentHandler)
+	v2.Get("/deployments/{deployment:string}/snapshots", conditional, v2SnapshotsHandler)
+	v2.Get("/deployments/{deployment:string}/jobs", v2JobsHandler)
+	v2.Get("/deployments/{deployment:string}/clusters", conditional, v2ClustersHandler)
+	v2.Get("/deployments/{deployment:string}/clusters/{cluster:string}", conditional, v2ClusterHandler)
+	// hypervisors/empty and projects/{name}/instances are dispatched by the
+	// resource handlers, static segments would shadow the {resource} routes
+	v2.Get("/deployments/{deployment:string}/{resource:string}", conditional, v2ResourcesHandler)
+	v2.Get("/deployments/{deployment:string}/{resource:string}/{name:string}", conditional, v2ResourceHandler)
+	v2.Get("/deployments/{deployment:string}/{resource:string}/{name:string}/instances", conditional, v2ProjectInstancesHandler)
+	v2.Post("/deployments/{deployment:string}/update", v2UpdateHandler)
+	v2.Post("/deployments/{deployment:string}/update/{resource:string}", v2UpdateHandler)
+	v2.Post("/deployments/{deployment:string}/update/{resource:string}/{object:string}", v2UpdateHandler)
+	v2.Get("/jobs/{id:string}", v2JobHandler)
+	v2.Get("/scheduler/tasks", v2TasksHandler)
+	v2.Get("/scheduler/tasks/{name:string}", v2TaskHandler)
+	v2.Post("/scheduler/tasks/{name:string}/{action:string}", v2TaskActionHandler)
 
 	return engine
 
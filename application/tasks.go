@@ -12,17 +12,45 @@ governing permissions and limitations under the License.
 package application
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"ossia/models"
 	"ossia/scheduler"
 	"ossia/utils"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/kataras/iris/v12"
 	log "github.com/sirupsen/logrus"
 )
 
+// StartDeployments runs UpdateInventory for the deployments,
+// spreading the first polls by the startup stagger
+func StartDeployments(deployments []string) {
+	sort.Strings(deployments)
+
+	stagger := Cfg().APIClient.StartupStagger
+	for i, deployment := range deployments {
+
+		delay := time.Duration(i) * stagger
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+			"delay":      delay,
+		}).Info("Registered new deployment")
+
+		go func(deployment string, delay time.Duration) {
+			time.Sleep(delay)
+			UpdateInventory(deployment, Cfg().PollInterval)
+		}(deployment, delay)
+
+	}
+}
+
 // UpdateInventory executes initial API calls to
 // OpenStack API to prepopulate the Inventory Database
 // In addition, it also schedules periodic tasks based on
@@ -30,71 +58,160 @@ import (
 func UpdateInventory(deployment string, pollinterval models.PollInterval) {
 	defer utils.TimeTrack(time.Now(), UpdateInventory)
 
-	updateProjects(deployment)
-	updateAggregates(deployment)
-	updateHypervisors(deployment)
-	updateImages(deployment)
-	updateFlavors(deployment)
-	updateInstances(deployment)
+	job, _ := submitJob(deployment, nil)
+	waitJob(job.ID)
+
+	// The deployment may be removed by a config reload meanwhile
+	if !deploymentRegistered(deployment) {
+		return
+	}
 	usageSnapshot(deployment)
-	dbCleanup()
-
-	// Need to re-work this awful code
-	scheduler.AddTask(
-		fmt.Sprintf("@every %s", pollinterval.Projects),
-		func() { updateProjects(deployment) },
-		log.Fields{"task": "projects", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		fmt.Sprintf("@every %s", pollinterval.Aggregates),
-		func() { updateAggregates(deployment) },
-		log.Fields{"task": "aggregates", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		fmt.Sprintf("@every %s", pollinterval.Hypervisors),
-		func() { updateHypervisors(deployment) },
-		log.Fields{"task": "hypervisors", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		fmt.Sprintf("@every %s", pollinterval.Images),
-		func() { updateImages(deployment) },
-		log.Fields{"task": "images", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		fmt.Sprintf("@every %s", pollinterval.Flavors),
-		func() { updateFlavors(deployment) },
-		log.Fields{"task": "flavors", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		fmt.Sprintf("@every %s", pollinterval.Instances),
-		func() { updateInstances(deployment) },
-		log.Fields{"task": "instances", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		fmt.Sprintf("@every 24h"),
-		func() { usageSnapshot(deployment) },
-		log.Fields{"task": "usageSnapshot", "deployment": deployment},
-	)
-	scheduler.AddTask(
-		"@every 24h",
-		func() { dbCleanup() },
-		log.Fields{"task": "dbCleanup"},
-	)
-
-}
-
-// updateDeployment executes initial API calls to
-// OpenStack API to fetch information about resources
-func updateDeployment(deployment string) {
-	defer utils.TimeTrack(time.Now(), updateDeployment)
-
-	updateProjects(deployment)
-	updateHypervisors(deployment)
-	updateImages(deployment)
-	updateFlavors(deployment)
-	updateInstances(deployment)
-	updateAggregates(deployment)
-	dbCleanup()
+
+	scheduleInventory(deployment, pollinterval)
+}
+
+// scheduleInventory registers periodic tasks of the deployment.
+// Tasks scheduled before are replaced
+func scheduleInventory(deployment string, pollinterval models.PollInterval) {
+	for _, c := range Collectors() {
+		c := c
+		scheduler.AddTask(scheduler.Task{
+			Name:       taskName(deployment, c.Name()),
+			Deployment: deployment,
+			Resource:   c.Name(),
+			Schedule:   c.Schedule(pollinterval),
+			Run:        func() error { return runCollector(context.Background(), c, deployment) },
+		})
+	}
+	scheduler.AddTask(scheduler.Task{
+		Name:       taskName(deployment, "usageSnapshot"),
+		Deployment: deployment,
+		Resource:   "snapshots",
+		Schedule:   "@every 24h",
+		Run:        func() error { usageSnapshot(deployment); return nil },
+	})
+}
+
+// ScheduleGlobalTasks registers the tasks not bound to a deployment.
+// It is run again on config reloads, adding, rescheduling and
+// removing the tasks of the alerts and reports
+func ScheduleGlobalTasks() {
+	scheduler.AddTask(scheduler.Task{
+		Name:     "dbCleanup",
+		Resource: "inventory",
+		Schedule: "@every 24h",
+		Run:      func() error { dbCleanup(); return nil },
+	})
+	scheduleAlerts()
+	scheduleReports()
+}
+
+// taskName returns the scheduler task name of the deployment
+func taskName(deployment string, task string) string {
+	return fmt.Sprintf("%s:%s", deployment, task)
+}
+
+// refreshDeployment runs the collectors of the deployment in
+// parallel, each one as soon as its dependencies are finished.
+// Only the named collectors are run if any. The refresh stops
+// waiting once the cycle deadline is exceeded. Every collector
+// result is passed to progress
+func refreshDeployment(deployment string, names []string, progress func(models.CollectorResult)) {
+	defer utils.TimeTrack(time.Now(), refreshDeployment)
+
+	ctx, cancel := cycleContext()
+	defer cancel()
+
+	var selected []Collector
+	for _, c := range Collectors() {
+		if len(names) == 0 || contains(names, c.Name()) {
+			selected = append(selected, c)
+		}
+	}
+
+	done := make(map[string]chan struct{}, len(selected))
+	for _, c := range selected {
+		done[c.Name()] = make(chan struct{})
+	}
+
+	for _, c := range selected {
+		go func(c Collector) {
+			defer close(done[c.Name()])
+
+			for _, dep := range c.Dependencies() {
+				if _, ok := done[dep]; !ok {
+					continue
+				}
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+				}
+			}
+
+			result := models.CollectorResult{
+				Name:    c.Name(),
+				Status:  models.JobSkipped,
+				Started: time.Now(),
+			}
+			if ctx.Err() != nil {
+				log.WithFields(log.Fields{
+					"deployment": deployment,
+					"task":       c.Name(),
+				}).Warn("Skipping update. Refresh deadline exceeded")
+				result.Error = errDeadlineExceeded.Error()
+				progress(result)
+				return
+			}
+
+			result.Status = models.JobRunning
+			progress(result)
+
+			err := runCollector(ctx, c, deployment)
+			result.Status = models.JobSucceeded
+			if err != nil {
+				result.Status = models.JobFailed
+				result.Error = err.Error()
+			}
+			result.Duration = time.Since(result.Started).String()
+			progress(result)
+		}(c)
+	}
+
+	for _, c := range selected {
+		select {
+		case <-done[c.Name()]:
+		case <-ctx.Done():
+			log.WithFields(log.Fields{
+				"deployment": deployment,
+				"task":       c.Name(),
+				"deadline":   Cfg().PollInterval.CycleDeadline,
+			}).Error("Refresh deadline exceeded for the deployment")
+			return
+		}
+	}
+}
+
+// errDeadlineExceeded is reported for the collectors
+// not finished within the cycle deadline
+var errDeadlineExceeded = errors.New("refresh deadline exceeded")
+
+// contains checks if the slice has the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// cycleContext returns context bounded by the refresh cycle deadline
+func cycleContext() (context.Context, context.CancelFunc) {
+	deadline, err := time.ParseDuration(Cfg().PollInterval.CycleDeadline)
+	if err != nil || deadline <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), deadline)
 }
 
 // OpenStack Resources view methods implemenation
@@ -111,7 +228,7 @@ func listDeployments() []string {
 	buckets := DB.PrefixScan("")
 	for _, i := range buckets {
 		deployment := i.Bucket()[0]
-		if !strings.Contains(string(deployment), "storm") {
+		if inventoryBucket(deployment) {
 			deployments = append(deployments, deployment)
 		}
 
@@ -166,46 +283,6 @@ func listInstances(deployment string, hostname string) []models.Instance {
 	return instances
 }
 
-// filterInstancesByProject method returns list of instances
-// filteted by Project ID
-func filterInstancesByProject(deployment string, projectName string) []models.Instance {
-	defer utils.TimeTrack(time.Now(), listInstances)
-
-	var instances []models.Instance
-	var project models.Project
-	var err error
-
-	bucket := DB.From(deployment)
-	log.WithFields(log.Fields{
-		"deployment": deployment,
-		"project":    projectName,
-	}).Info("Fetching inventory Instances for the deployment")
-
-	if len(projectName) > 0 {
-		err = bucket.One("Name", projectName, &project)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"deployment": deployment,
-				"project":    projectName,
-			}).Error(err)
-		} else {
-			err = bucket.All(&instances)
-			if err != nil {
-				log.Error(err)
-			}
-			for i := 0; i < len(instances); i++ {
-				if instances[i].ProjectID != project.ID {
-					instances = append(instances[:i], instances[i+1:]...)
-					i--
-				}
-			}
-			return instances
-		}
-
-	}
-	return instances
-}
-
 // listImages method returns list of images
 // for the deployment
 func listImages(deployment string) []models.Image {
@@ -236,13 +313,7 @@ func listHypervisors(deployment string) []models.Hypervisor {
 	if err != nil {
 		log.Error(err)
 	}
-	for e, p := range hypervisors {
-		newHypervisor := &NewHypervisor{p}
-		for _, i := range newHypervisor.Instances(deployment) {
-			hypervisors[e].VMs = append(hypervisors[e].VMs, i.Name)
-		}
-		//hypervisors[e].Instances = newHypervisor.Instances(deployment)
-	}
+	addHypervisorVMs(deployment, hypervisors)
 	return hypervisors
 }
 
@@ -259,15 +330,40 @@ func listEmptyHypervisors(deployment string) []models.Hypervisor {
 	if err != nil {
 		log.Error(err)
 	}
-	for e, p := range hypervisors {
-		newHypervisor := &NewHypervisor{p}
-		if len(newHypervisor.Instances(deployment)) == 0 {
+
+	busy := make(map[string]bool)
+	hosts := hypervisorHashes(deployment, nil)
+	var instances []models.Instance
+	err = bucket.All(&instances)
+	if err != nil {
+		log.Error(err)
+	}
+	for _, i := range instances {
+		busy[hosts[i.HostID]] = true
+	}
+
+	for e, h := range hypervisors {
+		if !busy[h.Hostname] {
 			emptyHypervisors = append(emptyHypervisors, hypervisors[e])
 		}
 	}
 	return emptyHypervisors
 }
 
+// addHypervisorVMs adds names of the hypervisor instances to VMs
+func addHypervisorVMs(deployment string, hypervisors []models.Hypervisor) {
+	var hostnames []string
+	for _, h := range hypervisors {
+		hostnames = append(hostnames, h.Hostname)
+	}
+	instances := hypervisorInstances(deployment, hostnames)
+	for e, h := range hypervisors {
+		for _, i := range instances[h.Hostname] {
+			hypervisors[e].VMs = append(hypervisors[e].VMs, i.Name)
+		}
+	}
+}
+
 // listFlavors method returns list of flavors
 // for the deployment
 func listFlavors(deployment string) []models.Flavor {
@@ -302,125 +398,277 @@ func listAggregates(deployment string) []models.Aggregate {
 	return aggregates
 }
 
-// OpenStack Resource methods implemenation (by resource name)
-// Returns resource by its name (or 404)
+// findResources runs the list query with the matchers on the deployment
+// inventory into to. Storage errors are logged, errInvalidMarker
+// is returned for unknown markers
+func findResources(deployment string, lq *listQuery, to interface{}, matchers ...q.Matcher) (string, error) {
+	defer utils.TimeTrack(time.Now(), findResources)
 
-// getProject method returns Inventory Project Object
-func getProject(deployment string, name string) (models.Project, error) {
-	var project models.Project
-	bucket := DB.From(deployment)
 	log.WithFields(log.Fields{
 		"deployment": deployment,
-	}).Info("Fetching Inventory Project for the deployment")
-	err := bucket.One("Name", name, &project)
+		"resource":   fmt.Sprintf("%T", to),
+	}).Info("Fetching inventory for the deployment")
+
+	matchers = append(matchers, lq.filterMatcher(deployment))
+	next, err := lq.find(DB.From(deployment), to, presentMatchers(matchers)...)
+	if err != nil && err != errInvalidMarker {
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+		}).Error(err)
+		return "", nil
+	}
+	return next, err
+}
+
+// countResources returns the number of the model
+// records matching the matchers
+func countResources(deployment string, model interface{}, matchers ...q.Matcher) int {
+	count, err := DB.From(deployment).Select(presentMatchers(matchers)...).Count(model)
 	if err != nil {
-		if err == storm.ErrNotFound {
-			return project, err
+		log.WithFields(log.Fields{
+			"deployment": deployment,
+		}).Error(err)
+	}
+	return count
+}
+
+// presentMatchers drops nil matchers of unrestricted access
+func presentMatchers(matchers []q.Matcher) []q.Matcher {
+	var present []q.Matcher
+	for _, m := range matchers {
+		if m != nil {
+			present = append(present, m)
 		}
-		log.Error(err)
 	}
-	return project, nil
+	return present
 }
 
-// getInstance method returns Inventory Instance Object
-func getInstance(deployment string, hostname string) (models.Instance, error) {
-	var instance models.Instance
+// OpenStack Resource methods implemenation (by resource ID or name)
+// Returns resource by its ID or name (or 404)
+
+// objectRef references an Inventory object by its ID or name.
+// Names are not unique in OpenStack, the project (ID or name)
+// narrows the matches of the objects owned by projects
+type objectRef struct {
+	name    string
+	project string
+	access  *access
+}
+
+// newObjectRef returns the reference of the request,
+// the project is taken from the ?project= parameter
+func newObjectRef(c iris.Context, name string) objectRef {
+	return objectRef{
+		name:    name,
+		project: c.URLParam("project"),
+		access:  getAccess(c),
+	}
+}
+
+// findObject returns the Inventory object matching the reference.
+// The ID takes precedence over the names, an ambiguousError lists
+// the candidates if the name matches several objects
+func findObject(deployment string, collector string, ref objectRef) (models.Resource, error) {
+	c, _ := getCollector(collector)
+	oc := c.(ObjectCollector)
 	bucket := DB.From(deployment)
+
 	log.WithFields(log.Fields{
 		"deployment": deployment,
-		"instance":   hostname,
-	}).Info("Fetching inventory Instance for the deployment")
+		"resource":   collector,
+		"object":     ref.name,
+	}).Info("Fetching Inventory object for the deployment")
+
+	// ID and name hits are checked the same way against
+	// the caller access and the project of the reference
+	projects := ref.projectIDs(deployment)
+	allowed := func(r models.Resource) bool {
+		if !ref.access.resourceAllowed(deployment, r) {
+			return false
+		}
+		if projects != nil {
+			owner := reflect.ValueOf(r).Elem().FieldByName("ProjectID")
+			if owner.IsValid() && !projects[owner.String()] {
+				return false
+			}
+		}
+		return true
+	}
 
-	err := bucket.One("Name", hostname, &instance)
-	if err != nil {
-		if err == storm.ErrNotFound {
-			return instance, err
+	r, err := loadObject(bucket, c, ref.name)
+	switch {
+	case err == nil && allowed(r):
+		return r, nil
+	case err != nil && err != storm.ErrNotFound:
+		log.Error(err)
+		return nil, err
+	}
+
+	matches := oc.Model()
+	err = bucket.Find(oc.NameField(), ref.name, matches)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+		return nil, err
+	}
+
+	var found []models.Resource
+	items := reflect.ValueOf(matches).Elem()
+	for i := 0; i < items.Len(); i++ {
+		r := items.Index(i).Addr().Interface().(models.Resource)
+		if allowed(r) {
+			found = append(found, r)
 		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, storm.ErrNotFound
+	case 1:
+		return found[0], nil
+	}
+	e := &ambiguousError{ref: ref.name, objects: found}
+	for _, r := range found {
+		e.candidates = append(e.candidates, r.Key())
+	}
+	return nil, e
+}
+
+// projectIDs returns IDs of the projects matching the project
+// of the reference, nil if the reference has no project
+func (ref objectRef) projectIDs(deployment string) map[string]bool {
+	if ref.project == "" {
+		return nil
+	}
+	ids := map[string]bool{ref.project: true}
+	var projects []models.Project
+	err := DB.From(deployment).Find("Name", ref.project, &projects)
+	if err != nil && err != storm.ErrNotFound {
 		log.Error(err)
 	}
-	return instance, nil
+	for _, p := range projects {
+		ids[p.ID] = true
+	}
+	return ids
 }
 
-// getHypervisor method returns Inventory Hypervisor Object
-func getHypervisor(deployment string, hostname string) (models.Hypervisor, error) {
-	var hypervisor models.Hypervisor
+// candidateFields are the fields summarizing ambiguous matches
+var candidateFields = []string{"ID", "Name", "Hostname", "ProjectID"}
+
+// ambiguousCandidates summarizes the objects matching an ambiguous name
+func ambiguousCandidates(e *ambiguousError) []iris.Map {
+	candidates := []iris.Map{}
+	for _, r := range e.objects {
+		v := reflect.ValueOf(r).Elem()
+		candidate := iris.Map{}
+		for _, name := range candidateFields {
+			if f := v.FieldByName(name); f.IsValid() {
+				candidate[name] = f.Interface()
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
 
-	bucket := DB.From(deployment)
-	log.WithFields(log.Fields{
-		"deployment": deployment,
-	}).Info("Fetching inventory Hypervisor for the deployment")
+// lookupError responds 404 to unknown objects and 409 with the
+// candidates to ambiguous names, the kind names the resource type
+func lookupError(c iris.Context, kind string, name string, err error) iris.Map {
+	if err == storm.ErrNotFound {
+		c.StatusCode(iris.StatusNotFound)
+		return iris.Map{"message": fmt.Sprintf("%s %s not found", kind, name)}
+	}
+	e, ok := err.(*ambiguousError)
+	if !ok {
+		c.StatusCode(iris.StatusInternalServerError)
+		return iris.Map{"message": err.Error()}
+	}
+
+	hint := "use one of the IDs or ?project="
+	if kind == "Project" {
+		hint = "use one of the IDs"
+	}
+	c.StatusCode(iris.StatusConflict)
+	return iris.Map{
+		"message":    fmt.Sprintf("%s name %s is ambiguous, %s", kind, name, hint),
+		"candidates": ambiguousCandidates(e),
+	}
+}
 
-	err := bucket.One("Hostname", hostname, &hypervisor)
-	newHypervisor := &NewHypervisor{hypervisor}
+// getProject method returns Inventory Project Object
+func getProject(deployment string, ref objectRef) (models.Project, error) {
+	r, err := findObject(deployment, "projects", ref)
 	if err != nil {
-		if err == storm.ErrNotFound {
-			return hypervisor, err
-		}
-		log.Error(err)
+		return models.Project{}, err
 	}
-	for _, i := range newHypervisor.Instances(deployment) {
-		hypervisor.VMs = append(hypervisor.VMs, i.Name)
+	return *r.(*models.Project), nil
+}
+
+// getInstance method returns Inventory Instance Object
+func getInstance(deployment string, ref objectRef) (models.Instance, error) {
+	r, err := findObject(deployment, "instances", ref)
+	if err != nil {
+		return models.Instance{}, err
 	}
-	return hypervisor, nil
+	return *r.(*models.Instance), nil
+}
+
+// getHypervisor method returns Inventory Hypervisor Object
+func getHypervisor(deployment string, ref objectRef) (models.Hypervisor, error) {
+	r, err := findObject(deployment, "hypervisors", ref)
+	if err != nil {
+		return models.Hypervisor{}, err
+	}
+	hypervisors := []models.Hypervisor{*r.(*models.Hypervisor)}
+	addHypervisorVMs(deployment, hypervisors)
+	return hypervisors[0], nil
 }
 
 // getImage method returns Inventory Image Object
-func getImage(deployment string, name string) (models.Image, error) {
-	var image models.Image
-	bucket := DB.From(deployment)
-	log.WithFields(log.Fields{
-		"deployment": deployment,
-	}).Info("Fetching Inventory Image for the deployment")
-	err := bucket.One("Name", name, &image)
+func getImage(deployment string, ref objectRef) (models.Image, error) {
+	r, err := findObject(deployment, "images", ref)
 	if err != nil {
-		if err == storm.ErrNotFound {
-			return image, err
-		}
-		log.Error(err)
+		return models.Image{}, err
 	}
-	return image, nil
+	return *r.(*models.Image), nil
 }
 
 // getFlavor method returns Inventory Flavor Object
-func getFlavor(deployment string, name string) (models.Flavor, error) {
-	var flavor models.Flavor
-	bucket := DB.From(deployment)
-	log.WithFields(log.Fields{
-		"deployment": deployment,
-	}).Info("Fetching Inventory Flavor for the deployment")
-	err := bucket.One("Name", name, &flavor)
+func getFlavor(deployment string, ref objectRef) (models.Flavor, error) {
+	r, err := findObject(deployment, "flavors", ref)
 	if err != nil {
-		if err == storm.ErrNotFound {
-			return flavor, err
-		}
-		log.Error(err)
+		return models.Flavor{}, err
 	}
-	return flavor, nil
+	return *r.(*models.Flavor), nil
 }
 
 // getAggregate method returns Inventory Aggregate Object
-func getAggregate(deployment string, name string) (models.Aggregate, error) {
-	var aggregate models.Aggregate
-	bucket := DB.From(deployment)
-	log.WithFields(log.Fields{
-		"deployment": deployment,
-	}).Info("Fetching Inventory Aggregate for the deployment")
-	err := bucket.One("Name", name, &aggregate)
+func getAggregate(deployment string, ref objectRef) (models.Aggregate, error) {
+	r, err := findObject(deployment, "aggregates", ref)
 	if err != nil {
-		if err == storm.ErrNotFound {
-			return aggregate, err
-		}
-		log.Error(err)
+		return models.Aggregate{}, err
 	}
-	return aggregate, nil
+	return *r.(*models.Aggregate), nil
 }
 
 // getSnapshot method returns OpenStack Usage Snapshots per Deployment
 //func getSnapshots(deployment string) ([]models.Snapshot, error) {
-func getSnapshots(deployment string) (map[string]interface{}, error) {
-	var (
-		snapshots []models.Snapshot
-	)
+func getSnapshots(deployment string, a *access) (map[string]interface{}, error) {
+	snapshots := restrictSnapshots(deployment, a, listSnapshots(deployment))
+	if len(snapshots) == 0 {
+		return nil, storm.ErrNotFound
+	}
+
+	x := make(map[string]interface{})
+
+	for _, s := range snapshots {
+		x[s.ID] = s.Public()
+	}
+	return x, nil
+}
+
+// listSnapshots returns the Usage Snapshots of the deployment sorted by day
+func listSnapshots(deployment string) []models.Snapshot {
+	var snapshots []models.Snapshot
 
 	bucket := DB.From(deployment)
 	log.WithFields(log.Fields{
@@ -430,11 +678,5 @@ func getSnapshots(deployment string) (map[string]interface{}, error) {
 	if err != nil {
 		log.Error(err)
 	}
-
-	x := make(map[string]interface{})
-
-	for _, s := range snapshots {
-		x[s.ID] = s.Public()
-	}
-	return x, nil
+	return snapshots
 }
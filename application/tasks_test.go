@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"testing"
+
+	"ossia/models"
+
+	"github.com/asdine/storm"
+)
+
+func TestFindObjectProject(t *testing.T) {
+	openTestDB(t)
+	useTestConfig(t, &models.Configuration{})
+
+	bucket := DB.From("lab")
+	for _, r := range []interface{}{
+		&models.Project{ID: "p-a", Name: "team-a"},
+		&models.Project{ID: "p-b", Name: "team-b"},
+		&models.Instance{ID: "i1", Name: "web-1", ProjectID: "p-a"},
+		&models.Instance{ID: "i2", Name: "web-1", ProjectID: "p-b"},
+	} {
+		if err := bucket.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		ref     objectRef
+		want    string
+		err     error
+		matches int
+	}{
+		{"ID", objectRef{name: "i1"}, "i1", nil, 0},
+		{"ID of the project", objectRef{name: "i1", project: "team-a"}, "i1", nil, 0},
+		{"ID of the project ID", objectRef{name: "i2", project: "p-b"}, "i2", nil, 0},
+		{"ID of other project", objectRef{name: "i1", project: "team-b"}, "", storm.ErrNotFound, 0},
+		{"name of the project", objectRef{name: "web-1", project: "team-b"}, "i2", nil, 0},
+		{"ambiguous name", objectRef{name: "web-1"}, "", nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := findObject("lab", "instances", tt.ref)
+			if tt.matches > 0 {
+				e, ok := err.(*ambiguousError)
+				if !ok || len(e.objects) != tt.matches {
+					t.Fatalf("findObject() = %v, %v, want %d candidates", r, err, tt.matches)
+				}
+				return
+			}
+			if err != tt.err {
+				t.Fatalf("findObject() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && r.Key() != tt.want {
+				t.Errorf("findObject() = %s, want %s", r.Key(), tt.want)
+			}
+		})
+	}
+}
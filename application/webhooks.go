@@ -0,0 +1,655 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"ossia/models"
+	"ossia/utils"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	log "github.com/sirupsen/logrus"
+)
+
+// Webhooks deliver the Inventory events as <resource>.<created|updated|deleted>
+// (instance.deleted, image.created), hypervisor state and status changes
+// as hypervisor.<up|down|enabled|disabled> and the capacity usage crossing
+// the webhook threshold as capacity.above. Payloads are signed with
+// HMAC-SHA256 of the webhook secret in the X-Ossia-Signature header
+
+// Webhook event names, besides the Inventory ones
+const (
+	eventCapacityAbove = "capacity.above"
+	eventPing          = "ping"
+)
+
+// maxWebhookBackoff caps the delay between delivery attempts
+const maxWebhookBackoff = time.Hour
+
+// Webhook management errors
+var (
+	errWebhookExists     = errors.New("webhook already exists")
+	errWebhookInConfig   = errors.New("webhook is defined in the config file")
+	errWebhookMissing    = errors.New("webhook not found")
+	errDeadLetterMissing = errors.New("dead letter not found")
+	errBacklogFull       = errors.New("delivery backlog is full")
+)
+
+// delivery is the webhook request, retried with the same ID and body
+type delivery struct {
+	id       string
+	event    string
+	webhook  models.Webhook
+	body     []byte
+	attempts int
+}
+
+// webhookQueue holds the backlog of deliveries, passed to the workers one
+// at a time by the dispatcher. Both are started with the first delivery
+var webhookQueue = struct {
+	sync.Mutex
+	once       sync.Once
+	backlog    []*delivery
+	ready      chan struct{}
+	deliveries chan *delivery
+}{
+	ready:      make(chan struct{}, 1),
+	deliveries: make(chan *delivery),
+}
+
+// capacityAlerts tracks the capacity above the threshold per webhook,
+// deployment and metric, so capacity.above fires once per crossing
+var capacityAlerts = struct {
+	sync.Mutex
+	above map[string]bool
+}{
+	above: make(map[string]bool),
+}
+
+// listWebhooks returns the webhooks defined in the config file
+// and the ones registered via API
+func listWebhooks() []models.Webhook {
+	subscriptions := Cfg().Webhooks.Subscriptions
+	webhooks := make([]models.Webhook, 0, len(subscriptions))
+	for _, w := range subscriptions {
+		w.Source = models.WebhookFromConfig
+		webhooks = append(webhooks, w)
+	}
+
+	var registered []models.Webhook
+	err := DB.From(systemBucket).All(&registered)
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+	}
+	for _, w := range registered {
+		w.Source = models.WebhookFromAPI
+		webhooks = append(webhooks, w)
+	}
+	return webhooks
+}
+
+// getWebhook returns the webhook by name
+func getWebhook(name string) (models.Webhook, error) {
+	for _, w := range listWebhooks() {
+		if w.Name == name {
+			return w, nil
+		}
+	}
+	return models.Webhook{}, errWebhookMissing
+}
+
+// inConfigFile is true for the webhooks defined in the config file
+func inConfigFile(name string) bool {
+	for _, w := range Cfg().Webhooks.Subscriptions {
+		if w.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// saveWebhook registers the webhook. The secret is encrypted
+// with the admin secret key
+func saveWebhook(w models.Webhook) (models.Webhook, error) {
+	err := validateWebhook(w)
+	if err != nil {
+		return w, err
+	}
+	if inConfigFile(w.Name) {
+		return w, errWebhookInConfig
+	}
+
+	bucket := DB.From(systemBucket)
+	err = bucket.One("Name", w.Name, &models.Webhook{})
+	if err == nil {
+		return w, errWebhookExists
+	}
+	if err != storm.ErrNotFound {
+		return w, err
+	}
+
+	w.SealedSecret = ""
+	if w.Secret != "" {
+		key := Cfg().Admin.SecretKey
+		if key == "" {
+			return w, errNoSecretKey
+		}
+		w.SealedSecret, err = utils.Encrypt(key, w.Secret)
+		if err != nil {
+			return w, err
+		}
+	}
+	w.Secret = ""
+	w.Source = models.WebhookFromAPI
+	w.Created = time.Now()
+
+	err = bucket.Save(&w)
+	if err != nil {
+		return w, err
+	}
+
+	log.WithFields(log.Fields{
+		"webhook": w.Name,
+		"url":     w.URL,
+	}).Info("Registered webhook")
+	return w, nil
+}
+
+// deleteWebhook removes the webhook registered via API
+func deleteWebhook(name string) error {
+	if inConfigFile(name) {
+		return errWebhookInConfig
+	}
+
+	var w models.Webhook
+	bucket := DB.From(systemBucket)
+	err := bucket.One("Name", name, &w)
+	if err == storm.ErrNotFound {
+		return errWebhookMissing
+	}
+	if err != nil {
+		return err
+	}
+	err = bucket.DeleteStruct(&w)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"webhook": name,
+	}).Info("Deleted webhook")
+	return nil
+}
+
+// validateWebhook checks the URL, the patterns and the threshold
+func validateWebhook(w models.Webhook) error {
+	if w.Name == "" {
+		return errors.New("name is required")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", w.URL)
+	}
+	if len(w.Events) == 0 {
+		return errors.New("events are required")
+	}
+	for _, pattern := range append(append([]string{}, w.Events...), w.Deployments...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if w.Threshold < 0 || w.Threshold > 100 {
+		return errors.New("threshold must be between 0 and 100")
+	}
+	return nil
+}
+
+// subscribed is true if the webhook receives the event of the deployment
+func subscribed(w models.Webhook, event string, deployment string) bool {
+	if len(w.Deployments) > 0 && !matchAny(w.Deployments, deployment) {
+		return false
+	}
+	return matchAny(w.Events, event)
+}
+
+// eventNames returns the webhook event names of the Inventory change
+func eventNames(e models.Event) []string {
+	names := []string{strings.TrimSuffix(e.Resource, "s") + "." + e.Type}
+	if e.Resource != "hypervisors" || e.Type != models.EventUpdated {
+		return names
+	}
+	for _, field := range []string{"State", "Status"} {
+		if c, ok := e.Changes[field]; ok {
+			names = append(names, fmt.Sprintf("hypervisor.%v", c.New))
+		}
+	}
+	return names
+}
+
+// notifyWebhooks queues the Inventory changes for the subscribed webhooks
+func notifyWebhooks(events []models.Event) {
+	if len(events) == 0 {
+		return
+	}
+	webhooks := listWebhooks()
+	if len(webhooks) == 0 {
+		return
+	}
+
+	var deliveries []*delivery
+	for i := range events {
+		e := events[i]
+		for _, name := range eventNames(e) {
+			for _, w := range webhooks {
+				if !subscribed(w, name, e.Deployment) {
+					continue
+				}
+				d := newDelivery(w, models.WebhookPayload{
+					Event:      name,
+					Time:       e.Time,
+					Deployment: e.Deployment,
+					Change:     &e,
+				})
+				if d != nil {
+					deliveries = append(deliveries, d)
+				}
+			}
+		}
+	}
+	queueDeliveries(deliveries...)
+}
+
+// checkCapacity fires capacity.above for the webhooks with the capacity
+// usage of the deployment crossing their threshold. The usage has to go
+// below the threshold before firing again
+func checkCapacity(deployment string) {
+	var webhooks []models.Webhook
+	for _, w := range listWebhooks() {
+		if w.Threshold > 0 && subscribed(w, eventCapacityAbove, deployment) {
+			webhooks = append(webhooks, w)
+		}
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	usage := getUsage(deployment, nil)
+	metrics := []models.CapacityAlert{
+		{Metric: "vcpus", Used: usage.VCPUsUsed, Total: usage.VCPUs},
+		{Metric: "memory", Used: usage.MemoryUsedMB, Total: usage.MemoryMB},
+		{Metric: "disk", Used: usage.DiskUsedGB, Total: usage.DiskGB},
+	}
+
+	capacityAlerts.Lock()
+	defer capacityAlerts.Unlock()
+
+	for _, w := range webhooks {
+		for _, m := range metrics {
+			if m.Total == 0 {
+				continue
+			}
+			m.Percent = usagePercent(m.Used, m.Total)
+			m.Threshold = w.Threshold
+
+			key := w.Name + "/" + deployment + "/" + m.Metric
+			above := m.Percent >= w.Threshold
+			if above && !capacityAlerts.above[key] {
+				alert := m
+				queueWebhook(w, models.WebhookPayload{
+					Event:      eventCapacityAbove,
+					Time:       time.Now(),
+					Deployment: deployment,
+					Capacity:   &alert,
+				})
+			}
+			capacityAlerts.above[key] = above
+		}
+	}
+}
+
+// queueWebhook queues the payload for the webhook and returns its delivery ID
+func queueWebhook(w models.Webhook, payload models.WebhookPayload) string {
+	d := newDelivery(w, payload)
+	if d == nil {
+		return ""
+	}
+	queueDeliveries(d)
+	return d.id
+}
+
+// newDelivery encodes the payload for the webhook, nil if it fails
+func newDelivery(w models.Webhook, payload models.WebhookPayload) *delivery {
+	payload.ID = utils.NewID()
+	payload.Webhook = w.Name
+	if payload.Time.IsZero() {
+		payload.Time = time.Now()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"webhook": w.Name,
+			"event":   payload.Event,
+			"error":   err,
+		}).Error("Unable to encode webhook payload")
+		return nil
+	}
+
+	return &delivery{
+		id:      payload.ID,
+		event:   payload.Event,
+		webhook: w,
+		body:    body,
+	}
+}
+
+// queueDeliveries adds the deliveries to the backlog without blocking
+// the caller. Beyond the configured backlog the oldest deliveries are
+// dead-lettered, in one batch outside of the caller
+func queueDeliveries(ds ...*delivery) {
+	if len(ds) == 0 {
+		return
+	}
+	webhookQueue.once.Do(func() {
+		workers := Cfg().Webhooks.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			go webhookWorker()
+		}
+		go dispatchDeliveries()
+	})
+
+	var dropped []*delivery
+	webhookQueue.Lock()
+	webhookQueue.backlog = append(webhookQueue.backlog, ds...)
+	limit := Cfg().Webhooks.Backlog
+	if excess := len(webhookQueue.backlog) - limit; limit > 0 && excess > 0 {
+		dropped = make([]*delivery, excess)
+		copy(dropped, webhookQueue.backlog)
+		webhookQueue.backlog = append([]*delivery(nil), webhookQueue.backlog[excess:]...)
+	}
+	webhookQueue.Unlock()
+
+	select {
+	case webhookQueue.ready <- struct{}{}:
+	default:
+	}
+	if len(dropped) > 0 {
+		go saveDeadLetters(dropped, errBacklogFull)
+	}
+}
+
+// dispatchDeliveries passes the backlog to the workers, waiting
+// for a free worker before taking the next delivery
+func dispatchDeliveries() {
+	for range webhookQueue.ready {
+		for d := nextDelivery(); d != nil; d = nextDelivery() {
+			webhookQueue.deliveries <- d
+		}
+	}
+}
+
+// nextDelivery removes the oldest delivery from the backlog, nil if empty
+func nextDelivery() *delivery {
+	webhookQueue.Lock()
+	defer webhookQueue.Unlock()
+
+	if len(webhookQueue.backlog) == 0 {
+		return nil
+	}
+	d := webhookQueue.backlog[0]
+	webhookQueue.backlog[0] = nil
+	webhookQueue.backlog = webhookQueue.backlog[1:]
+	return d
+}
+
+// webhookWorker delivers the queued requests. Failed deliveries are
+// queued again after the backoff, dead-lettered after all retries
+func webhookWorker() {
+	for d := range webhookQueue.deliveries {
+		err := deliverWebhook(d)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"webhook":  d.webhook.Name,
+				"event":    d.event,
+				"delivery": d.id,
+			}).Debug("Delivered webhook")
+			continue
+		}
+		config := Cfg().Webhooks
+		if d.attempts > config.Retries {
+			saveDeadLetter(d, err)
+			continue
+		}
+
+		backoff := config.Backoff
+		for i := 1; i < d.attempts && backoff < maxWebhookBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > maxWebhookBackoff {
+			backoff = maxWebhookBackoff
+		}
+		log.WithFields(log.Fields{
+			"webhook":  d.webhook.Name,
+			"event":    d.event,
+			"delivery": d.id,
+			"attempt":  d.attempts,
+			"retry_in": backoff,
+			"error":    err,
+		}).Warn("Webhook delivery failed, retrying")
+
+		retry := d
+		time.AfterFunc(backoff, func() { queueDeliveries(retry) })
+	}
+}
+
+// deliverWebhook posts the payload, any status but 2xx is a failure
+func deliverWebhook(d *delivery) error {
+	d.attempts++
+
+	req, err := http.NewRequest(http.MethodPost, d.webhook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", AppName)
+	req.Header.Set("X-Ossia-Event", d.event)
+	req.Header.Set("X-Ossia-Delivery", d.id)
+
+	secret, err := webhookSecret(d.webhook)
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		req.Header.Set("X-Ossia-Signature", "sha256="+signPayload(secret, d.body))
+	}
+
+	client := &http.Client{Timeout: Cfg().Webhooks.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSecret returns the signing key, decrypted for the
+// webhooks registered via API
+func webhookSecret(w models.Webhook) (string, error) {
+	if w.SealedSecret == "" {
+		return w.Secret, nil
+	}
+	return utils.Decrypt(Cfg().Admin.SecretKey, w.SealedSecret)
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the body
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// saveDeadLetter keeps the failed delivery, dropping the oldest
+// dead letters above the configured amount
+func saveDeadLetter(d *delivery, err error) {
+	saveDeadLetters([]*delivery{d}, err)
+}
+
+// saveDeadLetters keeps the failed deliveries in one transaction,
+// dropping the oldest dead letters above the configured amount
+func saveDeadLetters(ds []*delivery, err error) {
+	if len(ds) == 1 {
+		d := ds[0]
+		log.WithFields(log.Fields{
+			"webhook":  d.webhook.Name,
+			"event":    d.event,
+			"delivery": d.id,
+			"attempts": d.attempts,
+			"error":    err,
+		}).Error("Webhook delivery failed, saved as dead letter")
+	} else {
+		log.WithFields(log.Fields{
+			"deliveries": len(ds),
+			"error":      err,
+		}).Error("Webhook deliveries failed, saved as dead letters")
+	}
+
+	limit := Cfg().Webhooks.DeadLetters
+	if len(ds) > limit {
+		ds = ds[len(ds)-limit:]
+	}
+	tx, txErr := DB.From(systemBucket).Begin(true)
+	if txErr != nil {
+		log.Error(txErr)
+		return
+	}
+	defer tx.Rollback()
+
+	failed := time.Now()
+	for _, d := range ds {
+		letter := models.DeadLetter{
+			ID:       d.id,
+			Webhook:  d.webhook.Name,
+			Event:    d.event,
+			URL:      d.webhook.URL,
+			Payload:  d.body,
+			Attempts: d.attempts,
+			Error:    err.Error(),
+			Failed:   failed,
+		}
+		txErr = tx.Save(&letter)
+		if txErr != nil {
+			log.Error(txErr)
+			return
+		}
+	}
+
+	count, txErr := tx.Count(&models.DeadLetter{})
+	excess := count - limit
+	if txErr == nil && excess > 0 {
+		var oldest []models.DeadLetter
+		txErr = tx.AllByIndex("Failed", &oldest, storm.Limit(excess))
+		if txErr != nil {
+			log.Error(txErr)
+			return
+		}
+		for i := range oldest {
+			txErr = tx.DeleteStruct(&oldest[i])
+			if txErr != nil {
+				log.Error(txErr)
+				return
+			}
+		}
+	}
+	txErr = tx.Commit()
+	if txErr != nil {
+		log.Error(txErr)
+	}
+}
+
+// listDeadLetters returns the failed deliveries, the latest first
+func listDeadLetters() []models.DeadLetter {
+	letters := []models.DeadLetter{}
+	err := DB.From(systemBucket).AllByIndex("Failed", &letters, storm.Reverse())
+	if err != nil && err != storm.ErrNotFound {
+		log.Error(err)
+	}
+	return letters
+}
+
+// getDeadLetter returns the failed delivery by ID
+func getDeadLetter(id string) (models.DeadLetter, error) {
+	var letter models.DeadLetter
+	err := DB.From(systemBucket).One("ID", id, &letter)
+	if err == storm.ErrNotFound {
+		return letter, errDeadLetterMissing
+	}
+	return letter, err
+}
+
+// retryDeadLetter queues the failed delivery again to the current URL
+// of its webhook or alert channel and removes the dead letter
+func retryDeadLetter(id string) (models.DeadLetter, error) {
+	letter, err := getDeadLetter(id)
+	if err != nil {
+		return letter, err
+	}
+	w, err := getWebhook(letter.Webhook)
+	if err == errWebhookMissing {
+		w, err = channelWebhook(letter.Webhook)
+	}
+	if err != nil {
+		return letter, err
+	}
+	err = DB.From(systemBucket).DeleteStruct(&letter)
+	if err != nil {
+		return letter, err
+	}
+
+	queueDeliveries(&delivery{
+		id:      letter.ID,
+		event:   letter.Event,
+		webhook: w,
+		body:    letter.Payload,
+	})
+	return letter, nil
+}
+
+// deleteDeadLetter removes the failed delivery
+func deleteDeadLetter(id string) error {
+	letter, err := getDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	return DB.From(systemBucket).DeleteStruct(&letter)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package application
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ossia/models"
+
+	"github.com/asdine/storm"
+)
+
+// openTestDB replaces DB with a temporary database
+func openTestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ossia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storm.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		db.Close()
+		os.RemoveAll(dir)
+	})
+}
+
+// useTestConfig replaces the configuration for the test
+func useTestConfig(t *testing.T, config *models.Configuration) {
+	previous := currentConfig.Load()
+	setConfig(config)
+	t.Cleanup(func() {
+		if previous != nil {
+			setConfig(previous.(*models.Configuration))
+		}
+	})
+}
+
+func TestSignPayload(t *testing.T) {
+	got := signPayload("s3cret", []byte(`{"event":"ping"}`))
+	want := "dfdb9d36759a59dc252c24d194f7122a8df08cb88f87fa8d5c6b24ff48fe45d8"
+	if got != want {
+		t.Errorf("signPayload() = %s, want %s", got, want)
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	w := models.Webhook{
+		Events:      []string{"instance.deleted", "hypervisor.*"},
+		Deployments: []string{"us-west-*"},
+	}
+	tests := []struct {
+		event      string
+		deployment string
+		want       bool
+	}{
+		{"instance.deleted", "us-west-1", true},
+		{"hypervisor.down", "us-west-2", true},
+		{"instance.created", "us-west-1", false},
+		{"instance.deleted", "eu-central-1", false},
+	}
+	for _, tt := range tests {
+		if got := subscribed(w, tt.event, tt.deployment); got != tt.want {
+			t.Errorf("subscribed(%s, %s) = %v, want %v", tt.event, tt.deployment, got, tt.want)
+		}
+	}
+
+	w.Deployments = nil
+	if !subscribed(w, "instance.deleted", "eu-central-1") {
+		t.Error("subscribed() = false for all the deployments, want true")
+	}
+}
+
+func TestEventNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		event models.Event
+		want  []string
+	}{
+		{
+			"instance",
+			models.Event{Resource: "instances", Type: models.EventDeleted},
+			[]string{"instance.deleted"},
+		},
+		{
+			"hypervisor state",
+			models.Event{Resource: "hypervisors", Type: models.EventUpdated, Changes: map[string]models.Change{
+				"State":  {Old: "up", New: "down"},
+				"Status": {Old: "enabled", New: "disabled"},
+			}},
+			[]string{"hypervisor.updated", "hypervisor.down", "hypervisor.disabled"},
+		},
+		{
+			"hypervisor created",
+			models.Event{Resource: "hypervisors", Type: models.EventCreated, Changes: map[string]models.Change{
+				"State": {New: "up"},
+			}},
+			[]string{"hypervisor.created"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eventNames(tt.event)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("eventNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	openTestDB(t)
+	useTestConfig(t, &models.Configuration{Webhooks: models.Webhooks{
+		Workers:     2,
+		Backlog:     100,
+		Timeout:     time.Second,
+		Retries:     2,
+		Backoff:     time.Millisecond,
+		DeadLetters: 10,
+	}})
+
+	delivered := make(chan string, 10)
+	attempts := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Ossia-Signature") != "sha256="+signPayload("s3cret", body) {
+			t.Errorf("invalid signature %s", r.Header.Get("X-Ossia-Signature"))
+		}
+		if r.URL.Path == "/fail" {
+			attempts <- r.Header.Get("X-Ossia-Delivery")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		delivered <- r.Header.Get("X-Ossia-Delivery")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ok := models.Webhook{Name: "ok", URL: server.URL + "/ok", Secret: "s3cret"}
+	id := queueWebhook(ok, models.WebhookPayload{Event: eventPing})
+	select {
+	case got := <-delivered:
+		if got != id {
+			t.Errorf("delivered %s, want %s", got, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	fail := models.Webhook{Name: "fail", URL: server.URL + "/fail", Secret: "s3cret"}
+	id = queueWebhook(fail, models.WebhookPayload{Event: eventPing})
+	deadline := time.After(5 * time.Second)
+	for {
+		letter, err := getDeadLetter(id)
+		if err == nil {
+			if letter.Attempts != 3 || len(attempts) != 3 {
+				t.Errorf("dead letter after %d attempts (%d requests), want 3", letter.Attempts, len(attempts))
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("failed webhook not dead-lettered")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestSaveDeadLetters(t *testing.T) {
+	openTestDB(t)
+	useTestConfig(t, &models.Configuration{Webhooks: models.Webhooks{DeadLetters: 10}})
+
+	w := models.Webhook{Name: "ops", URL: "http://127.0.0.1/ops"}
+	var ds []*delivery
+	for i := 0; i < 8; i++ {
+		ds = append(ds, newDelivery(w, models.WebhookPayload{Event: eventPing}))
+	}
+	saveDeadLetter(ds[0], errBacklogFull)
+	time.Sleep(time.Millisecond)
+	saveDeadLetters(ds[1:], errBacklogFull)
+	if got := len(listDeadLetters()); got != 8 {
+		t.Fatalf("saved %d dead letters, want 8", got)
+	}
+
+	var more []*delivery
+	for i := 0; i < 15; i++ {
+		more = append(more, newDelivery(w, models.WebhookPayload{Event: eventPing}))
+	}
+	time.Sleep(time.Millisecond)
+	saveDeadLetters(more, errBacklogFull)
+
+	letters := listDeadLetters()
+	if len(letters) != 10 {
+		t.Fatalf("kept %d dead letters, want 10", len(letters))
+	}
+	kept := make(map[string]bool)
+	for _, l := range letters {
+		kept[l.ID] = true
+	}
+	for _, d := range more[5:] {
+		if !kept[d.id] {
+			t.Errorf("dead letter %s dropped, want the latest ones kept", d.id)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are accepted for the time values
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// Resolver returns the values of the field path, nil if the
+// field is missing. A Comparison matches if any value matches
+type Resolver func(path string) []interface{}
+
+// Expr is the filter expression tree
+type Expr interface {
+	Match(r Resolver) bool
+	String() string
+}
+
+// And matches if both expressions match
+type And struct {
+	Left  Expr
+	Right Expr
+}
+
+// Match evaluates the expression
+func (e And) Match(r Resolver) bool { return e.Left.Match(r) && e.Right.Match(r) }
+
+func (e And) String() string { return fmt.Sprintf("(%s and %s)", e.Left, e.Right) }
+
+// Or matches if any expression matches
+type Or struct {
+	Left  Expr
+	Right Expr
+}
+
+// Match evaluates the expression
+func (e Or) Match(r Resolver) bool { return e.Left.Match(r) || e.Right.Match(r) }
+
+func (e Or) String() string { return fmt.Sprintf("(%s or %s)", e.Left, e.Right) }
+
+// Not negates the expression
+type Not struct {
+	Expr Expr
+}
+
+// Match evaluates the expression
+func (e Not) Match(r Resolver) bool { return !e.Expr.Match(r) }
+
+func (e Not) String() string { return fmt.Sprintf("not %s", e.Expr) }
+
+// Comparison compares the field values with Value. Value is
+// converted to the type of the field (number, boolean, time)
+type Comparison struct {
+	Path  string
+	Op    string
+	Value string
+}
+
+func (e Comparison) String() string { return fmt.Sprintf("%s%s%q", e.Path, e.Op, e.Value) }
+
+// Match evaluates the comparison. Negated operators match if
+// no value matches, missing fields only match them
+func (e Comparison) Match(r Resolver) bool {
+	values := r(e.Path)
+	switch e.Op {
+	case OpNotEqual:
+		return !e.any(values, OpEqual)
+	case OpNotMatch:
+		return !e.any(values, OpMatch)
+	}
+	return e.any(values, e.Op)
+}
+
+func (e Comparison) any(values []interface{}, op string) bool {
+	for _, v := range values {
+		if compare(v, op, e.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies the operator to the field value and the literal
+func compare(value interface{}, op string, literal string) bool {
+	if op == OpMatch {
+		ok, _ := path.Match(literal, fmt.Sprint(value))
+		return ok
+	}
+
+	var c int
+	switch v := value.(type) {
+	case time.Time:
+		t, ok := parseTime(literal)
+		if !ok {
+			return false
+		}
+		c = compareOrdered(float64(v.Sub(t)), 0)
+	case bool:
+		b, err := strconv.ParseBool(literal)
+		if err != nil || (op != OpEqual && op != OpNotEqual) {
+			return false
+		}
+		if v != b {
+			c = 1
+		}
+	case string:
+		c = strings.Compare(v, literal)
+	default:
+		rv := reflect.ValueOf(value)
+		var n float64
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = rv.Float()
+		default:
+			c = strings.Compare(fmt.Sprint(value), literal)
+			return result(c, op)
+		}
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return false
+		}
+		c = compareOrdered(n, f)
+	}
+	return result(c, op)
+}
+
+func result(c int, op string) bool {
+	switch op {
+	case OpEqual:
+		return c == 0
+	case OpGreater:
+		return c > 0
+	case OpGreaterEqual:
+		return c >= 0
+	case OpLess:
+		return c < 0
+	case OpLessEqual:
+		return c <= 0
+	}
+	return false
+}
+
+func compareOrdered(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Comparisons returns all comparisons of the expression
+func Comparisons(e Expr) []Comparison {
+	switch v := e.(type) {
+	case And:
+		return append(Comparisons(v.Left), Comparisons(v.Right)...)
+	case Or:
+		return append(Comparisons(v.Left), Comparisons(v.Right)...)
+	case Not:
+		return Comparisons(v.Expr)
+	case Comparison:
+		return []Comparison{v}
+	}
+	return nil
+}
+
+// Conjuncts returns the comparisons every match has to satisfy,
+// which may be looked up in an index before the evaluation
+func Conjuncts(e Expr) []Comparison {
+	switch v := e.(type) {
+	case And:
+		return append(Conjuncts(v.Left), Conjuncts(v.Right)...)
+	case Comparison:
+		return []Comparison{v}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type flavor struct {
+	Name string
+}
+
+func TestMatch(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fields := map[string][]interface{}{
+		"status":  {"ACTIVE"},
+		"name":    {"web-1"},
+		"vcpus":   {8},
+		"disk":    {uint64(40)},
+		"ratio":   {1.5},
+		"locked":  {true},
+		"created": {created},
+		"tags":    {"kafka-1", "db"},
+		"flavor":  {flavor{Name: "m1"}},
+	}
+	resolver := func(path string) []interface{} { return fields[path] }
+
+	tests := []struct {
+		filter string
+		match  bool
+	}{
+		{"status==ACTIVE", true},
+		{"status==ERROR", false},
+		{"status!=ERROR", true},
+		{"status>ABC", true},
+		{"name~web-*", true},
+		{"name~db-*", false},
+		{"name!~db-*", true},
+		{"vcpus==8", true},
+		{"vcpus>=8", true},
+		{"vcpus>8", false},
+		{"vcpus<16", true},
+		{"vcpus<=7", false},
+		{"vcpus==eight", false},
+		{"disk==40", true},
+		{"disk>39.5", true},
+		{"ratio==1.5", true},
+		{"ratio<1", false},
+		{"locked==true", true},
+		{"locked==false", false},
+		{"locked!=false", true},
+		{"locked>false", false},
+		{"locked==yes", false},
+		{"created==2020-01-02T03:04:05Z", true},
+		{`created>"2020-01-02"`, true},
+		{`created<"2020-01-02 03:04:06"`, true},
+		{"created>2020-01-03", false},
+		{"created>yesterday", false},
+		{"tags==db", true},
+		{"tags~kafka-*", true},
+		{"tags!=db", false},
+		{"flavor=={m1}", true},
+		{"missing==x", false},
+		{"missing!=x", true},
+		{"missing!~x*", true},
+		{"status==ACTIVE and vcpus>=8", true},
+		{"status==ACTIVE and vcpus>8", false},
+		{"status==ERROR or vcpus>=8", true},
+		{"not status==ACTIVE", false},
+		{"not (status==ERROR or locked==false)", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.filter, err)
+			}
+			if got := expr.Match(resolver); got != tt.match {
+				t.Errorf("Match() = %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestComparisons(t *testing.T) {
+	tests := []struct {
+		filter      string
+		comparisons []string
+		conjuncts   []string
+	}{
+		{"a==1", []string{"a"}, []string{"a"}},
+		{"a==1 and b==2", []string{"a", "b"}, []string{"a", "b"}},
+		{"a==1 and (b==2 or c==3)", []string{"a", "b", "c"}, []string{"a"}},
+		{"a==1 or b==2", []string{"a", "b"}, nil},
+		{"not a==1 and b==2", []string{"a", "b"}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			expr, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.filter, err)
+			}
+			if got := paths(Comparisons(expr)); !reflect.DeepEqual(got, tt.comparisons) {
+				t.Errorf("Comparisons() = %v, want %v", got, tt.comparisons)
+			}
+			if got := paths(Conjuncts(expr)); !reflect.DeepEqual(got, tt.conjuncts) {
+				t.Errorf("Conjuncts() = %v, want %v", got, tt.conjuncts)
+			}
+		})
+	}
+}
+
+func paths(comparisons []Comparison) []string {
+	var p []string
+	for _, c := range comparisons {
+		p = append(p, c.Path)
+	}
+	return p
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Comparison operators
+const (
+	OpEqual        = "=="
+	OpNotEqual     = "!="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpMatch        = "~"
+	OpNotMatch     = "!~"
+)
+
+// operators are ordered so the longest prefix is tried first
+var operators = []string{OpEqual, OpNotEqual, OpNotMatch, OpGreaterEqual, OpLessEqual, OpGreater, OpLess, OpMatch, "="}
+
+// Parse builds the expression tree of the filter such as
+// status==ACTIVE and (flavor.vcpus>=8 or metadata.cluster~"kafka-*").
+// Comparisons are combined with and, or, not and parentheses
+func Parse(s string) (Expr, error) {
+	p := &parser{input: s}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return expr, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("filter: position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.keyword("not", "") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	}
+
+	p.skipSpaces()
+	if p.peek() == '(' {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && isPathChar(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	path := p.input[start:p.pos]
+	if path == "" {
+		return nil, p.errorf("field expected")
+	}
+
+	p.skipSpaces()
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(p.input[p.pos:], o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("operator expected after %s", path)
+	}
+	p.pos += len(op)
+	if op == "=" {
+		op = OpEqual
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return Comparison{Path: path, Op: op, Value: value}, nil
+}
+
+// parseValue reads a quoted string or a bare word
+// up to the next space or parenthesis
+func (p *parser) parseValue() (string, error) {
+	p.skipSpaces()
+	if p.peek() == '"' || p.peek() == '\'' {
+		quote := p.input[p.pos]
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.input) {
+			ch := p.input[p.pos]
+			p.pos++
+			switch {
+			case ch == '\\' && p.pos < len(p.input):
+				b.WriteByte(p.input[p.pos])
+				p.pos++
+			case ch == quote:
+				return b.String(), nil
+			default:
+				b.WriteByte(ch)
+			}
+		}
+		return "", p.errorf("unterminated string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) && p.input[p.pos] != '(' && p.input[p.pos] != ')' {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("value expected")
+	}
+	return p.input[start:p.pos], nil
+}
+
+// keyword consumes the word (case insensitive) or the symbol
+func (p *parser) keyword(word string, symbol string) bool {
+	p.skipSpaces()
+	rest := p.input[p.pos:]
+	if symbol != "" && strings.HasPrefix(rest, symbol) {
+		p.pos += len(symbol)
+		return true
+	}
+	if len(rest) > len(word) && strings.EqualFold(rest[:len(word)], word) {
+		next := rune(rest[len(word)])
+		if unicode.IsSpace(next) || next == '(' {
+			p.pos += len(word)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func isPathChar(ch rune) bool {
+	return unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '_' || ch == '.' || ch == '-'
+}
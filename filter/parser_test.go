@@ -0,0 +1,84 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"equal", "status==ACTIVE", `status=="ACTIVE"`},
+		{"single equal", "status=ACTIVE", `status=="ACTIVE"`},
+		{"path", "flavor.vcpus>=8", `flavor.vcpus>="8"`},
+		{"operators", "a!=1 and b>2 and c<3 and d<=4 and e~x* and f!~y*", `(((((a!="1" and b>"2") and c<"3") and d<="4") and e~"x*") and f!~"y*")`},
+		{"and before or", "a==1 or b==2 and c==3", `(a=="1" or (b=="2" and c=="3"))`},
+		{"or left to right", "a==1 and b==2 or c==3", `((a=="1" and b=="2") or c=="3")`},
+		{"symbols", "a==1 || b==2 && c==3", `(a=="1" or (b=="2" and c=="3"))`},
+		{"parentheses", "(a==1 or b==2) and c==3", `((a=="1" or b=="2") and c=="3")`},
+		{"not", "not (a==1 or b==2)", `not (a=="1" or b=="2")`},
+		{"keywords ignore case", "NOT a==1 AND b==2", `(not a=="1" and b=="2")`},
+		{"spaces", "  a ==  1  ", `a=="1"`},
+		{"quoted", `name=="web 1"`, `name=="web 1"`},
+		{"single quoted", `name=='web "1"'`, `name=="web \"1\""`},
+		{"escaped quote", `name=="a\"b"`, `name=="a\"b"`},
+		{"bare value stops at parenthesis", "(a==1)", `a=="1"`},
+		{"keyword prefix in path", "oregon==1 and notes==2", `(oregon=="1" and notes=="2")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.input, err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"empty", "", "position 1: field expected"},
+		{"missing operator", "status", "position 7: operator expected after status"},
+		{"missing value", "status==", "position 9: value expected"},
+		{"missing field", "==1", "position 1: field expected"},
+		{"unterminated string", `name=="web`, "unterminated string"},
+		{"missing parenthesis", "(a==1", "position 6: missing )"},
+		{"extra parenthesis", "a==1)", `position 5: unexpected ")"`},
+		{"missing keyword", "a==1 b==2", `position 6: unexpected "b==2"`},
+		{"keyword without space", "a==1 andb==2", `unexpected "andb==2"`},
+		{"dangling and", "a==1 and", `position 6: unexpected "and"`},
+		{"dangling not", "not", "operator expected after not"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.input)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded", tt.input)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse(%q) error = %v, want %s", tt.input, err, tt.err)
+			}
+		})
+	}
+}
@@ -17,5 +17,9 @@ require (
 	github.com/robfig/cron v1.2.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/viper v1.7.1
+	github.com/square/go-jose/v3 v3.0.0-20200630053402-0a67ce9b0693
 	go.etcd.io/bbolt v1.3.5
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
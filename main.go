@@ -25,6 +25,28 @@ governing permissions and limitations under the License.
 //
 //     Produces:
 //     - application/json
+//     - application/x-yaml
+//     - text/csv
+//     - application/x-ndjson
+//
+//     Security:
+//     - api_key:
+//     - bearer:
+//     - keystone:
+//
+//     SecurityDefinitions:
+//     api_key:
+//          type: apiKey
+//          name: X-API-Key
+//          in: header
+//     bearer:
+//          type: apiKey
+//          name: Authorization
+//          in: header
+//     keystone:
+//          type: apiKey
+//          name: X-Auth-Token
+//          in: header
 //
 // swagger:meta
 package main
@@ -32,8 +54,6 @@ package main
 import (
 	"ossia/application"
 	"ossia/scheduler"
-
-	log "github.com/sirupsen/logrus"
 )
 
 // Reload is used for the call-back from
@@ -51,20 +71,16 @@ func main() {
 	service := application.NewService(app)
 	defer service.Run()
 
+	// Spread the first polls across deployments
+	var deployments []string
 	for deployment := range app.Config.Deployments {
-
-		log.WithFields(log.Fields{
-			"deployment": deployment,
-		}).Info("Registered new deployment")
-
-		go application.UpdateInventory(
-			deployment,
-			app.Config.PollInterval,
-		)
-
+		deployments = append(deployments, deployment)
 	}
+	application.ScheduleGlobalTasks()
+	application.StartDeployments(deployments)
 
 	go application.DataStoreMetrics()
+	go application.BuildSearchIndex()
 
 	scheduler.Run()
 
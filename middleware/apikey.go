@@ -0,0 +1,78 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+)
+
+// APIKey is a static key with the scopes granted to it
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+	Roles  []string
+}
+
+type apiKeyAuthenticator struct {
+	keys []APIKey
+}
+
+// NewAPIKeyAuthenticator validates the X-API-Key header.
+// The key is also accepted as Bearer token
+func NewAPIKeyAuthenticator(keys []APIKey) Authenticator {
+	return &apiKeyAuthenticator{keys: keys}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(ctx iris.Context) (*Identity, error) {
+	key := ctx.GetHeader("X-API-Key")
+	explicit := key != ""
+	if !explicit {
+		key = bearerToken(ctx)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	// Compare digests so the time does not depend on the key length
+	digest := sha256.Sum256([]byte(key))
+	for _, k := range a.keys {
+		expected := sha256.Sum256([]byte(k.Key))
+		if subtle.ConstantTimeCompare(digest[:], expected[:]) == 1 {
+			return &Identity{
+				Subject: k.Name,
+				Method:  "api_key",
+				Scopes:  k.Scopes,
+				Roles:   k.Roles,
+			}, nil
+		}
+	}
+
+	// Bearer token may be handled by another Authenticator
+	if !explicit {
+		return nil, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(ctx iris.Context) string {
+	header := ctx.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scopes of the API operations. ScopeAdmin grants all of them
+const (
+	ScopeRead    = "read"
+	ScopeRefresh = "refresh"
+	ScopeAdmin   = "admin"
+)
+
+const identityKey = "identity"
+
+// ErrInvalidCredentials is returned for unknown or expired credentials
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Identity is the authenticated API caller
+type Identity struct {
+	// Subject is the API key name or the token subject
+	Subject string
+	// Method is the authentication method (api_key, jwt, keystone)
+	Method string
+	// Scopes granted to the caller
+	Scopes []string
+	// Roles of the caller, used for authorization
+	Roles []string
+	// Keystone grants of the OpenStack token per deployment
+	Keystone []KeystoneGrant
+}
+
+// HasScope checks the scope is granted to the Identity
+func (i *Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator validates request credentials. Nil Identity
+// and nil error are returned if the request has no credentials
+// handled by the Authenticator
+type Authenticator interface {
+	Authenticate(ctx iris.Context) (*Identity, error)
+}
+
+// AuthOptions defines authentication of the API requests
+type AuthOptions struct {
+	// Authenticators returns the chain tried in order. It is
+	// called per request to follow configuration changes
+	Authenticators func() []Authenticator
+	// Public requests skip authentication
+	Public func(ctx iris.Context) bool
+	// Scope returns the scope required by the request
+	Scope func(ctx iris.Context) string
+	// Error responds the rejected request, a JSON message if nil
+	Error func(ctx iris.Context, status int, message string)
+}
+
+// Auth returns the handler authenticating API requests. Requests
+// without valid credentials get 401, without the required scope 403
+func Auth(opts AuthOptions) context.Handler {
+	return func(ctx iris.Context) {
+		if opts.Public != nil && opts.Public(ctx) {
+			ctx.Next()
+			return
+		}
+
+		var (
+			identity *Identity
+			err      error
+		)
+		for _, a := range opts.Authenticators() {
+			identity, err = a.Authenticate(ctx)
+			if identity != nil || err != nil {
+				break
+			}
+		}
+
+		if identity == nil {
+			message := "Authentication required"
+			if err != nil {
+				message = fmt.Sprintf("Authentication failed: %v", err)
+				log.WithFields(log.Fields{
+					"remote_addr": ctx.RemoteAddr(),
+					"path":        ctx.Path(),
+					"error":       err,
+				}).Warn("API authentication failed")
+			}
+			ctx.Header("WWW-Authenticate", `Bearer realm="ossia"`)
+			opts.reject(ctx, iris.StatusUnauthorized, message)
+			return
+		}
+
+		if opts.Scope != nil {
+			scope := opts.Scope(ctx)
+			if scope != "" && !identity.HasScope(scope) {
+				opts.reject(ctx, iris.StatusForbidden, fmt.Sprintf("Scope %s is required", scope))
+				return
+			}
+		}
+
+		ctx.Values().Set(identityKey, identity)
+		ctx.Next()
+	}
+}
+
+// reject responds the error and stops the request
+func (opts AuthOptions) reject(ctx iris.Context, status int, message string) {
+	if opts.Error != nil {
+		opts.Error(ctx, status, message)
+	} else {
+		ctx.StatusCode(status)
+		ctx.JSON(iris.Map{
+			"message": message,
+		})
+	}
+	ctx.StopExecution()
+}
+
+// GetIdentity returns the Identity of the authenticated request
+// or nil if the request is public
+func GetIdentity(ctx iris.Context) *Identity {
+	identity, _ := ctx.Values().Get(identityKey).(*Identity)
+	return identity
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	log "github.com/sirupsen/logrus"
+	jose "github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+)
+
+// JWT validation errors
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenAlgorithm = errors.New("unsupported token algorithm")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token is expired or not valid yet")
+	ErrTokenNoExpiry  = errors.New("token has no expiration time")
+	ErrTokenIssuer    = errors.New("invalid token issuer")
+	ErrTokenAudience  = errors.New("invalid token audience")
+	ErrUnknownKey     = errors.New("unknown token signing key")
+)
+
+// JWTOptions configures Bearer token validation. Signing keys are
+// read from JWKSFile or discovered from the Issuer (OpenID Connect).
+// The Audience is required, tokens issued for other clients are rejected
+type JWTOptions struct {
+	JWKSFile    string
+	Issuer      string
+	Audience    string
+	ScopesClaim string
+	RolesClaim  string
+	Leeway      time.Duration
+	// Refresh is the interval of JWKS refresh from the Issuer
+	Refresh time.Duration
+}
+
+type jwtAuthenticator struct {
+	opts JWTOptions
+	keys *keySet
+}
+
+// NewJWTAuthenticator validates RS*, PS* and ES* signed Bearer tokens
+func NewJWTAuthenticator(opts JWTOptions) (Authenticator, error) {
+	if opts.Audience == "" {
+		return nil, errors.New("token audience is required")
+	}
+	if opts.ScopesClaim == "" {
+		opts.ScopesClaim = "scope"
+	}
+	if opts.RolesClaim == "" {
+		opts.RolesClaim = "roles"
+	}
+	if opts.Refresh <= 0 {
+		opts.Refresh = time.Hour
+	}
+
+	keys := &keySet{
+		file:    opts.JWKSFile,
+		issuer:  opts.Issuer,
+		refresh: opts.Refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	err := keys.load()
+	if err != nil && opts.JWKSFile != "" {
+		return nil, err
+	}
+	if err != nil {
+		// The issuer may be temporarily unavailable
+		log.WithFields(log.Fields{
+			"issuer": opts.Issuer,
+			"error":  err,
+		}).Warn("Unable to fetch JWKS, retrying on request")
+	}
+	return &jwtAuthenticator{opts: opts, keys: keys}, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx iris.Context) (*Identity, error) {
+	token := bearerToken(ctx)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	claims, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &Identity{
+		Subject: subject,
+		Method:  "jwt",
+		Scopes:  claimStrings(claims[a.opts.ScopesClaim]),
+		Roles:   claimStrings(claims[a.opts.RolesClaim]),
+	}, nil
+}
+
+// verify checks the token signature with the key of its kid
+// and the registered claims at the given time
+func (a *jwtAuthenticator) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil || len(parsed.Headers) != 1 {
+		return nil, ErrTokenMalformed
+	}
+	header := parsed.Headers[0]
+
+	key, err := a.keys.get(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if !keyAllows(key, header.Algorithm) {
+		return nil, ErrTokenAlgorithm
+	}
+
+	var (
+		registered jwt.Claims
+		claims     map[string]interface{}
+	)
+	err = parsed.Claims(key.Key, &registered, &claims)
+	if err != nil {
+		return nil, ErrTokenSignature
+	}
+	return claims, a.validate(registered, now)
+}
+
+// validate checks exp, nbf, iss and aud claims. Tokens
+// without expiration time are rejected
+func (a *jwtAuthenticator) validate(claims jwt.Claims, now time.Time) error {
+	if claims.Expiry == nil {
+		return ErrTokenNoExpiry
+	}
+	err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   a.opts.Issuer,
+		Audience: jwt.Audience{a.opts.Audience},
+		Time:     now,
+	}, a.opts.Leeway)
+	switch err {
+	case nil:
+		return nil
+	case jwt.ErrInvalidIssuer:
+		return ErrTokenIssuer
+	case jwt.ErrInvalidAudience:
+		return ErrTokenAudience
+	}
+	return ErrTokenExpired
+}
+
+// curveAlgorithms are the ES* algorithms of the key curves
+var curveAlgorithms = map[elliptic.Curve]string{
+	elliptic.P256(): string(jose.ES256),
+	elliptic.P384(): string(jose.ES384),
+	elliptic.P521(): string(jose.ES512),
+}
+
+// keyAllows checks the token algorithm against the key type, its curve
+// and the algorithm of the JWKS entry if it is set
+func keyAllows(key jose.JSONWebKey, alg string) bool {
+	if key.Algorithm != "" && key.Algorithm != alg {
+		return false
+	}
+	switch k := key.Key.(type) {
+	case *rsa.PublicKey:
+		switch jose.SignatureAlgorithm(alg) {
+		case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		return curveAlgorithms[k.Curve] == alg
+	}
+	return false
+}
+
+// claimStrings returns the claim as a list. Space separated
+// strings (OAuth2 scope) are split
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// keySet caches the signing keys of the JWKS
+type keySet struct {
+	file    string
+	issuer  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu         sync.Mutex
+	keys       map[string]jose.JSONWebKey
+	fetched    time.Time
+	refreshing bool
+}
+
+// get returns the key by its ID. Keys are reloaded once they are
+// older than the refresh interval or at most every minute on unknown ID.
+// Known keys are served while they are reloaded in the background,
+// only the request reloading an unknown ID waits for the JWKS
+func (s *keySet) get(kid string) (jose.JSONWebKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetched)
+	reload := ((!ok && age > time.Minute) || age > s.refresh) && !s.refreshing
+	if reload {
+		s.refreshing = true
+	}
+	s.mu.Unlock()
+
+	if reload {
+		if ok {
+			go s.reload()
+			return key, nil
+		}
+		s.reload()
+		s.mu.Lock()
+		key, ok = s.keys[kid]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return jose.JSONWebKey{}, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (s *keySet) reload() {
+	err := s.load()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warn("Unable to refresh JWKS")
+	}
+}
+
+// load reads the JWKS without holding mu and replaces the keys.
+// The previous keys are kept on error
+func (s *keySet) load() error {
+	var (
+		raw []byte
+		err error
+	)
+	if s.file != "" {
+		raw, err = ioutil.ReadFile(s.file)
+	} else {
+		raw, err = s.fetchIssuerKeys()
+	}
+	var keys map[string]jose.JSONWebKey
+	if err == nil {
+		keys, err = parseJWKS(raw)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched = time.Now()
+	s.refreshing = false
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+// fetchIssuerKeys downloads JWKS using OpenID Connect discovery
+func (s *keySet) fetchIssuerKeys() ([]byte, error) {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+
+	raw, err := s.fetch(strings.TrimSuffix(s.issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(raw, &discovery)
+	if err != nil || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("invalid OpenID configuration of %s", s.issuer)
+	}
+	return s.fetch(discovery.JWKSURI)
+}
+
+func (s *keySet) fetch(url string) ([]byte, error) {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseJWKS returns RSA and EC public signing keys by their IDs.
+// Other keys are skipped
+func parseJWKS(raw []byte) (map[string]jose.JSONWebKey, error) {
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	err := json.Unmarshal(raw, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jose.JSONWebKey)
+	for _, entry := range jwks.Keys {
+		var k jose.JSONWebKey
+		if json.Unmarshal(entry, &k) != nil || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		switch key := k.Key.(type) {
+		case *rsa.PrivateKey:
+			k.Key = &key.PublicKey
+		case *ecdsa.PrivateKey:
+			k.Key = &key.PublicKey
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			continue
+		}
+		keys[k.KeyID] = k
+	}
+	return keys, nil
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jose "github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+)
+
+type testKeys struct {
+	rsa   *rsa.PrivateKey
+	ec256 *ecdsa.PrivateKey
+	ec384 *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec256: ec256, ec384: ec384}
+}
+
+// newTestAuthenticator writes the public keys to a JWKS file
+func newTestAuthenticator(t *testing.T, keys testKeys) *jwtAuthenticator {
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &keys.rsa.PublicKey, KeyID: "rsa", Use: "sig"},
+		{Key: &keys.rsa.PublicKey, KeyID: "rsa-pinned", Algorithm: "RS256", Use: "sig"},
+		{Key: &keys.ec256.PublicKey, KeyID: "ec256", Use: "sig"},
+		{Key: &keys.ec384.PublicKey, KeyID: "ec384", Use: "sig"},
+		{Key: &keys.rsa.PublicKey, KeyID: "rsa-enc", Use: "enc"},
+	}}
+	raw, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "jwks.json")
+	err = ioutil.WriteFile(file, raw, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewJWTAuthenticator(JWTOptions{
+		JWKSFile: file,
+		Issuer:   "https://idp.example.com",
+		Audience: "ossia",
+		Leeway:   time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.(*jwtAuthenticator)
+}
+
+func sign(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, kid string, claims interface{}) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// withSegment replaces the header (0) or the claims (1) of the token
+func withSegment(token string, i int, value string) string {
+	parts := strings.Split(token, ".")
+	parts[i] = base64.RawURLEncoding.EncodeToString([]byte(value))
+	return strings.Join(parts, ".")
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	a := newTestAuthenticator(t, keys)
+	now := time.Now()
+
+	claims := func(modify func(c *jwt.Claims)) map[string]interface{} {
+		c := jwt.Claims{
+			Subject:  "jdoe",
+			Issuer:   "https://idp.example.com",
+			Audience: jwt.Audience{"ossia"},
+			Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+		if modify != nil {
+			modify(&c)
+		}
+		raw, _ := json.Marshal(c)
+		var m map[string]interface{}
+		json.Unmarshal(raw, &m)
+		m["scope"] = "read refresh"
+		return m
+	}
+	valid := claims(nil)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256", sign(t, jose.RS256, keys.rsa, "rsa", valid), nil},
+		{"PS384", sign(t, jose.PS384, keys.rsa, "rsa", valid), nil},
+		{"ES256", sign(t, jose.ES256, keys.ec256, "ec256", valid), nil},
+		{"ES384", sign(t, jose.ES384, keys.ec384, "ec384", valid), nil},
+		{"pinned algorithm", sign(t, jose.RS256, keys.rsa, "rsa-pinned", valid), nil},
+		{"expired", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.Expiry = jwt.NewNumericDate(now.Add(-2 * time.Minute))
+		})), ErrTokenExpired},
+		{"expired within leeway", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.Expiry = jwt.NewNumericDate(now.Add(-30 * time.Second))
+		})), nil},
+		{"not valid yet", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Minute))
+		})), ErrTokenExpired},
+		{"no expiration", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.Expiry = nil
+		})), ErrTokenNoExpiry},
+		{"wrong audience", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.Audience = jwt.Audience{"other-client"}
+		})), ErrTokenAudience},
+		{"no audience", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.Audience = nil
+		})), ErrTokenAudience},
+		{"wrong issuer", sign(t, jose.RS256, keys.rsa, "rsa", claims(func(c *jwt.Claims) {
+			c.Issuer = "https://evil.example.com"
+		})), ErrTokenIssuer},
+		{"unknown kid", sign(t, jose.RS256, keys.rsa, "missing", valid), ErrUnknownKey},
+		{"encryption key", sign(t, jose.RS256, keys.rsa, "rsa-enc", valid), ErrUnknownKey},
+		{"key of other kid", sign(t, jose.ES256, keys.ec256, "ec384", valid), ErrTokenAlgorithm},
+		{"ES256 with P-384 key", withSegment(sign(t, jose.ES384, keys.ec384, "ec384", valid),
+			0, `{"alg":"ES256","kid":"ec384"}`), ErrTokenAlgorithm},
+		{"RS256 with EC key", withSegment(sign(t, jose.ES256, keys.ec256, "ec256", valid),
+			0, `{"alg":"RS256","kid":"ec256"}`), ErrTokenAlgorithm},
+		{"HS256 with RSA key", withSegment(sign(t, jose.RS256, keys.rsa, "rsa", valid),
+			0, `{"alg":"HS256","kid":"rsa"}`), ErrTokenAlgorithm},
+		{"none", withSegment(sign(t, jose.RS256, keys.rsa, "rsa", valid),
+			0, `{"alg":"none","kid":"rsa"}`), ErrTokenAlgorithm},
+		{"algorithm not pinned", sign(t, jose.PS256, keys.rsa, "rsa-pinned", valid), ErrTokenAlgorithm},
+		{"wrong signing key", sign(t, jose.ES256, keys.ec256, "rsa", valid), ErrTokenAlgorithm},
+		{"tampered claims", withSegment(sign(t, jose.RS256, keys.rsa, "rsa", valid),
+			1, `{"sub":"jdoe","scope":"admin"}`), ErrTokenSignature},
+		{"malformed", "a.b.c", ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.verify(tt.token, now)
+			if err != tt.err {
+				t.Fatalf("verify() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && claims["sub"] != "jdoe" {
+				t.Errorf("verify() sub = %v, want jdoe", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestJWTAudienceRequired(t *testing.T) {
+	_, err := NewJWTAuthenticator(JWTOptions{Issuer: "https://idp.example.com"})
+	if err == nil {
+		t.Fatal("NewJWTAuthenticator() without audience succeeded")
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	tests := []struct {
+		claim interface{}
+		want  []string
+	}{
+		{"read refresh", []string{"read", "refresh"}},
+		{[]interface{}{"read", 1, "admin"}, []string{"read", "admin"}},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		got := claimStrings(tt.claim)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("claimStrings(%v) = %v, want %v", tt.claim, got, tt.want)
+		}
+	}
+}
+
+func TestJWKSRefreshOutsideLock(t *testing.T) {
+	keys := newTestKeys(t)
+	raw, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &keys.rsa.PublicKey, KeyID: "rsa", Use: "sig"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked := make(chan bool, 1)
+	release := make(chan bool)
+	var block int32
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer close(release)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jwks_uri":"` + server.URL + `/jwks"}`))
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&block) == 1 {
+			blocked <- true
+			<-release
+		}
+		w.Write(raw)
+	})
+
+	a, err := NewJWTAuthenticator(JWTOptions{
+		Issuer:   server.URL,
+		Audience: "ossia",
+		Refresh:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := a.(*jwtAuthenticator).keys
+	if _, err := set.get("rsa"); err != nil {
+		t.Fatalf("get() = %v", err)
+	}
+
+	// The issuer hangs on the next refresh
+	atomic.StoreInt32(&block, 1)
+	time.Sleep(60 * time.Millisecond)
+
+	get := func(kid string) error {
+		result := make(chan error, 1)
+		go func() {
+			_, err := set.get(kid)
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(time.Second):
+			t.Fatalf("get(%s) blocked behind the JWKS refresh", kid)
+		}
+		return nil
+	}
+	if err := get("rsa"); err != nil {
+		t.Errorf("get() of cached key during refresh = %v", err)
+	}
+	<-blocked
+	if err := get("missing"); err != ErrUnknownKey {
+		t.Errorf("get() of unknown key during refresh = %v, want %v", err, ErrUnknownKey)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"golang.org/x/time/rate"
+)
+
+// KeystoneGrant is the access of an OpenStack token on a deployment
+type KeystoneGrant struct {
+	Deployment string
+	UserID     string
+	UserName   string
+	// ProjectIDs the user has roles in
+	ProjectIDs []string
+	// Roles of the token
+	Roles []string
+	// Admin is true if the token has one of the admin roles
+	Admin     bool
+	ExpiresAt time.Time
+}
+
+// ErrDeploymentRequired is returned for the tokens of requests
+// without a deployment to validate them against
+var ErrDeploymentRequired = errors.New("the deployment parameter is required for the token")
+
+// ErrValidationLimited is returned for uncached tokens once
+// the validation rate of the deployment is exceeded
+var ErrValidationLimited = errors.New("too many token validations")
+
+// KeystoneOptions configures X-Auth-Token validation
+type KeystoneOptions struct {
+	// Validate checks the token against the Keystone of the deployment.
+	// ErrInvalidCredentials is returned for tokens unknown to it
+	Validate func(deployment string, token string) (*KeystoneGrant, error)
+	// Deployments returns the deployments the request applies to,
+	// tokens of requests without any are rejected
+	Deployments func(ctx iris.Context) []string
+	// Scopes granted to the valid tokens
+	Scopes []string
+	// AdminRoles are Keystone roles granting access to all projects
+	AdminRoles []string
+	// CacheTTL limits how long the validation result is reused
+	CacheTTL time.Duration
+	// NegativeTTL limits how long rejected tokens are cached
+	NegativeTTL time.Duration
+	// CacheSize caps the cached results, rejected tokens are
+	// not cached once it is full. Not limited if 0
+	CacheSize int
+	// ValidationRate limits Keystone calls per second and deployment,
+	// with ValidationBurst. Not limited if 0
+	ValidationRate  float64
+	ValidationBurst int
+}
+
+type keystoneEntry struct {
+	grant   *KeystoneGrant
+	expires time.Time
+}
+
+type keystoneAuthenticator struct {
+	opts KeystoneOptions
+
+	mu        sync.Mutex
+	cache     map[string]keystoneEntry
+	lastSweep time.Time
+	limiters  map[string]*rate.Limiter
+}
+
+// NewKeystoneAuthenticator validates the X-Auth-Token header against
+// the Keystone of the requested deployments. The Identity gets
+// a KeystoneGrant per deployment accepting the token
+func NewKeystoneAuthenticator(opts KeystoneOptions) Authenticator {
+	return &keystoneAuthenticator{
+		opts:     opts,
+		cache:    make(map[string]keystoneEntry),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (a *keystoneAuthenticator) Authenticate(ctx iris.Context) (*Identity, error) {
+	token := ctx.GetHeader("X-Auth-Token")
+	if token == "" {
+		return nil, nil
+	}
+
+	deployments := a.opts.Deployments(ctx)
+	if len(deployments) == 0 {
+		return nil, ErrDeploymentRequired
+	}
+
+	var (
+		grants  []KeystoneGrant
+		lastErr error
+	)
+	for _, deployment := range deployments {
+		grant, err := a.grant(deployment, token)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if grant != nil {
+			grants = append(grants, *grant)
+		}
+	}
+
+	if len(grants) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("keystone: %v", lastErr)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Subject:  grants[0].UserName,
+		Method:   "keystone",
+		Scopes:   a.opts.Scopes,
+		Keystone: grants,
+	}, nil
+}
+
+// grant returns the cached validation result or validates the token.
+// Nil grant is returned if the deployment rejects the token
+func (a *keystoneAuthenticator) grant(deployment string, token string) (*KeystoneGrant, error) {
+	digest := sha256.Sum256([]byte(token))
+	key := deployment + ":" + hex.EncodeToString(digest[:])
+	now := time.Now()
+
+	a.mu.Lock()
+	entry, ok := a.cache[key]
+	limiter := a.limiter(deployment)
+	a.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.grant, nil
+	}
+	if limiter != nil && !limiter.Allow() {
+		return nil, ErrValidationLimited
+	}
+
+	grant, err := a.opts.Validate(deployment, token)
+	if err != nil && err != ErrInvalidCredentials {
+		// Keystone is unavailable, the result is not cached
+		return nil, err
+	}
+	if grant != nil {
+		grant.Admin = a.isAdmin(grant.Roles)
+	}
+
+	entry = keystoneEntry{grant: grant, expires: now.Add(a.opts.CacheTTL)}
+	if grant == nil {
+		if a.opts.NegativeTTL <= 0 {
+			return nil, nil
+		}
+		entry.expires = now.Add(a.opts.NegativeTTL)
+	}
+	if grant != nil && !grant.ExpiresAt.IsZero() && grant.ExpiresAt.Before(entry.expires) {
+		entry.expires = grant.ExpiresAt
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	full := a.opts.CacheSize > 0 && len(a.cache) >= a.opts.CacheSize
+	a.sweep(now, full)
+	if a.opts.CacheSize > 0 && len(a.cache) >= a.opts.CacheSize {
+		if grant == nil {
+			return nil, nil
+		}
+		// Valid tokens replace any entry
+		for k := range a.cache {
+			delete(a.cache, k)
+			break
+		}
+	}
+	a.cache[key] = entry
+	return grant, nil
+}
+
+// limiter returns the validation rate limiter of the deployment,
+// nil if not limited. mu must be locked
+func (a *keystoneAuthenticator) limiter(deployment string) *rate.Limiter {
+	if a.opts.ValidationRate <= 0 {
+		return nil
+	}
+	l, ok := a.limiters[deployment]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(a.opts.ValidationRate), a.opts.ValidationBurst)
+		a.limiters[deployment] = l
+	}
+	return l
+}
+
+// sweep drops expired cache entries once per CacheTTL,
+// or right away if forced by the full cache
+func (a *keystoneAuthenticator) sweep(now time.Time, force bool) {
+	if !force && now.Sub(a.lastSweep) < a.opts.CacheTTL {
+		return
+	}
+	a.lastSweep = now
+	for key, entry := range a.cache {
+		if !now.Before(entry.expires) {
+			delete(a.cache, key)
+		}
+	}
+}
+
+func (a *keystoneAuthenticator) isAdmin(roles []string) bool {
+	for _, role := range roles {
+		for _, admin := range a.opts.AdminRoles {
+			if role == admin {
+				return true
+			}
+		}
+	}
+	return false
+}
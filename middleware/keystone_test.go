@@ -0,0 +1,142 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+func TestKeystoneAuthenticate(t *testing.T) {
+	var validated []string
+	auth := NewKeystoneAuthenticator(KeystoneOptions{
+		Validate: func(deployment string, token string) (*KeystoneGrant, error) {
+			validated = append(validated, deployment)
+			if token != "valid" {
+				return nil, ErrInvalidCredentials
+			}
+			return &KeystoneGrant{Deployment: deployment, UserName: "alice", Roles: []string{"admin"}}, nil
+		},
+		Deployments: func(ctx iris.Context) []string {
+			if d := ctx.URLParam("deployment"); d != "" {
+				return []string{d}
+			}
+			return nil
+		},
+		Scopes:     []string{ScopeRead},
+		AdminRoles: []string{"admin"},
+		CacheTTL:   time.Minute,
+	})
+
+	tests := []struct {
+		name      string
+		url       string
+		token     string
+		err       error
+		validated []string
+	}{
+		{"no token", "/?deployment=lab", "", nil, nil},
+		{"no deployment", "/", "valid", ErrDeploymentRequired, nil},
+		{"valid", "/?deployment=lab", "valid", nil, []string{"lab"}},
+		{"cached", "/?deployment=lab", "valid", nil, nil},
+		{"invalid", "/?deployment=lab", "unknown", ErrInvalidCredentials, []string{"lab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validated = nil
+			var (
+				identity *Identity
+				err      error
+			)
+			app := iris.New()
+			app.Get("/", func(ctx iris.Context) { identity, err = auth.Authenticate(ctx) })
+			if buildErr := app.Build(); buildErr != nil {
+				t.Fatal(buildErr)
+			}
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("X-Auth-Token", tt.token)
+			}
+			app.ServeHTTP(httptest.NewRecorder(), req)
+
+			if err != tt.err {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.err)
+			}
+			if len(validated) != len(tt.validated) {
+				t.Errorf("validated against %v, want %v", validated, tt.validated)
+			}
+			if tt.err == nil && tt.token != "" {
+				if identity == nil || len(identity.Keystone) != 1 || !identity.Keystone[0].Admin {
+					t.Errorf("Authenticate() identity = %+v, want an admin grant", identity)
+				}
+			}
+		})
+	}
+}
+
+func TestKeystoneCacheLimits(t *testing.T) {
+	validations := 0
+	auth := NewKeystoneAuthenticator(KeystoneOptions{
+		Validate: func(deployment string, token string) (*KeystoneGrant, error) {
+			validations++
+			if token != "valid" {
+				return nil, ErrInvalidCredentials
+			}
+			return &KeystoneGrant{Deployment: deployment, UserName: "alice"}, nil
+		},
+		CacheTTL:        time.Minute,
+		NegativeTTL:     50 * time.Millisecond,
+		CacheSize:       2,
+		ValidationRate:  0.001,
+		ValidationBurst: 4,
+	}).(*keystoneAuthenticator)
+
+	tests := []struct {
+		name        string
+		deployment  string
+		token       string
+		valid       bool
+		err         error
+		validations int
+	}{
+		{"rejected", "lab", "bad-1", false, nil, 1},
+		{"rejected cached", "lab", "bad-1", false, nil, 1},
+		{"valid", "lab", "valid", true, nil, 2},
+		{"rejected with full cache", "lab", "bad-2", false, nil, 3},
+		{"rejected not cached", "lab", "bad-2", false, nil, 4},
+		{"rate limited", "lab", "bad-3", false, ErrValidationLimited, 4},
+		{"valid cached", "lab", "valid", true, nil, 4},
+		{"other deployment", "prod", "bad-3", false, nil, 5},
+	}
+	for _, tt := range tests {
+		grant, err := auth.grant(tt.deployment, tt.token)
+		if err != tt.err || (grant != nil) != tt.valid {
+			t.Errorf("%s: grant() = %v, %v", tt.name, grant, err)
+		}
+		if validations != tt.validations {
+			t.Errorf("%s: %d validations, want %d", tt.name, validations, tt.validations)
+		}
+		if len(auth.cache) > 2 {
+			t.Errorf("%s: %d cached results, want at most 2", tt.name, len(auth.cache))
+		}
+	}
+
+	// Rejected tokens expire after NegativeTTL
+	time.Sleep(60 * time.Millisecond)
+	if _, err := auth.grant("lab", "bad-1"); err != ErrValidationLimited {
+		t.Errorf("grant() of expired rejected token = %v, want %v", err, ErrValidationLimited)
+	}
+}
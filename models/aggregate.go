@@ -13,8 +13,6 @@ package models
 
 import (
 	"time"
-
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/aggregates"
 )
 
 // Aggregate represents the OpenStack Aggregate
@@ -54,14 +52,3 @@ type Aggregate struct {
 	// required: true
 	PollTime time.Time
 }
-
-// Exists method checking if flavor is in API Response Slice
-func (a *Aggregate) Exists(aggregates []aggregates.Aggregate) bool {
-	for _, v := range aggregates {
-		if v.ID == a.ID {
-			return true
-		}
-	}
-	return false
-
-}
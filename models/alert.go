@@ -0,0 +1,139 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// Alert states. Pending alerts fire once the rule matched
+// for its duration, firing ones resolve if it does not match
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert represents the alert rule matching the Inventory object
+//
+// swagger:model
+type Alert struct {
+	// the id for the alert (rule/deployment/resource/object)
+	//
+	// required: true
+	ID string
+	// the name of the rule
+	//
+	// required: true
+	Rule string
+	// the severity of the rule
+	//
+	// required: false
+	Severity string
+	// the state (pending, firing or resolved)
+	//
+	// required: true
+	State string
+	// the summary of the alert
+	//
+	// required: true
+	Summary string
+	// the deployment of the object
+	//
+	// required: true
+	Deployment string
+	// the resource type of the object
+	//
+	// required: true
+	Resource string
+	// the id for the object
+	//
+	// required: true
+	ObjectID string
+	// the name for the object
+	//
+	// required: true
+	Name string
+	// the projectID for instances and projects
+	//
+	// required: false
+	ProjectID string
+	// the time the rule started matching
+	//
+	// required: true
+	Since time.Time
+	// the time the alert fired
+	//
+	// required: false
+	Fired time.Time
+	// the time the alert resolved
+	//
+	// required: false
+	Resolved time.Time
+}
+
+// Capacity represents the usage of the enabled and up hypervisors
+// of the aggregate or the whole deployment, evaluated by alert rules
+//
+// swagger:model
+type Capacity struct {
+	// the aggregate ID or the deployment name
+	//
+	// required: true
+	ID string
+	// the aggregate or the deployment name
+	//
+	// required: true
+	Name string
+	// aggregate or deployment
+	//
+	// required: true
+	Type string
+	// Amount of Hypervisors
+	//
+	// required: true
+	Hypervisors int
+	// Total VCPUs
+	//
+	// required: true
+	VCPUs int
+	// Used VCPUs
+	//
+	// required: true
+	VCPUsUsed int
+	// Used VCPUs percent
+	//
+	// required: true
+	VCPUUsage float64
+	// Total Memory
+	//
+	// required: true
+	MemoryMB int
+	// Used Memory
+	//
+	// required: true
+	MemoryUsedMB int
+	// Used Memory percent
+	//
+	// required: true
+	MemoryUsage float64
+	// Total Disk
+	//
+	// required: true
+	DiskGB int
+	// Used Disk
+	//
+	// required: true
+	DiskUsedGB int
+	// Used Disk percent
+	//
+	// required: true
+	DiskUsage float64
+}
@@ -11,6 +11,8 @@ governing permissions and limitations under the License.
 
 package models
 
+import "time"
+
 // Configuration is the Global Object for the config file
 type Configuration struct {
 	ListenOn     string                `mapstructure:"listen_on"`
@@ -19,6 +21,17 @@ type Configuration struct {
 	LogFile      string                `mapstructure:"logfile"`
 	PollInterval PollInterval          `mapstructure:"poll_interval"`
 	AutoTLS      AutoTLS               `mapstructure:"auto_tls"`
+	APIClient    APIClient             `mapstructure:"api_client"`
+	Admin        Admin                 `mapstructure:"admin"`
+	Auth         Auth                  `mapstructure:"auth"`
+	RBAC         RBAC                  `mapstructure:"rbac"`
+	Compression  Compression           `mapstructure:"compression"`
+	CORS         CORS                  `mapstructure:"cors"`
+	Events       Events                `mapstructure:"events"`
+	Webhooks     Webhooks              `mapstructure:"webhooks"`
+	Alerts       Alerts                `mapstructure:"alerts"`
+	SMTP         SMTP                  `mapstructure:"smtp"`
+	Reports      []Report              `mapstructure:"reports"`
 	Deployments  map[string]Deployment `mapstructure:"deployments"`
 }
 
@@ -28,6 +41,9 @@ type Deployment struct {
 	OsProjectName string `mapstructure:"os_project_name"`
 	OsUsername    string `mapstructure:"os_username"`
 	OsPassword    string `mapstructure:"os_password"`
+	// RateLimit and Burst override the api_client ones if set
+	RateLimit float64 `mapstructure:"rate_limit"`
+	Burst     int     `mapstructure:"burst"`
 }
 
 // PollInterval intervals for the API calls.
@@ -39,6 +55,12 @@ type PollInterval struct {
 	Instances   string `maptstructure:"instances"`
 	Aggregates  string `maptstructure:"aggregates"`
 	Hypervisors string `maptstructure:"hypervisors"`
+	// FullReconcile is the interval of full Instances polling.
+	// Only changes are fetched in between
+	FullReconcile string `mapstructure:"full_reconcile"`
+	// CycleDeadline bounds a refresh of all resources
+	// of the deployment
+	CycleDeadline string `mapstructure:"cycle_deadline"`
 }
 
 // AutoTLS is used for Let's Encrypt integration
@@ -47,3 +69,201 @@ type AutoTLS struct {
 	Domain     string `mapstructure:"domain"`
 	AdminEmail string `mapstructure:"admin_email"`
 }
+
+// APIClient tunes OpenStack API calls. Timeout and RateLimit apply
+// to every HTTP request, Retries and Backoff to idempotent list calls only.
+// MaxPollers caps concurrent update tasks across all deployments
+type APIClient struct {
+	Timeout        time.Duration  `mapstructure:"timeout"`
+	Retries        int            `mapstructure:"retries"`
+	Backoff        time.Duration  `mapstructure:"backoff"`
+	MaxBackoff     time.Duration  `mapstructure:"max_backoff"`
+	Breaker        CircuitBreaker `mapstructure:"circuit_breaker"`
+	RateLimit      float64        `mapstructure:"rate_limit"`
+	Burst          int            `mapstructure:"burst"`
+	PageSize       int            `mapstructure:"page_size"`
+	MaxPollers     int            `mapstructure:"max_pollers"`
+	StartupStagger time.Duration  `mapstructure:"startup_stagger"`
+}
+
+// CircuitBreaker stops polling a deployment after Threshold
+// consecutive failures for the Cooldown period
+type CircuitBreaker struct {
+	Threshold int           `mapstructure:"threshold"`
+	Cooldown  time.Duration `mapstructure:"cooldown"`
+}
+
+// CORS configures the origins of browser clients. Credentials
+// (cookies, HTTP authentication) are only allowed for explicit origins
+type CORS struct {
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+}
+
+// Compression of the API responses (gzip or br, as accepted by
+// the client). Responses smaller than MinSize bytes are not compressed
+type Compression struct {
+	Enabled bool `mapstructure:"enabled"`
+	MinSize int  `mapstructure:"min_size"`
+}
+
+// Events configures the stream of Inventory changes. BufferSize
+// recent events are kept, so clients resume after reconnecting
+type Events struct {
+	BufferSize int `mapstructure:"buffer_size"`
+}
+
+// Webhooks configures the delivery of Inventory events and capacity
+// alerts. Failed requests are retried Retries times with exponential
+// Backoff, then kept as dead letters (up to DeadLetters). Deliveries
+// waiting for the workers beyond Backlog are dead-lettered, the oldest first
+type Webhooks struct {
+	Workers       int           `mapstructure:"workers"`
+	Backlog       int           `mapstructure:"backlog"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+	Retries       int           `mapstructure:"retries"`
+	Backoff       time.Duration `mapstructure:"backoff"`
+	DeadLetters   int           `mapstructure:"dead_letters"`
+	Subscriptions []Webhook     `mapstructure:"subscriptions"`
+}
+
+// Alerts configures the rules evaluated after every poll and snapshot.
+// Pending alerts are checked every Interval, resolved ones are kept
+// for KeepResolved. Channels are referenced by the rules
+type Alerts struct {
+	Interval     time.Duration           `mapstructure:"interval"`
+	KeepResolved time.Duration           `mapstructure:"keep_resolved"`
+	Rules        []AlertRule             `mapstructure:"rules"`
+	Channels     map[string]AlertChannel `mapstructure:"channels"`
+}
+
+// AlertRule fires for every object of the Resource matching the
+// Condition (filter expression) for the For duration. Summary is
+// a text/template of the alert and its object
+type AlertRule struct {
+	Name        string        `mapstructure:"name"`
+	Resource    string        `mapstructure:"resource"`
+	Condition   string        `mapstructure:"condition"`
+	For         time.Duration `mapstructure:"for"`
+	Severity    string        `mapstructure:"severity"`
+	Summary     string        `mapstructure:"summary"`
+	Deployments []string      `mapstructure:"deployments"`
+	Channels    []string      `mapstructure:"channels"`
+}
+
+// AlertChannel notifies firing and resolved alerts by webhook (URL,
+// Secret), smtp (To) or command (Command, Args, Timeout). Commands
+// read the alert as JSON from the standard input
+type AlertChannel struct {
+	Type    string        `mapstructure:"type"`
+	URL     string        `mapstructure:"url"`
+	Secret  string        `mapstructure:"secret"`
+	To      []string      `mapstructure:"to"`
+	Command string        `mapstructure:"command"`
+	Args    []string      `mapstructure:"args"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Report is the capacity report of the Deployments (glob patterns, all
+// if empty) rendered on Schedule (cron spec with seconds or @weekly) as
+// html, markdown or text. It is mailed To the recipients and/or written
+// to Directory. Sections are summary, top_projects, growth (over the
+// GrowthDays snapshots), empty_hypervisors and error_instances
+type Report struct {
+	Name        string   `mapstructure:"name"`
+	Schedule    string   `mapstructure:"schedule"`
+	Deployments []string `mapstructure:"deployments"`
+	Sections    []string `mapstructure:"sections"`
+	Format      string   `mapstructure:"format"`
+	Top         int      `mapstructure:"top"`
+	GrowthDays  int      `mapstructure:"growth_days"`
+	To          []string `mapstructure:"to"`
+	Subject     string   `mapstructure:"subject"`
+	Directory   string   `mapstructure:"directory"`
+}
+
+// SMTP is the mail server sending the alerts and reports.
+// Username enables PLAIN authentication
+type SMTP struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+}
+
+// Admin configures the deployment management API. SecretKey (base64
+// encoded AES key) encrypts the credentials stored in the datastore
+type Admin struct {
+	SecretKey string `mapstructure:"secret_key"`
+}
+
+// Auth configures API authentication. The API is open if neither
+// API keys, JWT nor Keystone are configured, except the admin endpoints
+type Auth struct {
+	SwaggerUI bool     `mapstructure:"swagger_ui"`
+	APIKeys   []APIKey `mapstructure:"api_keys"`
+	JWT       JWT      `mapstructure:"jwt"`
+	Keystone  Keystone `mapstructure:"keystone"`
+}
+
+// APIKey is a static key with granted scopes (read, refresh, admin)
+// and RBAC roles
+type APIKey struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+	Roles  []string `mapstructure:"roles"`
+}
+
+// JWT configures Bearer token validation with keys from
+// the local JWKS file or discovered from the issuer
+type JWT struct {
+	JWKSFile    string        `mapstructure:"jwks_file"`
+	Issuer      string        `mapstructure:"issuer"`
+	Audience    string        `mapstructure:"audience"`
+	ScopesClaim string        `mapstructure:"scopes_claim"`
+	RolesClaim  string        `mapstructure:"roles_claim"`
+	Leeway      time.Duration `mapstructure:"leeway"`
+}
+
+// Keystone configures pass-through of OpenStack tokens (X-Auth-Token)
+// validated against the Keystone of the deployment. Users with one of
+// AdminRoles see all projects, others the projects they have roles in.
+// Requests without a deployment are validated against the Home one.
+// Rejected tokens are cached for NegativeTTL, validations are limited
+// to ValidationRate per second and deployment
+type Keystone struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Home            string        `mapstructure:"home"`
+	Scopes          []string      `mapstructure:"scopes"`
+	AdminRoles      []string      `mapstructure:"admin_roles"`
+	CacheTTL        time.Duration `mapstructure:"cache_ttl"`
+	NegativeTTL     time.Duration `mapstructure:"negative_ttl"`
+	CacheSize       int           `mapstructure:"cache_size"`
+	ValidationRate  float64       `mapstructure:"validation_rate"`
+	ValidationBurst int           `mapstructure:"validation_burst"`
+}
+
+// RBAC restricts API identities to the deployments, projects and
+// operations of their roles. Roles come from API keys, the JWT
+// roles claim and Bindings. Access is not restricted without Roles
+type RBAC struct {
+	Roles    map[string]Role `mapstructure:"roles"`
+	Bindings []Binding       `mapstructure:"bindings"`
+}
+
+// Role allows Operations (read, refresh, admin) on the Deployments
+// and Projects (names or IDs). Glob patterns are supported,
+// all projects are allowed if Projects is empty
+type Role struct {
+	Deployments []string `mapstructure:"deployments"`
+	Projects    []string `mapstructure:"projects"`
+	Operations  []string `mapstructure:"operations"`
+}
+
+// Binding grants Roles to the API identity (API key name or JWT subject)
+type Binding struct {
+	Subject string   `mapstructure:"subject"`
+	Roles   []string `mapstructure:"roles"`
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// Event types of the Inventory changes
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// Event represents an Inventory object change found by a poll
+//
+// swagger:model
+type Event struct {
+	// the sequence number, used to resume the stream
+	//
+	// required: true
+	ID uint64
+	// the change type (created, updated or deleted)
+	//
+	// required: true
+	Type string
+	// the time of the change
+	//
+	// required: true
+	Time time.Time
+	// the deployment of the object
+	//
+	// required: true
+	Deployment string
+	// the resource type (instances, images, flavors, projects, aggregates or hypervisors)
+	//
+	// required: true
+	Resource string
+	// the id for the object
+	//
+	// required: true
+	ObjectID string
+	// the name for the object
+	//
+	// required: true
+	Name string
+	// the projectID for instances and projects
+	//
+	// required: false
+	ProjectID string
+	// the changed fields. Created objects have new values only,
+	// deleted objects old values only
+	//
+	// required: false
+	Changes map[string]Change
+}
+
+// Change represents the old and new value of an object field
+//
+// swagger:model
+type Change struct {
+	// the value before the change
+	Old interface{}
+	// the value after the change
+	New interface{}
+}
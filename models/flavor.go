@@ -13,8 +13,6 @@ package models
 
 import (
 	"time"
-
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 )
 
 // Flavor represents the OpenStack Flavor
@@ -62,14 +60,3 @@ type Flavor struct {
 	// required: true
 	PollTime time.Time
 }
-
-// Exists method checking if flavor is in API Response Slice
-func (f *Flavor) Exists(flavors []flavors.Flavor) bool {
-	for _, v := range flavors {
-		if v.ID == f.ID {
-			return true
-		}
-	}
-	return false
-
-}
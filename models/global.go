@@ -0,0 +1,135 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+// Usage represents the inventory and capacity of one or
+// several deployments. Capacity counts enabled hypervisors
+// in up state only
+//
+// swagger:model
+type Usage struct {
+	// Amount of Instances
+	//
+	// required: true
+	Instances int
+	// Amount of Instances per status
+	//
+	// required: true
+	InstancesByStatus map[string]int
+	// Amount of Projects
+	//
+	// required: true
+	Projects int
+	// Amount of Hypervisors
+	//
+	// required: true
+	Hypervisors int
+	// Amount of Images
+	//
+	// required: true
+	Images int
+	// Amount of Flavors
+	//
+	// required: true
+	Flavors int
+	// Total VCPUs
+	//
+	// required: true
+	VCPUs int
+	// vCPU Usage
+	//
+	// required: true
+	VCPUsUsed int
+	// Total Memory
+	//
+	// required: true
+	MemoryMB int
+	// Memory Usage
+	//
+	// required: true
+	MemoryUsedMB int
+	// Total Disk
+	//
+	// required: true
+	DiskGB int
+	// Disk Usage
+	//
+	// required: true
+	DiskUsedGB int
+}
+
+// Add sums the usage of another deployment
+func (u *Usage) Add(o Usage) {
+	if u.InstancesByStatus == nil {
+		u.InstancesByStatus = make(map[string]int)
+	}
+	for status, n := range o.InstancesByStatus {
+		u.InstancesByStatus[status] += n
+	}
+	u.Instances += o.Instances
+	u.Projects += o.Projects
+	u.Hypervisors += o.Hypervisors
+	u.Images += o.Images
+	u.Flavors += o.Flavors
+	u.VCPUs += o.VCPUs
+	u.VCPUsUsed += o.VCPUsUsed
+	u.MemoryMB += o.MemoryMB
+	u.MemoryUsedMB += o.MemoryUsedMB
+	u.DiskGB += o.DiskGB
+	u.DiskUsedGB += o.DiskUsedGB
+}
+
+// FlavorCatalogEntry represents a flavor and the deployments
+// defining it. Flavors are merged by name and size
+//
+// swagger:model
+type FlavorCatalogEntry struct {
+	// the name for the flavor
+	//
+	// required: true
+	Name string
+	// the amount of VCPUs
+	//
+	// required: true
+	VCPUs int
+	// the amount of RAM (MB)
+	//
+	// required: true
+	RAM int
+	// the disk size (GB)
+	//
+	// required: true
+	Disk int
+	// the flavor id per deployment
+	//
+	// required: true
+	Deployments map[string]string
+}
+
+// ImageCatalogEntry represents an image and the deployments
+// providing it. Images are merged by name
+//
+// swagger:model
+type ImageCatalogEntry struct {
+	// the name for the image
+	//
+	// required: true
+	Name string
+	// the image id per deployment
+	//
+	// required: true
+	Deployments map[string]string
+	// the amount of instances using the image
+	//
+	// required: true
+	Instances int
+}
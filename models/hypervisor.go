@@ -12,7 +12,6 @@ governing permissions and limitations under the License.
 package models
 
 import (
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/hypervisors"
 	"time"
 )
 
@@ -106,14 +105,3 @@ type EmptyHypervisor struct {
 	// required: true
 	TotalRAMMB int
 }
-
-// Exists method checking if hypervisor is in API Response Slice
-func (h *Hypervisor) Exists(hypervisors []hypervisors.Hypervisor) bool {
-	for _, v := range hypervisors {
-		if v.ID == h.ID {
-			return true
-		}
-	}
-	return false
-
-}
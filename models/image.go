@@ -13,8 +13,6 @@ package models
 
 import (
 	"time"
-
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
 )
 
 // Image represents the OpenStack Glance Image
@@ -54,14 +52,3 @@ type Image struct {
 	// required: true
 	PollTime time.Time
 }
-
-// Exists method checking if image is in API Response Slice
-func (i *Image) Exists(images []images.Image) bool {
-	for _, v := range images {
-		if v.ID == i.ID {
-			return true
-		}
-	}
-	return false
-
-}
@@ -14,8 +14,6 @@ package models
 import (
 	"fmt"
 	"time"
-
-	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
 )
 
 // Instance represents the OpenStack Instance
@@ -70,6 +68,10 @@ type Instance struct {
 	//
 	// required: true
 	FloatingIPv6 string
+	// the addresses of all the instance NICs, grouped by network
+	//
+	// required: false
+	Addresses []InstanceAddresses
 	// the metadata for the instance
 	//
 	// required: true
@@ -112,17 +114,6 @@ type InstanceAddresses struct {
 	InstanceNICs []InstanceNIC
 }
 
-// Exists method checking if instance is in API Response Slice
-func (i *Instance) Exists(instances []servers.Server) bool {
-	for _, v := range instances {
-		if v.ID == i.ID {
-			return true
-		}
-	}
-	return false
-
-}
-
 // GetInstanceAddresses metod extracs IPAddresses from the API Instance Object
 func GetInstanceAddresses(addresses map[string]interface{}) []InstanceAddresses {
 	var allInstanceAddresses []InstanceAddresses
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// Job and Collector statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobSkipped   = "skipped"
+)
+
+// Job represents an asynchronous update of the deployment
+//
+// swagger:model
+type Job struct {
+	// the id for the job
+	//
+	// required: true
+	ID string
+	// the deployment to update
+	//
+	// required: true
+	Deployment string
+	// the collectors to run (all if empty)
+	//
+	// required: false
+	Collectors []string
+	// the status of the job (queued, running, succeeded, failed)
+	//
+	// required: true
+	Status string
+	// the time of the job creation
+	//
+	// required: true
+	Created time.Time
+	// the time the job was started
+	//
+	// required: false
+	Started time.Time
+	// the time the job was finished
+	//
+	// required: false
+	Finished time.Time
+	// the number of merged duplicate requests
+	//
+	// required: true
+	Merged int
+	// the results per collector
+	//
+	// required: true
+	Results []CollectorResult
+}
+
+// CollectorResult represents the Collector run within the Job
+//
+// swagger:model
+type CollectorResult struct {
+	// the collector name
+	//
+	// required: true
+	Name string
+	// the status of the collector
+	//
+	// required: true
+	Status string
+	// the error message
+	//
+	// required: false
+	Error string
+	// the time the collector was started
+	//
+	// required: false
+	Started time.Time
+	// the duration of the collector run
+	//
+	// required: false
+	Duration string
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// ManagedDeployment is the OpenStack deployment registered
+// via API. It is stored in the datastore with encrypted password
+//
+// swagger:model
+type ManagedDeployment struct {
+	// the name for the deployment
+	//
+	// required: true
+	Name string `storm:"id" json:"name"`
+	// the Keystone URL
+	//
+	// required: true
+	OsAuthURL string `json:"os_auth_url"`
+	// the project name of the service account
+	//
+	// required: true
+	OsProjectName string `json:"os_project_name"`
+	// the username of the service account
+	//
+	// required: true
+	OsUsername string `json:"os_username"`
+	// the password of the service account (write only)
+	//
+	// required: false
+	OsPassword string `json:"os_password,omitempty"`
+	// the deployment is not polled if disabled
+	//
+	// required: false
+	Disabled bool `json:"disabled"`
+	// the time the deployment was registered
+	//
+	// required: false
+	Created time.Time `json:"created"`
+	// the time the deployment was updated
+	//
+	// required: false
+	Updated time.Time `json:"updated"`
+	// the encrypted password (never returned by API)
+	//
+	// required: false
+	Secret string `json:"secret,omitempty"`
+}
+
+// Redacted returns the deployment without credentials
+func (d ManagedDeployment) Redacted() ManagedDeployment {
+	d.OsPassword = ""
+	d.Secret = ""
+	return d
+}
+
+// Deployment returns the credentials stanza
+func (d ManagedDeployment) Deployment(password string) Deployment {
+	return Deployment{
+		OsAuthURL:     d.OsAuthURL,
+		OsProjectName: d.OsProjectName,
+		OsUsername:    d.OsUsername,
+		OsPassword:    password,
+	}
+}
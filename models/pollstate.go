@@ -0,0 +1,26 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// PollState keeps incremental polling progress
+// of the resource type for the deployment
+// swagger:ignore
+type PollState struct {
+	// the resource type (collector name)
+	Resource string `storm:"id"`
+	// the high-water mark for the changes-since filter
+	ChangesSince time.Time
+	// the time of the last full reconciliation
+	LastFullSync time.Time
+}
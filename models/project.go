@@ -13,8 +13,6 @@ package models
 
 import (
 	"time"
-
-	"github.com/gophercloud/gophercloud/openstack/identity/v3/projects"
 )
 
 // Project represents the OpenStack Project (Tenant)
@@ -24,11 +22,11 @@ type Project struct {
 	// the id for the project
 	//
 	// required: true
-	ID string `storm:"index"`
+	ID string `storm:"id"`
 	// the name for the project
 	//
 	// required: true
-	Name string
+	Name string `storm:"index"`
 	// the status for the project
 	//
 	// required: true
@@ -42,14 +40,3 @@ type Project struct {
 	// required: true
 	PollTime time.Time
 }
-
-// Exists method checking if project is in API Response Slice
-func (p *Project) Exists(projects []projects.Project) bool {
-	for _, v := range projects {
-		if v.ID == p.ID {
-			return true
-		}
-	}
-	return false
-
-}
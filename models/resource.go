@@ -0,0 +1,39 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "strconv"
+
+// Resource is an Inventory object collected from OpenStack API
+// swagger:ignore
+type Resource interface {
+	// Key returns the unique id of the object
+	Key() string
+}
+
+// Key returns the instance id
+func (i *Instance) Key() string { return i.ID }
+
+// Key returns the image id
+func (i *Image) Key() string { return i.ID }
+
+// Key returns the hypervisor id
+func (h *Hypervisor) Key() string { return h.ID }
+
+// Key returns the flavor id
+func (f *Flavor) Key() string { return f.ID }
+
+// Key returns the project id
+func (p *Project) Key() string { return p.ID }
+
+// Key returns the aggregate id
+func (a *Aggregate) Key() string { return strconv.Itoa(a.ID) }
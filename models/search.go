@@ -0,0 +1,50 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+// SearchHit represents an Inventory object matching the search
+//
+// swagger:model
+type SearchHit struct {
+	// the resource type (instance, image, flavor, project, aggregate or hypervisor)
+	//
+	// required: true
+	Type string
+	// the deployment of the resource
+	//
+	// required: true
+	Deployment string
+	// the id for the resource
+	//
+	// required: true
+	ID string
+	// the name for the resource
+	//
+	// required: true
+	Name string
+	// the projectID for instances and projects
+	//
+	// required: false
+	ProjectID string
+	// the matched field, such as id, name, fixed_ipv4, mac or metadata.<key>
+	//
+	// required: true
+	Field string
+	// the matched value
+	//
+	// required: true
+	Value string
+	// the detail endpoint of the resource
+	//
+	// required: true
+	Link string
+}
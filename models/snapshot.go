@@ -31,11 +31,11 @@ type Snapshot struct {
 	//
 	// required: true
 	Images int
-	// Amount of Instances
+	// Amount of Instances, 0 for callers restricted to some projects
 	//
 	// required: true
 	Instances int
-	// Amount of Projects
+	// Amount of Projects, 0 for callers restricted to some projects
 	//
 	// required: true
 	Projects int
@@ -73,3 +73,16 @@ func (s *Snapshot) Public() interface{} {
 	}
 
 }
+
+// Add sums the snapshot of another deployment
+func (s *Snapshot) Add(o Snapshot) {
+	s.Flavors += o.Flavors
+	s.Hypervisors += o.Hypervisors
+	s.Images += o.Images
+	s.Instances += o.Instances
+	s.Projects += o.Projects
+	s.VCPUs += o.VCPUs
+	s.VCPUsUsed += o.VCPUsUsed
+	s.MemoryMB += o.MemoryMB
+	s.MemoryUsedMB += o.MemoryUsedMB
+}
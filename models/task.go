@@ -0,0 +1,71 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// Scheduled Task statuses
+const (
+	TaskScheduled = "scheduled"
+	TaskRunning   = "running"
+	TaskPaused    = "paused"
+)
+
+// ScheduledTask represents a periodic task of the scheduler
+//
+// swagger:model
+type ScheduledTask struct {
+	// the name for the task
+	//
+	// required: true
+	Name string
+	// the deployment of the task (empty for global tasks)
+	//
+	// required: false
+	Deployment string
+	// the resource type of the task
+	//
+	// required: false
+	Resource string
+	// the cron spec of the task
+	//
+	// required: true
+	Schedule string
+	// the status of the task (scheduled, running, paused)
+	//
+	// required: true
+	Status string
+	// the time the last run was started
+	//
+	// required: false
+	LastRun time.Time
+	// the time of the next run
+	//
+	// required: false
+	NextRun time.Time
+	// the duration of the last run
+	//
+	// required: false
+	LastDuration string
+	// the error of the last run
+	//
+	// required: false
+	LastError string
+	// the number of runs
+	//
+	// required: true
+	Runs int
+	// the number of runs skipped while the previous one was in progress
+	//
+	// required: true
+	Skipped int
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook sources
+const (
+	WebhookFromConfig = "config"
+	WebhookFromAPI    = "api"
+)
+
+// Webhook is the subscription delivering Inventory events and
+// capacity alerts as signed JSON POST requests. It is defined
+// in the config file or registered via API
+//
+// swagger:model
+type Webhook struct {
+	// the name for the webhook
+	//
+	// required: true
+	Name string `storm:"id" json:"name" mapstructure:"name"`
+	// the URL receiving the events
+	//
+	// required: true
+	URL string `json:"url" mapstructure:"url"`
+	// the key signing the payloads with HMAC-SHA256 (write only)
+	//
+	// required: false
+	Secret string `json:"secret,omitempty" mapstructure:"secret"`
+	// the event names, glob patterns are supported
+	//
+	// required: true
+	// example: ["instance.deleted", "hypervisor.*", "capacity.above"]
+	Events []string `json:"events" mapstructure:"events"`
+	// the deployments, glob patterns are supported. All if empty
+	//
+	// required: false
+	Deployments []string `json:"deployments" mapstructure:"deployments"`
+	// the capacity usage percent firing capacity.above events
+	//
+	// required: false
+	Threshold float64 `json:"threshold" mapstructure:"threshold"`
+	// the source of the webhook (config or api)
+	//
+	// required: false
+	Source string `json:"source" mapstructure:"-"`
+	// the time the webhook was registered
+	//
+	// required: false
+	Created time.Time `json:"created" mapstructure:"-"`
+	// the encrypted secret (never returned by API)
+	//
+	// required: false
+	SealedSecret string `json:"sealed_secret,omitempty" mapstructure:"-"`
+}
+
+// Redacted returns the webhook without the secret
+func (w Webhook) Redacted() Webhook {
+	w.Secret = ""
+	w.SealedSecret = ""
+	return w
+}
+
+// WebhookPayload is the body of the webhook request
+//
+// swagger:model
+type WebhookPayload struct {
+	// the delivery ID, the same for retries
+	//
+	// required: true
+	ID string `json:"id"`
+	// the event name
+	//
+	// required: true
+	// example: hypervisor.down
+	Event string `json:"event"`
+	// the time of the event
+	//
+	// required: true
+	Time time.Time `json:"time"`
+	// the deployment of the event
+	//
+	// required: true
+	Deployment string `json:"deployment"`
+	// the name of the webhook
+	//
+	// required: true
+	Webhook string `json:"webhook"`
+	// the Inventory change
+	//
+	// required: false
+	Change *Event `json:"change,omitempty"`
+	// the capacity usage above the threshold
+	//
+	// required: false
+	Capacity *CapacityAlert `json:"capacity,omitempty"`
+	// the firing or resolved alert
+	//
+	// required: false
+	Alert *Alert `json:"alert,omitempty"`
+}
+
+// CapacityAlert represents the capacity usage crossing the threshold
+//
+// swagger:model
+type CapacityAlert struct {
+	// the capacity (vcpus, memory or disk)
+	//
+	// required: true
+	Metric string `json:"metric"`
+	// the used capacity
+	//
+	// required: true
+	Used int `json:"used"`
+	// the total capacity of the enabled hypervisors
+	//
+	// required: true
+	Total int `json:"total"`
+	// the usage percent
+	//
+	// required: true
+	Percent float64 `json:"percent"`
+	// the threshold of the webhook
+	//
+	// required: true
+	Threshold float64 `json:"threshold"`
+}
+
+// DeadLetter is the webhook delivery failed after all retries
+//
+// swagger:model
+type DeadLetter struct {
+	// the delivery ID
+	//
+	// required: true
+	ID string `storm:"id" json:"id"`
+	// the name of the webhook
+	//
+	// required: true
+	Webhook string `storm:"index" json:"webhook"`
+	// the event name
+	//
+	// required: true
+	Event string `json:"event"`
+	// the URL of the webhook
+	//
+	// required: true
+	URL string `json:"url"`
+	// the request body
+	//
+	// required: true
+	Payload json.RawMessage `json:"payload"`
+	// the amount of delivery attempts
+	//
+	// required: true
+	Attempts int `json:"attempts"`
+	// the error of the last attempt
+	//
+	// required: true
+	Error string `json:"error"`
+	// the time of the last attempt
+	//
+	// required: true
+	Failed time.Time `storm:"index" json:"failed"`
+}
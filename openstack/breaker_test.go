@@ -0,0 +1,111 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package openstack
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(3, 50*time.Millisecond)
+	failure := errors.New("connection refused")
+
+	for i := 0; i < 2; i++ {
+		b.Failure(failure)
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow() after %d failures = %v, want nil", i+1, err)
+		}
+	}
+	b.Success()
+	if s := b.Status(); s.Failures != 0 || s.LastError != "" {
+		t.Fatalf("Status() after success = %+v, want reset", s)
+	}
+
+	for i := 0; i < 3; i++ {
+		b.Failure(failure)
+	}
+	if err := b.Allow(); err != ErrBreakerOpen {
+		t.Fatalf("Allow() after threshold = %v, want %v", err, ErrBreakerOpen)
+	}
+	s := b.Status()
+	if s.State != BreakerOpen || s.LastError != failure.Error() || s.RetryAt.Sub(s.OpenedAt) != 50*time.Millisecond {
+		t.Fatalf("Status() = %+v, want open", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after cooldown = %v, want trial call", err)
+	}
+	if err := b.Allow(); err != ErrBreakerOpen {
+		t.Fatalf("Allow() during trial call = %v, want %v", err, ErrBreakerOpen)
+	}
+
+	// Failed trial call opens the breaker again
+	b.Failure(failure)
+	if b.Status().State != BreakerOpen {
+		t.Fatalf("State after failed trial = %s, want %s", b.Status().State, BreakerOpen)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	b.Allow()
+	b.Success()
+	if s := b.Status(); s.State != BreakerClosed || !s.OpenedAt.IsZero() {
+		t.Fatalf("Status() after successful trial = %+v, want closed", s)
+	}
+}
+
+func TestBreakerWithoutThreshold(t *testing.T) {
+	b := NewBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		b.Failure(errors.New("timeout"))
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() = %v, disabled breaker must not open", err)
+	}
+}
+
+func TestBreakerAbort(t *testing.T) {
+	b := NewBreaker(1, 50*time.Millisecond)
+	b.Failure(errors.New("timeout"))
+
+	// Abort of a closed or open breaker changes nothing
+	b.Abort()
+	if s := b.Status(); s.State != BreakerOpen || s.Failures != 1 {
+		t.Fatalf("Status() after abort = %+v, want open", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after cooldown = %v, want trial call", err)
+	}
+
+	// Cancelled trial call opens the breaker for another cooldown
+	b.Abort()
+	s := b.Status()
+	if s.State != BreakerOpen || s.Failures != 1 {
+		t.Fatalf("Status() after cancelled trial = %+v, want open", s)
+	}
+	if err := b.Allow(); err != ErrBreakerOpen {
+		t.Fatalf("Allow() after cancelled trial = %v, want %v", err, ErrBreakerOpen)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after next cooldown = %v, want trial call", err)
+	}
+	b.Success()
+	if b.Status().State != BreakerClosed {
+		t.Fatalf("State after successful trial = %s, want %s", b.Status().State, BreakerClosed)
+	}
+}
@@ -12,12 +12,24 @@ governing permissions and limitations under the License.
 package openstack
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
-func initOpenStackProvider(IdentityEndpoint string, Username string, Password string, TenantName string) *gophercloud.ProviderClient {
+// ClientOptions are applied to the HTTP client
+// of every OpenStack Provider
+type ClientOptions struct {
+	Timeout time.Duration
+	Limiter *rate.Limiter
+}
+
+func initOpenStackProvider(IdentityEndpoint string, Username string, Password string, TenantName string, clientOpts ClientOptions) *gophercloud.ProviderClient {
 	opts := gophercloud.AuthOptions{
 		IdentityEndpoint: IdentityEndpoint,
 		Username:         Username,
@@ -28,20 +40,38 @@ func initOpenStackProvider(IdentityEndpoint string, Username string, Password st
 	opts.DomainName = "default"
 	opts.AllowReauth = true
 
-	provider, err := openstack.AuthenticatedClient(opts)
+	provider, err := openstack.NewClient(IdentityEndpoint)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error": err,
+		}).Error("Could not create OpenStack Provider.")
+		return nil
+	}
+	provider.HTTPClient = http.Client{
+		Timeout: clientOpts.Timeout,
+	}
+	if clientOpts.Limiter != nil {
+		provider.HTTPClient.Transport = &rateLimitedTransport{
+			limiter: clientOpts.Limiter,
+			next:    http.DefaultTransport,
+		}
+	}
+
+	err = openstack.Authenticate(provider, opts)
 	if err != nil {
 		log.WithFields(log.Fields{
 			//"URL":   IdentityEndpoint,
 			"Error": err,
 		}).Error("Could not create OpenStack Provider.")
+		return nil
 	}
 	return provider
 }
 
 // ComputeConnection initializes Nova API Connection
-func ComputeConnection(IdentityEndpoint string, Username string, Password string, TenantName string) *gophercloud.ServiceClient {
+func ComputeConnection(IdentityEndpoint string, Username string, Password string, TenantName string, clientOpts ClientOptions) *gophercloud.ServiceClient {
 
-	provider := initOpenStackProvider(IdentityEndpoint, Username, Password, TenantName)
+	provider := initOpenStackProvider(IdentityEndpoint, Username, Password, TenantName, clientOpts)
 	if provider == nil {
 		//need to fetch complete error at provider initialisation
 		return nil
@@ -60,9 +90,9 @@ func ComputeConnection(IdentityEndpoint string, Username string, Password string
 }
 
 // IdentityConnection initializes Keystone API Connection
-func IdentityConnection(IdentityEndpoint string, Username string, Password string, TenantName string) *gophercloud.ServiceClient {
+func IdentityConnection(IdentityEndpoint string, Username string, Password string, TenantName string, clientOpts ClientOptions) *gophercloud.ServiceClient {
 
-	provider := initOpenStackProvider(IdentityEndpoint, Username, Password, TenantName)
+	provider := initOpenStackProvider(IdentityEndpoint, Username, Password, TenantName, clientOpts)
 	if provider == nil {
 		//need to fetch complete error at provider initialisation
 		return nil
@@ -79,3 +109,33 @@ func IdentityConnection(IdentityEndpoint string, Username string, Password strin
 	return client
 
 }
+
+// WithContext returns a Service Client which binds its HTTP requests
+// to ctx. The token stays with the shared Provider: a reauthentication
+// triggered by a request refreshes it for every other caller as well.
+func WithContext(ctx context.Context, client *gophercloud.ServiceClient) *gophercloud.ServiceClient {
+	shared := client.ProviderClient
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:     shared.IdentityBase,
+		IdentityEndpoint: shared.IdentityEndpoint,
+		EndpointLocator:  shared.EndpointLocator,
+		HTTPClient:       shared.HTTPClient,
+		UserAgent:        shared.UserAgent,
+		Context:          ctx,
+	}
+	provider.UseTokenLock()
+	provider.CopyTokenFrom(shared)
+	if shared.ReauthFunc != nil {
+		provider.ReauthFunc = func() error {
+			if err := shared.Reauthenticate(provider.Token()); err != nil {
+				return err
+			}
+			provider.CopyTokenFrom(shared)
+			return nil
+		}
+	}
+
+	sc := *client
+	sc.ProviderClient = provider
+	return &sc
+}
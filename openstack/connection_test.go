@@ -0,0 +1,77 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestWithContextReauth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Auth-Token") != "fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shared := new(gophercloud.ProviderClient)
+	shared.UseTokenLock()
+	shared.SetToken("stale")
+	reauths := 0
+	shared.ReauthFunc = func() error {
+		reauths++
+		shared.SetToken("fresh")
+		return nil
+	}
+	client := &gophercloud.ServiceClient{ProviderClient: shared, Endpoint: server.URL + "/"}
+
+	for i := 0; i < 2; i++ {
+		sc := WithContext(context.Background(), client)
+		if _, err := sc.Get(sc.ServiceURL("servers"), nil, nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if reauths != 1 {
+		t.Errorf("reauthenticated %d times, want 1", reauths)
+	}
+	if token := shared.Token(); token != "fresh" {
+		t.Errorf("shared token = %q, want fresh", token)
+	}
+}
+
+func TestWithContextCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	shared := new(gophercloud.ProviderClient)
+	shared.UseTokenLock()
+	client := &gophercloud.ServiceClient{ProviderClient: shared, Endpoint: server.URL + "/"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sc := WithContext(ctx, client)
+	if _, err := sc.Get(sc.ServiceURL("servers"), nil, nil); err == nil {
+		t.Error("request with a cancelled context succeeded")
+	}
+	if shared.Context != nil {
+		t.Error("WithContext changed the shared provider")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+	log "github.com/sirupsen/logrus"
+)
+
+// RetryOptions controls retries of the idempotent API calls
+type RetryOptions struct {
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Retry executes call and repeats it on retryable errors with
+// an exponential backoff and full jitter between the attempts.
+// Retries stop once ctx is done
+func Retry(ctx context.Context, opts RetryOptions, call func() error) error {
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		err = call()
+		if err == nil || !Retryable(err) || attempt >= opts.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff(opts, attempt)
+		log.WithFields(log.Fields{
+			"attempt": attempt + 1,
+			"delay":   delay,
+			"error":   err,
+		}).Warn("OpenStack API call failed, retrying")
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// Retryable reports whether the call failed for a reason that
+// might go away on its own (timeouts, 5xx, throttling)
+func Retryable(err error) bool {
+	switch e := err.(type) {
+	case gophercloud.ErrDefault400, gophercloud.ErrDefault401,
+		gophercloud.ErrDefault403, gophercloud.ErrDefault404,
+		gophercloud.ErrDefault405, gophercloud.ErrDefault409:
+		return false
+	case gophercloud.ErrUnexpectedResponseCode:
+		return e.Actual >= http.StatusInternalServerError ||
+			e.Actual == http.StatusTooManyRequests ||
+			e.Actual == http.StatusRequestTimeout
+	}
+	return true
+}
+
+// RequestError reports whether the API rejected the request itself
+// (bad request, unknown object, conflict). The API is healthy then
+func RequestError(err error) bool {
+	switch err.(type) {
+	case gophercloud.ErrDefault400, gophercloud.ErrDefault404,
+		gophercloud.ErrDefault405, gophercloud.ErrDefault409:
+		return true
+	}
+	return false
+}
+
+// backoff returns a random delay in [0, min(MaxBackoff, Backoff * 2^attempt))
+func backoff(opts RetryOptions, attempt int) time.Duration {
+	ceiling := opts.Backoff << uint(attempt)
+	if ceiling <= 0 || (opts.MaxBackoff > 0 && ceiling > opts.MaxBackoff) {
+		ceiling = opts.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		request   bool
+	}{
+		{"timeout", errors.New("i/o timeout"), true, false},
+		{"400", gophercloud.ErrDefault400{}, false, true},
+		{"401", gophercloud.ErrDefault401{}, false, false},
+		{"403", gophercloud.ErrDefault403{}, false, false},
+		{"404", gophercloud.ErrDefault404{}, false, true},
+		{"409", gophercloud.ErrDefault409{}, false, true},
+		{"429", gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusTooManyRequests}, true, false},
+		{"500", gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusInternalServerError}, true, false},
+		{"503", gophercloud.ErrDefault503{ErrUnexpectedResponseCode: gophercloud.ErrUnexpectedResponseCode{Actual: 503}}, true, false},
+		{"422", gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusUnprocessableEntity}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err); got != tt.retryable {
+				t.Errorf("Retryable() = %v, want %v", got, tt.retryable)
+			}
+			if got := RequestError(tt.err); got != tt.request {
+				t.Errorf("RequestError() = %v, want %v", got, tt.request)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	opts := RetryOptions{Retries: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	calls := 0
+	err := Retry(context.Background(), opts, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Retry() = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = Retry(context.Background(), opts, func() error {
+		calls++
+		return gophercloud.ErrDefault404{}
+	})
+	if _, ok := err.(gophercloud.ErrDefault404); !ok || calls != 1 {
+		t.Fatalf("Retry() = %v after %d calls, want 404 without retries", err, calls)
+	}
+
+	calls = 0
+	err = Retry(context.Background(), opts, func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if err == nil || calls != opts.Retries+1 {
+		t.Fatalf("Retry() = %v after %d calls, want failure after %d", err, calls, opts.Retries+1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	Retry(ctx, RetryOptions{Retries: 5, Backoff: time.Hour}, func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+	if calls != 1 {
+		t.Fatalf("Retry() with cancelled context made %d calls, want 1", calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	opts := RetryOptions{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		if d := backoff(opts, attempt); d < 0 || d > time.Second {
+			t.Fatalf("backoff(%d) = %v, want within [0, 1s]", attempt, d)
+		}
+	}
+}
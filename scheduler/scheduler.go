@@ -12,16 +12,88 @@ governing permissions and limitations under the License.
 package scheduler
 
 import (
+	"errors"
+	"ossia/models"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/robfig/cron"
 	log "github.com/sirupsen/logrus"
 )
 
-var scheduler *cron.Cron
+var (
+	scheduler *cron.Cron
+	started   bool
+	tasks     map[string]*task
+	order     []string
+	mu        sync.Mutex
+)
+
+// Scheduler errors
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskRunning  = errors.New("task is already running")
+)
+
+type funcName func() error
+
+// Task describes a periodic task
+type Task struct {
+	// Name is the unique task name
+	Name string
+	// Deployment of the task, empty for global tasks
+	Deployment string
+	// Resource type the task refreshes
+	Resource string
+	// Schedule is the cron spec
+	Schedule string
+	// Run is called on every tick
+	Run funcName
+}
+
+// task is a registered Task with its run statistics
+type task struct {
+	models.ScheduledTask
+	schedule *keptSchedule
+	run      funcName
+	running  bool
+	paused   bool
+}
+
+// keptSchedule keeps the next activation time of the task across
+// cron rebuilds, so @every timers are not restarted by them
+type keptSchedule struct {
+	cron.Schedule
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Next returns the kept activation time while it is ahead
+func (s *keptSchedule) Next(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.next.After(now) {
+		s.next = s.Schedule.Next(now)
+	}
+	return s.next
+}
+
+// job is the cron Job running the named task
+type job struct {
+	name string
+}
 
-type funcName func()
+func (j *job) Run() {
+	t, run, err := begin(j.name, false)
+	if err == nil && t != nil {
+		execute(t, run)
+	}
+}
 
 func init() {
 	scheduler = cron.New()
+	tasks = make(map[string]*task)
 }
 
 // func AddTask(schedule string, task funcName, message string) error {
@@ -32,22 +104,257 @@ func init() {
 // 	return scheduler.AddFunc(schedule, task)
 // }
 
-// AddTask registers a new task
-func AddTask(schedule string, task funcName, log_message log.Fields) error {
-	log_message["schedule"] = schedule
-	log.WithFields(log_message).Info("Scheduled a new task")
-	return scheduler.AddFunc(schedule, task)
+// AddTask registers a new task. A task with the same name is replaced,
+// keeping its statistics and the paused state
+func AddTask(t Task) error {
+	parsed, err := cron.Parse(t.Schedule)
+	if err != nil {
+		return err
+	}
+	schedule := &keptSchedule{Schedule: parsed}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	previous, exists := tasks[t.Name]
+	if exists {
+		previous.run = t.Run
+		if previous.Schedule != t.Schedule {
+			log.WithFields(log.Fields{
+				"task":     t.Name,
+				"schedule": t.Schedule,
+			}).Info("Rescheduled the task")
+			previous.Schedule = t.Schedule
+			previous.schedule = schedule
+			rebuild()
+		}
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"task":       t.Name,
+		"deployment": t.Deployment,
+		"resource":   t.Resource,
+		"schedule":   t.Schedule,
+	}).Info("Scheduled a new task")
+
+	tasks[t.Name] = &task{
+		ScheduledTask: models.ScheduledTask{
+			Name:       t.Name,
+			Deployment: t.Deployment,
+			Resource:   t.Resource,
+			Schedule:   t.Schedule,
+		},
+		schedule: schedule,
+		run:      t.Run,
+	}
+	order = append(order, t.Name)
+	scheduler.Schedule(schedule, &job{name: t.Name})
+	return nil
+}
+
+// ValidateSchedule checks the cron spec
+func ValidateSchedule(spec string) error {
+	_, err := cron.Parse(spec)
+	return err
+}
+
+// RemoveTasks unschedules the tasks of the deployment
+func RemoveTasks(deployment string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	removeTasks(func(t *task) bool { return t.Deployment == deployment })
+}
+
+// RemoveTask unschedules the task
+func RemoveTask(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := tasks[name]; !ok {
+		return ErrTaskNotFound
+	}
+	removeTasks(func(t *task) bool { return t.Name == name })
+	return nil
+}
+
+// removeTasks unschedules the matching tasks. mu must be locked
+func removeTasks(match func(*task) bool) {
+	var kept []string
+	for _, name := range order {
+		if t := tasks[name]; match(t) {
+			log.WithFields(log.Fields{
+				"task":       name,
+				"deployment": t.Deployment,
+			}).Info("Removed the task")
+			delete(tasks, name)
+			continue
+		}
+		kept = append(kept, name)
+	}
+	if len(kept) != len(order) {
+		order = kept
+		rebuild()
+	}
+}
+
+// rebuild replaces the cron with a new one holding registered tasks,
+// as cron entries can not be removed. Tasks in progress are not affected
+// and the next activation times of the kept tasks are preserved
+func rebuild() {
+	previous := scheduler
+	scheduler = cron.New()
+	for _, name := range order {
+		scheduler.Schedule(tasks[name].schedule, &job{name: name})
+	}
+	if started {
+		previous.Stop()
+		scheduler.Start()
+	}
+}
+
+// Tasks returns the status of registered tasks. The next runs come
+// from the kept schedules, not from the cron which may be rebuilt
+func Tasks() []models.ScheduledTask {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	result := make([]models.ScheduledTask, 0, len(order))
+	for _, name := range order {
+		var next time.Time
+		if started {
+			next = tasks[name].schedule.Next(now)
+		}
+		result = append(result, status(tasks[name], next))
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Deployment < result[j].Deployment
+	})
+	return result
+}
+
+// GetTask returns the status of the named task
+func GetTask(name string) (models.ScheduledTask, error) {
+	for _, t := range Tasks() {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return models.ScheduledTask{}, ErrTaskNotFound
+}
+
+// status returns a copy of the task status
+func status(t *task, next time.Time) models.ScheduledTask {
+	s := t.ScheduledTask
+	s.Status = models.TaskScheduled
+	switch {
+	case t.running:
+		s.Status = models.TaskRunning
+	case t.paused:
+		s.Status = models.TaskPaused
+	}
+	if !t.paused {
+		s.NextRun = next
+	}
+	return s
+}
+
+// PauseTask stops periodic runs of the task
+func PauseTask(name string) error {
+	return setPaused(name, true)
+}
+
+// ResumeTask restarts periodic runs of the task
+func ResumeTask(name string) error {
+	return setPaused(name, false)
+}
+
+func setPaused(name string, paused bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	t, ok := tasks[name]
+	if !ok {
+		return ErrTaskNotFound
+	}
+	t.paused = paused
+	log.WithFields(log.Fields{
+		"task":   name,
+		"paused": paused,
+	}).Info("Changed the task state")
+	return nil
+}
+
+// TriggerTask runs the task now, even if it is paused
+func TriggerTask(name string) error {
+	t, run, err := begin(name, true)
+	if err != nil {
+		return err
+	}
+	go execute(t, run)
+	return nil
+}
+
+// begin marks the task as running and returns its function, which
+// may be replaced meanwhile. Paused tasks are only run when triggered.
+// A run is skipped while the previous one is in progress
+func begin(name string, triggered bool) (*task, funcName, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	t, ok := tasks[name]
+	if !ok {
+		return nil, nil, ErrTaskNotFound
+	}
+	if t.paused && !triggered {
+		return nil, nil, nil
+	}
+	if t.running {
+		t.Skipped++
+		log.WithFields(log.Fields{
+			"task": name,
+		}).Warn("Previous run of the task is still in progress, skipping")
+		return nil, nil, ErrTaskRunning
+	}
+	t.running = true
+	t.LastRun = time.Now()
+	return t, t.run, nil
+}
+
+// execute runs the task and records the result
+func execute(t *task, run funcName) {
+	start := time.Now()
+	err := run()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	t.running = false
+	t.Runs++
+	t.LastDuration = time.Since(start).String()
+	t.LastError = ""
+	if err != nil {
+		t.LastError = err.Error()
+	}
 }
 
 // Run starts scheduler
 func Run() {
 	//defer utils.TimeTrack(time.Now(), "Scheduler")
+	mu.Lock()
+	defer mu.Unlock()
+	started = true
 	scheduler.Start()
 	//select {}
 }
 
-// Entries implements view for the scheduled tasks
+// Entries implements view for the scheduled tasks. mu is held
+// so the cron is not stopped by a rebuild meanwhile
 func Entries() []*cron.Entry {
+	mu.Lock()
+	defer mu.Unlock()
 	return scheduler.Entries()
 }
 
@@ -55,6 +362,9 @@ func Entries() []*cron.Entry {
 func Stop() {
 	//defer utils.TimeTrack(time.Now(), "Scheduler")
 	log.Info("Stopping Scheduler...")
+	mu.Lock()
+	defer mu.Unlock()
+	started = false
 	scheduler.Stop()
 	//select {}
 }
@@ -63,6 +373,9 @@ func Stop() {
 func Clean() {
 	//defer utils.TimeTrack(time.Now(), "Scheduler")
 	log.Info("Stopping Scheduler...")
+	mu.Lock()
+	defer mu.Unlock()
+	started = false
 	scheduler.Stop()
 	//select {}
 }
@@ -0,0 +1,250 @@
+/*
+Copyright 2019 Adobe. All rights reserved.
+This file is licensed to you under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy
+of the License at http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software distributed under
+the License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR REPRESENTATIONS
+OF ANY KIND, either express or implied. See the License for the specific language
+governing permissions and limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"errors"
+	"ossia/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+func nextRun(t *testing.T, name string) time.Time {
+	task, err := GetTask(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task.NextRun
+}
+
+// waitRuns waits for the task to complete the number of runs
+func waitRuns(t *testing.T, name string, runs int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		task, _ := GetTask(name)
+		if task.Runs >= runs && task.Status != models.TaskRunning {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not complete %d runs", name, runs)
+}
+
+func TestKeptSchedule(t *testing.T) {
+	every, _ := cron.Parse("@every 1h")
+	s := &keptSchedule{Schedule: every}
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	next := s.Next(now)
+	if !next.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Next() = %v, want %v", next, now.Add(time.Hour))
+	}
+	if got := s.Next(now.Add(40 * time.Minute)); !got.Equal(next) {
+		t.Fatalf("Next() before activation = %v, want kept %v", got, next)
+	}
+	if got := s.Next(next); !got.Equal(next.Add(time.Hour)) {
+		t.Fatalf("Next() at activation = %v, want %v", got, next.Add(time.Hour))
+	}
+}
+
+func TestRebuildKeepsNextRun(t *testing.T) {
+	Run()
+	defer Stop()
+
+	noop := func() error { return nil }
+	err := AddTask(Task{Name: "snapshot", Schedule: "@every 1h", Run: noop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddTask(Task{Name: "lab:images", Deployment: "lab", Schedule: "@every 1h", Run: noop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := nextRun(t, "snapshot")
+	if next.IsZero() {
+		t.Fatal("snapshot task has no next run")
+	}
+
+	// @every schedules are rounded to seconds
+	time.Sleep(1100 * time.Millisecond)
+
+	err = AddTask(Task{Name: "lab:images", Deployment: "lab", Schedule: "@every 2h", Run: noop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nextRun(t, "snapshot"); !got.Equal(next) {
+		t.Fatalf("next run after reschedule = %v, want %v", got, next)
+	}
+	if got := nextRun(t, "lab:images"); got.Sub(time.Now()) < time.Hour+59*time.Minute {
+		t.Fatalf("next run of the rescheduled task = %v, want in 2h", got)
+	}
+
+	RemoveTasks("lab")
+	if got := nextRun(t, "snapshot"); !got.Equal(next) {
+		t.Fatalf("next run after removal = %v, want %v", got, next)
+	}
+	if _, err := GetTask("lab:images"); err != ErrTaskNotFound {
+		t.Fatalf("GetTask() of removed task = %v, want %v", err, ErrTaskNotFound)
+	}
+
+	if err := RemoveTask("snapshot"); err != nil {
+		t.Fatalf("RemoveTask() = %v", err)
+	}
+	if err := RemoveTask("snapshot"); err != ErrTaskNotFound {
+		t.Fatalf("RemoveTask() of removed task = %v, want %v", err, ErrTaskNotFound)
+	}
+}
+
+func TestTriggerTask(t *testing.T) {
+	failure := errors.New("API unavailable")
+	var (
+		runsMu sync.Mutex
+		runs   int
+	)
+	run := func() error {
+		runsMu.Lock()
+		defer runsMu.Unlock()
+		runs++
+		return failure
+	}
+	err := AddTask(Task{Name: "report", Schedule: "@weekly", Run: run})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PauseTask("report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = TriggerTask("report")
+	if err != nil {
+		t.Fatalf("TriggerTask() of paused task = %v", err)
+	}
+	waitRuns(t, "report", 1)
+
+	task, _ := GetTask("report")
+	if task.LastError != failure.Error() || task.Status != models.TaskPaused || !task.NextRun.IsZero() {
+		t.Fatalf("task = %+v, want paused with the last error", task)
+	}
+
+	// The replaced function is used by the next run
+	replaced := make(chan bool, 1)
+	err = AddTask(Task{Name: "report", Schedule: "@weekly", Run: func() error {
+		replaced <- true
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	TriggerTask("report")
+	select {
+	case <-replaced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replaced task function was not run")
+	}
+	waitRuns(t, "report", 2)
+	if task, _ := GetTask("report"); task.LastError != "" || task.Runs != 2 {
+		t.Fatalf("task = %+v, want 2 runs without error", task)
+	}
+
+	if err := TriggerTask("missing"); err != ErrTaskNotFound {
+		t.Fatalf("TriggerTask() of unknown task = %v, want %v", err, ErrTaskNotFound)
+	}
+	if err := AddTask(Task{Name: "invalid", Schedule: "every day"}); err == nil {
+		t.Fatal("AddTask() with invalid schedule succeeded")
+	}
+}
+
+func TestReplaceWhileRunning(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	err := AddTask(Task{Name: "slow", Schedule: "@weekly", Run: func() error {
+		started <- true
+		<-release
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	TriggerTask("slow")
+	<-started
+
+	if err := TriggerTask("slow"); err != ErrTaskRunning {
+		t.Fatalf("TriggerTask() while running = %v, want %v", err, ErrTaskRunning)
+	}
+	err = AddTask(Task{Name: "slow", Schedule: "@daily", Run: func() error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	waitRuns(t, "slow", 1)
+
+	task, _ := GetTask("slow")
+	if task.Skipped != 1 || task.Schedule != "@daily" {
+		t.Fatalf("task = %+v, want one skipped run and the new schedule", task)
+	}
+}
+
+func TestTasksDuringRebuild(t *testing.T) {
+	Run()
+	defer Stop()
+
+	noop := func() error { return nil }
+	if err := AddTask(Task{Name: "reload", Schedule: "@every 1h", Run: noop}); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveTask("reload")
+
+	// Readers run while the reschedules rebuild the cron
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					Tasks()
+					Entries()
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		schedule := "@every 1h"
+		if i%2 == 0 {
+			schedule = "@every 2h"
+		}
+		AddTask(Task{Name: "reload", Schedule: schedule, Run: noop})
+	}
+	close(stop)
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tasks() blocked during the cron rebuild")
+	}
+	if nextRun(t, "reload").IsZero() {
+		t.Fatal("reload task has no next run")
+	}
+}